@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"minibb/internal/models"
+)
+
+const banUsage = `add -ip <address> [-reason text] [-duration d] | list | lift <id>`
+
+func runBan(args []string) int {
+	action, args := subcommand(args)
+	switch action {
+	case "add":
+		return runBanAdd(args)
+	case "list":
+		return runBanList(args)
+	case "lift":
+		return runBanLift(args)
+	}
+
+	newFlagSet("ban", banUsage).Usage()
+	return 2
+}
+
+func runBanAdd(args []string) int {
+	flags := newFlagSet("ban add", "-ip <address> [-reason text] [-duration d]")
+	ip := flags.String("ip", "", "IP address to ban")
+	reason := flags.String("reason", "", "reason shown to the banned user")
+	duration := flags.Duration("duration", 0, "ban duration (0 = permanent)")
+	flags.Parse(args)
+
+	if net.ParseIP(*ip) == nil {
+		fmt.Fprintln(os.Stderr, "invalid IP address")
+		return 2
+	}
+	if *duration < 0 {
+		fmt.Fprintln(os.Stderr, "invalid ban duration")
+		return 2
+	}
+
+	database := initDB()
+	defer database.Close()
+
+	tx := beginAction(database)
+	ban, err := models.CreateBan(context.Background(), tx, *ip, *reason, "cli", *duration)
+	if err != nil {
+		log.Fatal("Failed to create ban:", err)
+	}
+	recordAction(tx, "ban.create", "ban", ban.ID, nil, nil, ban)
+	commitAction(tx)
+
+	fmt.Printf("Banned %s (ban %d)\n", ban.IP, ban.ID)
+	return 0
+}
+
+func runBanList(args []string) int {
+	flags := newFlagSet("ban list", "")
+	flags.Parse(args)
+
+	database := initDB()
+	defer database.Close()
+
+	bans, err := models.GetActiveBans(context.Background(), database)
+	if err != nil {
+		log.Fatal("Failed to list bans:", err)
+	}
+
+	for _, ban := range bans {
+		expires := "never"
+		if ban.ExpiresAt != nil {
+			expires = ban.ExpiresAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%4d  %-39s  expires %-25s  %s\n", ban.ID, ban.IP, expires, ban.Reason)
+	}
+	return 0
+}
+
+func runBanLift(args []string) int {
+	flags := newFlagSet("ban lift", "<id>")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	banID, err := strconv.Atoi(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid ban ID")
+		return 2
+	}
+
+	database := initDB()
+	defer database.Close()
+
+	ban, err := models.GetBanByID(context.Background(), database, banID)
+	if err != nil {
+		log.Fatal("Failed to look up ban:", err)
+	}
+	if ban == nil {
+		fmt.Fprintf(os.Stderr, "ban %d not found\n", banID)
+		return 1
+	}
+
+	tx := beginAction(database)
+	if err := models.LiftBan(context.Background(), tx, ban.ID); err != nil {
+		log.Fatal("Failed to lift ban:", err)
+	}
+
+	updated, err := models.GetBanByID(context.Background(), tx, ban.ID)
+	if err != nil {
+		log.Fatal("Failed to look up ban:", err)
+	}
+	recordAction(tx, "ban.lift", "ban", ban.ID, nil, ban, updated)
+	commitAction(tx)
+
+	fmt.Printf("Lifted ban %d\n", ban.ID)
+	return 0
+}
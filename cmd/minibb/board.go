@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"minibb/internal/models"
+)
+
+const boardUsage = `create <slug> <description> | list | edit [flags] <slug>`
+
+func runBoard(args []string) int {
+	action, args := subcommand(args)
+	switch action {
+	case "create":
+		return runBoardCreate(args)
+	case "list":
+		return runBoardList(args)
+	case "edit":
+		return runBoardEdit(args)
+	}
+
+	newFlagSet("board", boardUsage).Usage()
+	return 2
+}
+
+func runBoardCreate(args []string) int {
+	flags := newFlagSet("board create", "<slug> <description>")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	database := initDB()
+	defer database.Close()
+
+	slug := flags.Arg(0)
+	existing, err := models.GetBoardBySlug(context.Background(), database, slug)
+	if err != nil {
+		log.Fatal("Failed to look up board:", err)
+	}
+	if existing != nil {
+		fmt.Fprintf(os.Stderr, "board %q already exists\n", slug)
+		return 1
+	}
+
+	tx := beginAction(database)
+	board, err := models.CreateBoard(context.Background(), tx, slug, flags.Arg(1))
+	if err != nil {
+		log.Fatal("Failed to create board:", err)
+	}
+	recordAction(tx, "board.create", "board", board.ID, &board.ID, nil, board)
+	commitAction(tx)
+
+	fmt.Printf("Created board /%s/ (id %d)\n", board.Slug, board.ID)
+	return 0
+}
+
+func runBoardList(args []string) int {
+	flags := newFlagSet("board list", "")
+	flags.Parse(args)
+
+	database := initDB()
+	defer database.Close()
+
+	boards, err := models.GetAllBoards(context.Background(), database)
+	if err != nil {
+		log.Fatal("Failed to list boards:", err)
+	}
+
+	for _, board := range boards {
+		settings, err := models.GetBoardSettings(context.Background(), database, board.ID)
+		if err != nil {
+			log.Fatal("Failed to load board settings:", err)
+		}
+
+		var flagNames []string
+		if settings.ReadOnly {
+			flagNames = append(flagNames, "read-only")
+		}
+		if settings.Premoderate {
+			flagNames = append(flagNames, "premoderate")
+		}
+		if settings.PowDifficulty > 0 {
+			flagNames = append(flagNames, fmt.Sprintf("pow=%d", settings.PowDifficulty))
+		}
+
+		fmt.Printf("%4d  /%s/  %s", board.ID, board.Slug, board.Description)
+		if len(flagNames) > 0 {
+			fmt.Printf("  [%s]", strings.Join(flagNames, ", "))
+		}
+		fmt.Println()
+	}
+	return 0
+}
+
+func runBoardEdit(args []string) int {
+	flags := newFlagSet("board edit", "[flags] <slug>")
+	description := flags.String("description", "", "board description")
+	readOnly := flags.Bool("read-only", false, "reject new topics and posts")
+	defaultName := flags.String("default-name", "", "author name for anonymous posts")
+	maxTitleLength := flags.Int("max-title-length", 0, "maximum topic title length")
+	maxBodyLength := flags.Int("max-body-length", 0, "maximum post length")
+	maxActiveThreads := flags.Int("max-active-threads", 0, "archive topics beyond this count (0 = unlimited)")
+	bumpLimit := flags.Int("bump-limit", 0, "replies after which a topic stops bumping (0 = unlimited)")
+	markupExtensions := flags.String("markup-extensions", "", "comma-separated markdown extensions")
+	powDifficulty := flags.Int("pow-difficulty", 0, "proof-of-work difficulty in bits (0 = disabled)")
+	premoderate := flags.Bool("premoderate", false, "hold new posts for review")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	database := initDB()
+	defer database.Close()
+
+	board, err := models.GetBoardBySlug(context.Background(), database, flags.Arg(0))
+	if err != nil {
+		log.Fatal("Failed to look up board:", err)
+	}
+	if board == nil {
+		fmt.Fprintf(os.Stderr, "board %q not found\n", flags.Arg(0))
+		return 1
+	}
+
+	settings, err := models.GetBoardSettings(context.Background(), database, board.ID)
+	if err != nil {
+		log.Fatal("Failed to load board settings:", err)
+	}
+
+	// Only flags given on the command line are applied.
+	changedBoard := *board
+	changedSettings := *settings
+	boardChanged, settingsChanged := false, false
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "description":
+			changedBoard.Description = *description
+			boardChanged = true
+			return
+		case "read-only":
+			changedSettings.ReadOnly = *readOnly
+		case "default-name":
+			changedSettings.DefaultName = *defaultName
+		case "max-title-length":
+			changedSettings.MaxTitleLength = *maxTitleLength
+		case "max-body-length":
+			changedSettings.MaxBodyLength = *maxBodyLength
+		case "max-active-threads":
+			changedSettings.MaxActiveThreads = *maxActiveThreads
+		case "bump-limit":
+			changedSettings.BumpLimit = *bumpLimit
+		case "markup-extensions":
+			changedSettings.MarkupExtensions = nil
+			for _, name := range strings.Split(*markupExtensions, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					changedSettings.MarkupExtensions = append(changedSettings.MarkupExtensions, name)
+				}
+			}
+		case "pow-difficulty":
+			changedSettings.PowDifficulty = *powDifficulty
+		case "premoderate":
+			changedSettings.Premoderate = *premoderate
+		}
+		settingsChanged = true
+	})
+
+	if !boardChanged && !settingsChanged {
+		fmt.Fprintln(os.Stderr, "nothing to change")
+		flags.Usage()
+		return 2
+	}
+
+	if settingsChanged {
+		if err := changedSettings.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	tx := beginAction(database)
+	if boardChanged {
+		if err := models.UpdateBoard(context.Background(), tx, changedBoard); err != nil {
+			log.Fatal("Failed to update board:", err)
+		}
+		recordAction(tx, "board.update", "board", board.ID, &board.ID, board, changedBoard)
+	}
+	if settingsChanged {
+		if err := models.SaveBoardSettings(context.Background(), tx, changedSettings); err != nil {
+			log.Fatal("Failed to update board settings:", err)
+		}
+		recordAction(tx, "board.settings", "board", board.ID, &board.ID, settings, changedSettings)
+	}
+	commitAction(tx)
+
+	fmt.Printf("Updated board /%s/\n", board.Slug)
+	return 0
+}
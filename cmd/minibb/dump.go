@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"minibb/internal/db"
+	"minibb/internal/dump"
+)
+
+func runExport(args []string) int {
+	flags := newFlagSet("export", "[-o file]")
+	output := flags.String("o", "", "write the export to `file` instead of stdout")
+	flags.Parse(args)
+
+	database := initDB()
+	defer database.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			log.Fatal("Failed to create export file:", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	stats, err := dump.Export(database, w, reportProgress("Exported"))
+	if err != nil {
+		log.Fatal("Export failed:", err)
+	}
+	fmt.Fprintf(os.Stderr, "\nExported %d records\n", stats.Total())
+	return 0
+}
+
+func runImport(args []string) int {
+	flags := newFlagSet("import", "<file>")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	var r io.Reader = os.Stdin
+	if flags.Arg(0) != "-" {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			log.Fatal("Failed to open import file:", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	database := initDB()
+	defer database.Close()
+
+	stats, err := dump.Import(database, r, reportProgress("Imported"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr)
+		log.Fatal("Import failed:", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nImported %d records\n", stats.Total())
+	return 0
+}
+
+// reportProgress prints a status line to stderr that is overwritten on
+// every update, keeping stdout free for the export itself.
+func reportProgress(verb string) dump.Progress {
+	return func(stats dump.Stats) {
+		fmt.Fprintf(os.Stderr, "\r%s %d boards, %d filters, %d bans, %d topics, %d posts, %d redirects",
+			verb, stats.Boards, stats.Filters, stats.Bans, stats.Topics, stats.Posts, stats.Redirects)
+	}
+}
+
+func runBackup(args []string) int {
+	flags := newFlagSet("backup", "<path>")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	if err := db.Backup(loadConfig().Database, flags.Arg(0)); err != nil {
+		log.Fatal("Backup failed:", err)
+	}
+	if err := db.CheckIntegrity(flags.Arg(0)); err != nil {
+		log.Fatal("Backup is unusable:", err)
+	}
+
+	fmt.Printf("Wrote backup to %s\n", flags.Arg(0))
+	return 0
+}
+
+func runRestore(args []string) int {
+	flags := newFlagSet("restore", "<backup>")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	previous, err := db.Restore(loadConfig().Database, flags.Arg(0))
+	if err != nil {
+		log.Fatal("Restore failed:", err)
+	}
+	if previous != "" {
+		fmt.Printf("Previous database kept at %s\n", previous)
+	}
+
+	// Bring an older backup up to the current schema right away so
+	// problems surface now rather than at the next server start.
+	database := initDB()
+	database.Close()
+
+	fmt.Printf("Restored %s\n", flags.Arg(0))
+	return 0
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"minibb/internal/fsck"
+)
+
+func runFsck(args []string) int {
+	flags := newFlagSet("fsck", "[-fix]")
+	fix := flags.Bool("fix", false, "repair all discrepancies in a single transaction")
+	flags.Parse(args)
+
+	database := initDB()
+	defer database.Close()
+
+	var report *fsck.Report
+	var err error
+	if *fix {
+		tx := beginAction(database)
+		defer tx.Rollback()
+		if report, err = fsck.RunTx(tx, true); err != nil {
+			log.Fatal("Consistency check failed:", err)
+		}
+		if !report.OK() {
+			recordAction(tx, "fsck.repair", "database", 0, nil, nil, report.Summary())
+		}
+		commitAction(tx)
+		report.Fixed = true
+	} else if report, err = fsck.Run(database, false); err != nil {
+		log.Fatal("Consistency check failed:", err)
+	}
+
+	for _, d := range report.Discrepancies {
+		fmt.Printf("%s: %s %d: expected %v, found %v\n",
+			d.Check, d.TargetType, d.TargetID, d.Expected, d.Actual)
+	}
+
+	switch {
+	case report.OK():
+		fmt.Println("No discrepancies found")
+		return 0
+	case report.Fixed:
+		fmt.Printf("Fixed %d discrepancies\n", len(report.Discrepancies))
+		return 0
+	default:
+		fmt.Printf("Found %d discrepancies, run with -fix to repair them\n", len(report.Discrepancies))
+		return 1
+	}
+}
@@ -2,41 +2,252 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"minibb/internal/config"
 	"minibb/internal/db"
+	"minibb/internal/logging"
+	"minibb/internal/models"
 	"minibb/internal/server"
+	"minibb/internal/tracing"
 )
 
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "start the forum server (default)", runServe},
+		{"migrate", "show, apply or revert database migrations", runMigrate},
+		{"board", "create, list and edit boards", runBoard},
+		{"ban", "add, list and lift IP bans", runBan},
+		{"topic", "lock, move and delete topics", runTopic},
+		{"fsck", "check and repair denormalized data", runFsck},
+		{"export", "export the forum as JSON Lines", runExport},
+		{"import", "import a JSON Lines export into an empty database", runImport},
+		{"import-phpbb", "import a phpBB 3 forum from a MySQL dump or SQLite file", runImportPhpBB},
+		{"backup", "write a consistent snapshot of the database", runBackup},
+		{"restore", "replace the database with a verified backup", runRestore},
+		{"config", "print the effective configuration", runConfig},
+	}
+}
+
 func main() {
-	// Initialize database
-	db, err := db.Init()
+	if len(os.Args) < 2 {
+		os.Exit(runServe(nil))
+	}
+
+	name := os.Args[1]
+	for _, cmd := range commands {
+		if cmd.name == name {
+			os.Exit(cmd.run(os.Args[2:]))
+		}
+	}
+
+	if name != "help" && name != "-h" && name != "--help" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: minibb <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	width := 0
+	for _, cmd := range commands {
+		width = max(width, len(cmd.name))
+	}
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-*s  %s\n", width, cmd.name, cmd.summary)
+	}
+}
+
+// settings is the configuration of the running command. Commands that
+// take configuration flags load it while parsing them; the others read
+// the file named by MINIBB_CONFIG and the environment on first use.
+var settings *config.Config
+
+func loadConfig() config.Config {
+	if settings == nil {
+		c, err := config.Load("", nil)
+		if err != nil {
+			log.Fatal("Invalid configuration:", err)
+		}
+		settings = &c
+	}
+	return *settings
+}
+
+// parseWithConfig parses a command's flags including the configuration
+// flags and loads the configuration they select. The flags are returned
+// for reloading it later.
+func parseWithConfig(flags *flag.FlagSet, args []string) (config.Config, *config.Flags) {
+	configFlags := config.AddFlags(flags)
+	flags.Parse(args)
+
+	c, err := configFlags.Load()
+	if err != nil {
+		log.Fatal("Invalid configuration:", err)
+	}
+	settings = &c
+	return c, configFlags
+}
+
+// initDB opens the database and applies pending migrations. Every command
+// goes through here so they all see the same configuration.
+func initDB() *sql.DB {
+	database, err := db.Init(loadConfig().Database)
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
-	defer db.Close()
+	return database
+}
+
+// newFlagSet creates the flag set of a subcommand with a usage line
+// describing its positional arguments.
+func newFlagSet(name, arguments string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: minibb %s %s\n", name, arguments)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+func runServe(args []string) int {
+	c, configFlags := parseWithConfig(newFlagSet("serve", "[flags]"), args)
+
+	logConfig, _ := c.Logging()
+	logging.Setup(logConfig)
+
+	shutdownTracing := tracing.Setup(c.Tracing)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Println("Failed to flush traces:", err)
+		}
+	}()
+
+	// Initialize database
+	database := initDB()
+	defer database.Close()
+
+	reader, err := db.OpenReader(c.Database)
+	if err != nil {
+		log.Fatal("Failed to open read pool:", err)
+	}
+	defer reader.Close()
 
 	// Create server
-	srv := server.New(db, nil)
+	srv, err := server.New(c, database, reader, nil)
+	if err != nil {
+		log.Fatal("Failed to create server:", err)
+	}
 
 	// Start server
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Handle graceful shutdown
+	// Handle graceful shutdown, and reload the configuration on SIGHUP
 	go func() {
 		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-		<-sigChan
-		log.Println("Shutting down server...")
-		cancel()
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		for sig := range sigChan {
+			if sig == syscall.SIGHUP {
+				log.Println("Reloading configuration...")
+				next, err := configFlags.Load()
+				if err != nil {
+					logging.For(logging.Server).Error("keeping current configuration", "error", err)
+					continue
+				}
+				srv.Reload(next)
+				continue
+			}
+			log.Println("Shutting down server...")
+			cancel()
+			return
+		}
 	}()
 
 	log.Println("Starting MiniBB server...")
 	if err := srv.Start(ctx); err != nil {
 		log.Fatal("Server error:", err)
 	}
+	return 0
+}
+
+func runConfig(args []string) int {
+	action, args := subcommand(args)
+	if action != "print" {
+		newFlagSet("config", "print [flags]").Usage()
+		return 2
+	}
+
+	c, _ := parseWithConfig(newFlagSet("config print", "[flags]"), args)
+	if err := c.Write(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+	return 0
+}
+
+// beginAction starts the transaction that a change made from the command
+// line and its mod log entry are written in. Exiting before commitAction
+// discards both.
+func beginAction(database *sql.DB) *sql.Tx {
+	tx, err := database.Begin()
+	if err != nil {
+		log.Fatal("Failed to begin transaction:", err)
+	}
+	return tx
+}
+
+func commitAction(tx *sql.Tx) {
+	if err := tx.Commit(); err != nil {
+		log.Fatal("Failed to commit:", err)
+	}
+}
+
+// recordAction writes a mod log entry for a change made from the command
+// line. The actor is the local user so CLI changes remain attributable.
+func recordAction(database models.Querier, action, targetType string, targetID int, boardID *int, before, after interface{}) {
+	actor := "cli"
+	if user := os.Getenv("USER"); user != "" {
+		actor = "cli:" + user
+	}
+
+	entry := models.ModLogEntry{
+		Actor:      actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		BoardID:    boardID,
+		IP:         "local",
+	}
+	if err := models.RecordModAction(context.Background(), database, entry, before, after); err != nil {
+		log.Fatal("Failed to write mod log:", err)
+	}
+}
+
+// subcommand splits args into an action name and its arguments.
+func subcommand(args []string) (string, []string) {
+	if len(args) == 0 {
+		return "", nil
+	}
+	return args[0], args[1:]
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"minibb/internal/db"
+)
+
+const migrateUsage = `status | up [-dry-run] | to [-dry-run] <version> | down [-dry-run] <count>`
+
+func runMigrate(args []string) int {
+	action, args := subcommand(args)
+	switch action {
+	case "status":
+		return runMigrateStatus(args)
+	case "up", "to":
+		return runMigrateUp(action, args)
+	case "down":
+		return runMigrateDown(args)
+	}
+
+	newFlagSet("migrate", migrateUsage).Usage()
+	return 2
+}
+
+// openForMigration opens the database without migrating it, since the
+// migrate commands manage the schema explicitly.
+func openForMigration() *sql.DB {
+	database, err := db.Open(loadConfig().Database)
+	if err != nil {
+		log.Fatal("Failed to open database:", err)
+	}
+	return database
+}
+
+func runMigrateStatus(args []string) int {
+	flags := newFlagSet("migrate status", "")
+	flags.Parse(args)
+
+	database := openForMigration()
+	defer database.Close()
+
+	migrations, err := db.Migrations(database)
+	if err != nil {
+		log.Fatal("Failed to read migrations:", err)
+	}
+
+	modified := false
+	for _, m := range migrations {
+		state := "pending"
+		if m.Applied {
+			state = "applied " + m.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		if m.Modified {
+			state += " (checksum mismatch)"
+			modified = true
+		}
+		down := ""
+		if m.Reversible {
+			down = "down"
+		}
+		fmt.Printf("%04d  %-40s %-4s  %s\n", m.Number, m.Filename, down, state)
+	}
+
+	if modified {
+		return 1
+	}
+	return 0
+}
+
+func runMigrateUp(action string, args []string) int {
+	arguments := "[-dry-run]"
+	if action == "to" {
+		arguments += " <version>"
+	}
+	flags := newFlagSet("migrate "+action, arguments)
+	dryRun := flags.Bool("dry-run", false, "print the pending SQL without applying it")
+	flags.Parse(args)
+
+	target := 0
+	if action == "to" {
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return 2
+		}
+		var err error
+		target, err = strconv.Atoi(flags.Arg(0))
+		if err != nil || target <= 0 {
+			fmt.Fprintln(os.Stderr, "migration version must be a positive number")
+			return 2
+		}
+	} else if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	database := openForMigration()
+	defer database.Close()
+
+	if *dryRun {
+		if err := db.VerifyChecksums(database); err != nil {
+			log.Fatal("Migration failed:", err)
+		}
+		pending, err := db.PendingMigrations(database, target)
+		if err != nil {
+			log.Fatal("Failed to read migrations:", err)
+		}
+		for _, m := range pending {
+			fmt.Printf("-- %s\n%s\n", m.Filename, m.UpScript())
+		}
+		return 0
+	}
+
+	if err := db.MigrateTo(database, target); err != nil {
+		log.Fatal("Migration failed:", err)
+	}
+	return 0
+}
+
+func runMigrateDown(args []string) int {
+	flags := newFlagSet("migrate down", "[-dry-run] <count>")
+	dryRun := flags.Bool("dry-run", false, "print the SQL without reverting anything")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	steps, err := strconv.Atoi(flags.Arg(0))
+	if err != nil || steps <= 0 {
+		fmt.Fprintln(os.Stderr, "count must be a positive number")
+		return 2
+	}
+
+	database := openForMigration()
+	defer database.Close()
+
+	if *dryRun {
+		if err := db.VerifyChecksums(database); err != nil {
+			log.Fatal("Migration failed:", err)
+		}
+		revert, err := db.RevertibleMigrations(database, steps)
+		if err != nil {
+			log.Fatal("Migration failed:", err)
+		}
+		for _, m := range revert {
+			fmt.Printf("-- %s (down)\n%s\n", m.Filename, m.DownScript())
+		}
+		return 0
+	}
+
+	if err := db.MigrateDown(database, steps); err != nil {
+		log.Fatal("Migration failed:", err)
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"minibb/internal/phpbb"
+)
+
+func runImportPhpBB(args []string) int {
+	flags := newFlagSet("import-phpbb", "[-prefix phpbb_] [-base-path /forum] <mysql dump or sqlite file>")
+	prefix := flags.String("prefix", "phpbb_", "phpBB table prefix")
+	basePath := flags.String("base-path", "", "URL path phpBB was served under, for redirects")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	src, err := phpbb.OpenSource(flags.Arg(0))
+	if err != nil {
+		log.Fatal("Failed to open phpBB database:", err)
+	}
+	defer src.Close()
+
+	database := initDB()
+	defer database.Close()
+
+	stats, err := phpbb.Import(database, src, phpbb.Options{
+		Prefix:   *prefix,
+		BasePath: *basePath,
+		Progress: func(stats phpbb.Stats) {
+			fmt.Fprintf(os.Stderr, "\rImported %d boards, %d topics, %d posts",
+				stats.Boards, stats.Topics, stats.Posts)
+		},
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		log.Fatal("Import failed:", err)
+	}
+	recordAction(database, "import.phpbb", "database", 0, nil, nil, stats)
+
+	fmt.Printf("Imported %d boards, %d topics and %d posts, skipped %d records\n",
+		stats.Boards, stats.Topics, stats.Posts, stats.Skipped)
+	fmt.Println("Old phpBB URLs now redirect to the imported boards, topics and posts")
+	return 0
+}
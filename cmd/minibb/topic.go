@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"minibb/internal/models"
+)
+
+const topicUsage = `lock <id> | unlock <id> | move <id> <board> | delete <id>`
+
+func runTopic(args []string) int {
+	action, args := subcommand(args)
+	switch action {
+	case "lock", "unlock":
+		return runTopicStatus(action, args)
+	case "move":
+		return runTopicMove(args)
+	case "delete":
+		return runTopicDelete(args)
+	}
+
+	newFlagSet("topic", topicUsage).Usage()
+	return 2
+}
+
+// loadTopicArg resolves a topic ID given on the command line. It returns
+// nil after printing an error if the topic cannot be found.
+func loadTopicArg(database *sql.DB, arg string) *models.Topic {
+	topicID, err := strconv.Atoi(arg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid topic ID")
+		return nil
+	}
+
+	topic, err := models.GetTopicByID(context.Background(), database, topicID)
+	if err != nil {
+		log.Fatal("Failed to look up topic:", err)
+	}
+	if topic == nil {
+		fmt.Fprintf(os.Stderr, "topic %d not found\n", topicID)
+	}
+	return topic
+}
+
+func runTopicStatus(action string, args []string) int {
+	flags := newFlagSet("topic "+action, "<id>")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	database := initDB()
+	defer database.Close()
+
+	topic := loadTopicArg(database, flags.Arg(0))
+	if topic == nil {
+		return 1
+	}
+
+	status := "locked"
+	if action == "unlock" {
+		status = "open"
+	}
+	tx := beginAction(database)
+	if err := models.SetTopicStatus(context.Background(), tx, topic.ID, status); err != nil {
+		log.Fatal("Failed to update topic:", err)
+	}
+
+	updated, err := models.GetTopicByID(context.Background(), tx, topic.ID)
+	if err != nil {
+		log.Fatal("Failed to look up topic:", err)
+	}
+	recordAction(tx, "topic."+action, "topic", topic.ID, &topic.BoardID, topic, updated)
+	commitAction(tx)
+
+	fmt.Printf("Topic %d is now %s\n", topic.ID, status)
+	return 0
+}
+
+func runTopicMove(args []string) int {
+	flags := newFlagSet("topic move", "<id> <board>")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	database := initDB()
+	defer database.Close()
+
+	topic := loadTopicArg(database, flags.Arg(0))
+	if topic == nil {
+		return 1
+	}
+
+	board, err := models.GetBoardBySlug(context.Background(), database, flags.Arg(1))
+	if err != nil {
+		log.Fatal("Failed to look up board:", err)
+	}
+	if board == nil {
+		fmt.Fprintf(os.Stderr, "board %q not found\n", flags.Arg(1))
+		return 1
+	}
+
+	tx := beginAction(database)
+	if err := models.MoveTopic(context.Background(), tx, topic.ID, board.ID); err != nil {
+		log.Fatal("Failed to move topic:", err)
+	}
+
+	updated, err := models.GetTopicByID(context.Background(), tx, topic.ID)
+	if err != nil {
+		log.Fatal("Failed to look up topic:", err)
+	}
+	recordAction(tx, "topic.move", "topic", topic.ID, &board.ID, topic, updated)
+	commitAction(tx)
+
+	fmt.Printf("Moved topic %d to /%s/\n", topic.ID, board.Slug)
+	return 0
+}
+
+func runTopicDelete(args []string) int {
+	flags := newFlagSet("topic delete", "<id>")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	database := initDB()
+	defer database.Close()
+
+	topic := loadTopicArg(database, flags.Arg(0))
+	if topic == nil {
+		return 1
+	}
+
+	tx := beginAction(database)
+	if err := models.DeleteTopic(context.Background(), tx, topic.ID); err != nil {
+		log.Fatal("Failed to delete topic:", err)
+	}
+	recordAction(tx, "topic.delete", "topic", topic.ID, &topic.BoardID, topic, nil)
+	commitAction(tx)
+
+	fmt.Printf("Deleted topic %d\n", topic.ID)
+	return 0
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"minibb/internal/utils"
+)
+
+type Admin struct {
+	Label string `json:"label"`
+}
+
+type adminToken struct {
+	label string
+	token string
+}
+
+// Tokens holds the configured admin tokens. Tokens are configured as a
+// comma separated list of label:token pairs.
+type Tokens struct {
+	mu      sync.RWMutex
+	entries []adminToken
+}
+
+func ParseTokens(spec string) (*Tokens, error) {
+	tokens := &Tokens{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		label, token, ok := strings.Cut(pair, ":")
+		if !ok || label == "" || token == "" {
+			return nil, fmt.Errorf("admin token entry should be in format label:token")
+		}
+		tokens.entries = append(tokens.entries, adminToken{label: label, token: token})
+	}
+	return tokens, nil
+}
+
+// Replace swaps in the tokens of other, so that a reloaded token list
+// takes effect for middleware already holding t.
+func (t *Tokens) Replace(other *Tokens) {
+	other.mu.RLock()
+	entries := other.entries
+	other.mu.RUnlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = entries
+}
+
+func (t *Tokens) Lookup(token string) (*Admin, bool) {
+	if t == nil || token == "" {
+		return nil, false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var found *Admin
+	for _, entry := range t.entries {
+		if subtle.ConstantTimeCompare([]byte(entry.token), []byte(token)) == 1 {
+			found = &Admin{Label: entry.label}
+		}
+	}
+	return found, found != nil
+}
+
+type contextKey string
+
+const adminContextKey contextKey = "admin"
+
+func WithAdmin(ctx context.Context, admin *Admin) context.Context {
+	return context.WithValue(ctx, adminContextKey, admin)
+}
+
+// FromContext returns the authenticated admin or nil for regular readers.
+func FromContext(ctx context.Context) *Admin {
+	admin, _ := ctx.Value(adminContextKey).(*Admin)
+	return admin
+}
+
+func IsAdmin(ctx context.Context) bool {
+	return FromContext(ctx) != nil
+}
+
+// Middleware attaches the admin to the request context if the request
+// carries a valid bearer token. Requests without a token pass through.
+func Middleware(tokens *Tokens) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			admin, ok := tokens.Lookup(strings.TrimPrefix(header, "Bearer "))
+			if !ok {
+				utils.RespondWithError(w, http.StatusUnauthorized, utils.APIError{Detail: "invalid admin token"})
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithAdmin(r.Context(), admin)))
+		})
+	}
+}
+
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsAdmin(r.Context()) {
+			utils.RespondWithError(w, http.StatusUnauthorized, utils.APIError{Detail: "admin token required"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,412 @@
+// Package config loads the server configuration. Values come from the
+// defaults, an optional TOML or YAML file, environment variables and command-line
+// flags, each overriding the ones before.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"minibb/internal/auth"
+	"minibb/internal/db"
+	"minibb/internal/logging"
+	"minibb/internal/pow"
+	"minibb/internal/tracing"
+	"minibb/internal/utils"
+)
+
+// Config is the complete configuration. Every setting has a key such as
+// server.port, formed from the toml tags, under which it is set in the
+// file and on the command line; most also have an environment variable.
+// Settings tagged secret are redacted when printed, and those tagged
+// reload can be changed without a restart.
+type Config struct {
+	Server   Server         `toml:"server"`
+	Database db.Config      `toml:"database"`
+	Limits   Limits         `toml:"limits"`
+	Cache    Cache          `toml:"cache"`
+	Security Security       `toml:"security"`
+	Admin    Admin          `toml:"admin"`
+	PoW      PoW            `toml:"pow"`
+	Jobs     Jobs           `toml:"jobs"`
+	Log      Log            `toml:"log"`
+	Tracing  tracing.Config `toml:"tracing"`
+}
+
+type Server struct {
+	Port string `toml:"port" env:"PORT"`
+	// Listen overrides Port with a TCP address, "unix:" followed by a
+	// socket path, or "systemd" for the sockets passed in LISTEN_FDS.
+	Listen string `toml:"listen" env:"LISTEN"`
+	// SocketMode is the octal file mode of a Unix socket.
+	SocketMode string `toml:"socket_mode" env:"SOCKET_MODE"`
+	// TLSCert and TLSKey enable TLS. The files are reloaded when they
+	// change.
+	TLSCert           string        `toml:"tls_cert" env:"TLS_CERT"`
+	TLSKey            string        `toml:"tls_key" env:"TLS_KEY"`
+	ReadHeaderTimeout time.Duration `toml:"read_header_timeout" env:"READ_HEADER_TIMEOUT"`
+	IdleTimeout       time.Duration `toml:"idle_timeout" env:"IDLE_TIMEOUT"`
+	MaxHeaderBytes    int           `toml:"max_header_bytes" env:"MAX_HEADER_BYTES"`
+	// Compression encodes responses with brotli or gzip for clients that
+	// accept it, if they are at least CompressMinBytes long.
+	Compression      bool `toml:"compression" env:"COMPRESSION"`
+	CompressMinBytes int  `toml:"compress_min_bytes" env:"COMPRESS_MIN_BYTES"`
+	// TrustedProxies are the networks and addresses, or "unix" for the
+	// Unix socket, of the reverse proxies whose X-Forwarded-For and
+	// X-Real-IP headers give the client address. Bans, duplicate checks
+	// and logs use that address.
+	TrustedProxies []string `toml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	// MetricsListen serves /metrics on a separate TCP address, such as
+	// 127.0.0.1:9100, without authentication. Otherwise it is served on
+	// the main listener and needs an admin token.
+	MetricsListen string `toml:"metrics_listen" env:"METRICS_LISTEN"`
+	// Env is "development" when running behind the Vite dev server.
+	Env          string `toml:"env" env:"ENV"`
+	PublicModLog bool   `toml:"public_modlog" env:"PUBLIC_MODLOG"`
+	// CORSOrigins may call the API from a browser. It defaults to the
+	// Vite dev server in development.
+	CORSOrigins []string `toml:"cors_origins,reload" env:"CORS_ORIGINS"`
+	// DrainDelay is how long readiness fails before the server stops
+	// accepting connections on shutdown.
+	DrainDelay      time.Duration `toml:"drain_delay" env:"SHUTDOWN_DRAIN_DELAY"`
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+}
+
+type Limits struct {
+	RequestTimeout time.Duration `toml:"request_timeout" env:"REQUEST_TIMEOUT"`
+	// MinFreeDiskMB is the free space on the database volume below which
+	// the server reports itself unready.
+	MinFreeDiskMB int `toml:"min_free_disk_mb" env:"HEALTH_MIN_FREE_MB"`
+}
+
+type Cache struct {
+	// Entries is how many read responses are kept in memory; 0 disables
+	// the response cache.
+	Entries int `toml:"entries" env:"CACHE_ENTRIES"`
+	// MaxAge limits how long a response is served from the cache. Writes
+	// through the server drop the responses they change at once; this
+	// bounds how long writes by the command-line tools go unseen.
+	MaxAge time.Duration `toml:"max_age" env:"CACHE_MAX_AGE"`
+}
+
+type Security struct {
+	// CSP is the Content-Security-Policy. Every response gets a fresh
+	// nonce in place of {nonce}, which is also added to the script and
+	// style tags of index.html. An empty policy sends no header.
+	CSP string `toml:"csp" env:"CSP"`
+	// CSPReportOnly sends the policy as Content-Security-Policy-Report-Only,
+	// so that violations are reported to /api/csp-report but not blocked.
+	CSPReportOnly bool `toml:"csp_report_only" env:"CSP_REPORT_ONLY"`
+	// HSTSMaxAge enables Strict-Transport-Security. Only set it once the
+	// site is served over HTTPS alone.
+	HSTSMaxAge            time.Duration `toml:"hsts_max_age" env:"HSTS_MAX_AGE"`
+	HSTSIncludeSubdomains bool          `toml:"hsts_include_subdomains" env:"HSTS_INCLUDE_SUBDOMAINS"`
+	HSTSPreload           bool          `toml:"hsts_preload" env:"HSTS_PRELOAD"`
+}
+
+// DefaultCSP allows scripts and styles from the site itself and those
+// carrying the response's nonce, and nothing from elsewhere.
+const DefaultCSP = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; " +
+	"style-src 'self' 'nonce-{nonce}'; img-src 'self' data:; object-src 'none'; " +
+	"base-uri 'none'; form-action 'self'; frame-ancestors 'none'; " +
+	"report-uri /api/csp-report; report-to csp"
+
+type Admin struct {
+	// Tokens are label:token pairs. The environment variable takes them
+	// comma separated.
+	Tokens []string `toml:"tokens,secret,reload" env:"ADMIN_TOKENS"`
+}
+
+type PoW struct {
+	// Secret keys the challenges. Without one a random secret is used,
+	// which invalidates outstanding challenges on restart.
+	Secret       string        `toml:"secret,secret" env:"POW_SECRET"`
+	ChallengeTTL time.Duration `toml:"challenge_ttl" env:"POW_CHALLENGE_TTL"`
+}
+
+type Jobs struct {
+	PruneInterval time.Duration `toml:"prune_interval" env:"PRUNE_INTERVAL"`
+	// ArchiveRetention of 0 keeps archived threads forever.
+	ArchiveRetention time.Duration `toml:"archive_retention" env:"ARCHIVE_RETENTION"`
+	// BackupDir enables scheduled backups.
+	BackupDir      string        `toml:"backup_dir" env:"BACKUP_DIR"`
+	BackupInterval time.Duration `toml:"backup_interval" env:"BACKUP_INTERVAL"`
+	BackupKeep     int           `toml:"backup_keep" env:"BACKUP_KEEP"`
+}
+
+type Log struct {
+	// Format is json or text. It defaults to text in development and
+	// JSON otherwise.
+	Format string `toml:"format" env:"LOG_FORMAT"`
+	// Level is a default level optionally followed by subsystem
+	// overrides, for example "info,http=warn,jobs=debug".
+	Level string `toml:"level,reload" env:"LOG_LEVEL"`
+}
+
+func Default() Config {
+	return Config{
+		Server: Server{
+			Port:              "8080",
+			SocketMode:        "0660",
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			MaxHeaderBytes:    64 << 10,
+			Compression:       true,
+			CompressMinBytes:  1024,
+			ShutdownTimeout:   5 * time.Second,
+		},
+		Database: db.DefaultConfig(),
+		Limits: Limits{
+			RequestTimeout: 30 * time.Second,
+			MinFreeDiskMB:  100,
+		},
+		Cache: Cache{
+			Entries: 1000,
+			MaxAge:  time.Minute,
+		},
+		Security: Security{CSP: DefaultCSP},
+		PoW:      PoW{ChallengeTTL: pow.DefaultTTL},
+		Jobs: Jobs{
+			PruneInterval:    5 * time.Minute,
+			ArchiveRetention: 30 * 24 * time.Hour,
+			BackupInterval:   24 * time.Hour,
+			BackupKeep:       7,
+		},
+		Log:     Log{Level: "info"},
+		Tracing: tracing.DefaultConfig(),
+	}
+}
+
+// Load reads the file at path, or at MINIBB_CONFIG if path is empty, then
+// applies the environment and overrides, which map keys to values as they
+// would be given on the command line. Without a file only the defaults
+// and the environment apply.
+func Load(path string, overrides map[string]string) (Config, error) {
+	config := Default()
+
+	if path == "" {
+		path = os.Getenv("MINIBB_CONFIG")
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		tables, err := parseFile(path, data)
+		if err == nil {
+			err = config.decode(tables)
+		}
+		if err != nil {
+			return Config{}, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if err := config.applyEnv(); err != nil {
+		return Config{}, err
+	}
+
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := config.set(key, overrides[key]); err != nil {
+			return Config{}, fmt.Errorf("-%s: %w", key, err)
+		}
+	}
+
+	if config.Log.Format == "" {
+		config.Log.Format = "json"
+		if config.Development() {
+			config.Log.Format = "text"
+		}
+	}
+	if config.Server.CORSOrigins == nil && config.Development() {
+		config.Server.CORSOrigins = []string{"http://localhost:5173"}
+	}
+	config.Database.JournalMode = strings.ToUpper(config.Database.JournalMode)
+	config.Database.Synchronous = strings.ToUpper(config.Database.Synchronous)
+
+	return config, config.Validate()
+}
+
+// applyEnv sets every key whose environment variable is set and not empty.
+func (c *Config) applyEnv() error {
+	for _, f := range c.fields() {
+		value := os.Getenv(f.env)
+		if f.env == "" || value == "" {
+			continue
+		}
+		if err := f.setString(value); err != nil {
+			return fmt.Errorf("%s: %w", f.env, err)
+		}
+	}
+
+	// The generic OTLP endpoint names the collector rather than the
+	// traces resource.
+	if os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			c.Tracing.Endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+		}
+	}
+	return nil
+}
+
+func (c *Config) set(key, value string) error {
+	for _, f := range c.fields() {
+		if f.key == key {
+			return f.setString(value)
+		}
+	}
+	return fmt.Errorf("unknown setting")
+}
+
+// Validate checks the settings, naming the offending key on failure.
+func (c Config) Validate() error {
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 0 || port > 65535 {
+		return fmt.Errorf("server.port must be a port number")
+	}
+	if strings.HasPrefix(c.Server.Listen, "unix:") && len(c.Server.Listen) == len("unix:") {
+		return fmt.Errorf("server.listen needs a socket path after unix:")
+	}
+	if _, err := c.SocketMode(); err != nil {
+		return fmt.Errorf("server.socket_mode must be an octal file mode such as 0660")
+	}
+	if c.Server.MetricsListen != "" {
+		if _, _, err := net.SplitHostPort(c.Server.MetricsListen); err != nil {
+			return fmt.Errorf("server.metrics_listen must be a TCP address such as 127.0.0.1:9100")
+		}
+	}
+	if _, err := c.TrustedProxies(); err != nil {
+		return fmt.Errorf("server.trusted_proxies: %w", err)
+	}
+	if (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		return fmt.Errorf("server.tls_cert and server.tls_key must be set together")
+	}
+	if c.Server.ReadHeaderTimeout < 0 || c.Server.IdleTimeout < 0 {
+		return fmt.Errorf("server timeouts must not be negative")
+	}
+	if c.Server.MaxHeaderBytes < 0 {
+		return fmt.Errorf("server.max_header_bytes must not be negative")
+	}
+	if c.Server.CompressMinBytes < 0 {
+		return fmt.Errorf("server.compress_min_bytes must not be negative")
+	}
+	if c.Server.DrainDelay < 0 {
+		return fmt.Errorf("server.drain_delay must not be negative")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		return fmt.Errorf("server.shutdown_timeout must be positive")
+	}
+	if err := c.Database.Validate(); err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	if c.Limits.RequestTimeout <= 0 {
+		return fmt.Errorf("limits.request_timeout must be positive")
+	}
+	if c.Limits.MinFreeDiskMB < 0 {
+		return fmt.Errorf("limits.min_free_disk_mb must not be negative")
+	}
+	if c.Cache.Entries < 0 {
+		return fmt.Errorf("cache.entries must not be negative")
+	}
+	if c.Cache.MaxAge <= 0 {
+		return fmt.Errorf("cache.max_age must be positive")
+	}
+	if strings.ContainsAny(c.Security.CSP, "\r\n") {
+		return fmt.Errorf("security.csp must be a single line")
+	}
+	if c.Security.HSTSMaxAge < 0 {
+		return fmt.Errorf("security.hsts_max_age must not be negative")
+	}
+	if c.Security.HSTSPreload && !c.Security.HSTSIncludeSubdomains {
+		return fmt.Errorf("security.hsts_preload requires security.hsts_include_subdomains")
+	}
+	if _, err := c.AdminTokens(); err != nil {
+		return fmt.Errorf("admin.tokens: %w", err)
+	}
+	if c.PoW.ChallengeTTL <= 0 {
+		return fmt.Errorf("pow.challenge_ttl must be positive")
+	}
+	if c.Jobs.PruneInterval <= 0 {
+		return fmt.Errorf("jobs.prune_interval must be positive")
+	}
+	if c.Jobs.ArchiveRetention < 0 {
+		return fmt.Errorf("jobs.archive_retention must not be negative")
+	}
+	if c.Jobs.BackupInterval <= 0 {
+		return fmt.Errorf("jobs.backup_interval must be positive")
+	}
+	if c.Jobs.BackupKeep < 1 {
+		return fmt.Errorf("jobs.backup_keep must be at least 1")
+	}
+	if _, err := c.Logging(); err != nil {
+		return fmt.Errorf("log: %w", err)
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		return fmt.Errorf("tracing: %w", err)
+	}
+	return nil
+}
+
+func (c Config) Development() bool {
+	return c.Server.Env == "development"
+}
+
+func (c Config) SocketMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(c.Server.SocketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid file mode %q", c.Server.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+func (c Config) TrustedProxies() (*utils.TrustedProxies, error) {
+	return utils.ParseTrustedProxies(c.Server.TrustedProxies)
+}
+
+func (c Config) AdminTokens() (*auth.Tokens, error) {
+	return auth.ParseTokens(strings.Join(c.Admin.Tokens, ","))
+}
+
+func (c Config) Logging() (logging.Config, error) {
+	return logging.ParseConfig(c.Log.Format, c.Log.Level)
+}
+
+// Flags adds -config and an override flag per key, such as -server.port,
+// to a command's flag set.
+type Flags struct {
+	path      string
+	overrides map[string]string
+}
+
+func AddFlags(flags *flag.FlagSet) *Flags {
+	f := &Flags{overrides: make(map[string]string)}
+	flags.StringVar(&f.path, "config", "", "configuration `file` (default $MINIBB_CONFIG)")
+
+	defaults := Default()
+	for _, field := range defaults.fields() {
+		key := field.key
+		usage := "set " + key
+		if field.env != "" {
+			usage += ", overriding $" + field.env
+		}
+		flags.Func(key, usage, func(value string) error {
+			f.overrides[key] = value
+			return nil
+		})
+	}
+	return f
+}
+
+// Load loads the configuration with the parsed flags applied.
+func (f *Flags) Load() (Config, error) {
+	return Load(f.path, f.overrides)
+}
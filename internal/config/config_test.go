@@ -0,0 +1,178 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const tomlConfig = `
+# Comments and blank lines are ignored
+
+[server]
+port = "9000"
+cors_origins = [
+  "https://a.example",
+  'https://b.example',
+]
+compress_min_bytes = 2_048
+
+[cache]
+max_age = "90s"
+
+[pow]
+secret = "s3cret"
+`
+
+const yamlConfig = `
+# The same settings as YAML
+server:
+  port: "9000"
+  cors_origins:
+    - https://a.example
+    - https://b.example
+  compress_min_bytes: 2048
+cache:
+  max_age: 90s
+pow:
+  secret: s3cret
+jobs:
+`
+
+func TestLoadFormats(t *testing.T) {
+	for _, name := range []string{"minibb.toml", "minibb.yaml", "minibb.yml"} {
+		t.Run(name, func(t *testing.T) {
+			content := tomlConfig
+			if !strings.HasSuffix(name, ".toml") {
+				content = yamlConfig
+			}
+			config, err := Load(writeFile(t, name, content), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Server.Port != "9000" {
+				t.Errorf("server.port = %q", config.Server.Port)
+			}
+			if want := []string{"https://a.example", "https://b.example"}; !reflect.DeepEqual(config.Server.CORSOrigins, want) {
+				t.Errorf("server.cors_origins = %q, want %q", config.Server.CORSOrigins, want)
+			}
+			if config.Server.CompressMinBytes != 2048 {
+				t.Errorf("server.compress_min_bytes = %d", config.Server.CompressMinBytes)
+			}
+			if config.Cache.MaxAge != 90*time.Second {
+				t.Errorf("cache.max_age = %v", config.Cache.MaxAge)
+			}
+			if config.PoW.Secret != "s3cret" {
+				t.Errorf("pow.secret = %q", config.PoW.Secret)
+			}
+			// Untouched settings keep their defaults
+			if config.Jobs.BackupKeep != Default().Jobs.BackupKeep {
+				t.Errorf("jobs.backup_keep = %d", config.Jobs.BackupKeep)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		{"unknown.toml", "[server]\nprot = \"1\"\n", "unknown setting server.prot"},
+		{"table.toml", "[sever]\nport = \"1\"\n", "unknown table [sever]"},
+		{"root.toml", "port = \"1\"\n", `key "port" must be in a table`},
+		{"type.toml", "[server]\ncompression = \"yes\"\n", "server.compression: expected true or false"},
+		{"duration.toml", "[cache]\nmax_age = 60\n", "cache.max_age: expected a duration"},
+		{"twice.toml", "[server]\nport = \"1\"\nport = \"2\"\n", "port"},
+		{"syntax.toml", "[server\n", "toml: line"},
+		{"unknown.yaml", "server:\n  prot: 1\n", "unknown setting server.prot"},
+		{"type.yaml", "cache:\n  entries: many\n", "cache.entries: expected an integer"},
+		{"twice.yaml", "server:\n  port: 1\n  port: 2\n", "already defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeFile(t, tt.name, tt.content), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	t.Setenv("PORT", "7000")
+	path := writeFile(t, "minibb.toml", "[server]\nport = \"9000\"\n")
+
+	config, err := Load(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Server.Port != "7000" {
+		t.Errorf("the environment should override the file: server.port = %q", config.Server.Port)
+	}
+
+	config, err = Load(path, map[string]string{"server.port": "6000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Server.Port != "6000" {
+		t.Errorf("flags should override the environment: server.port = %q", config.Server.Port)
+	}
+}
+
+// TestWriteRoundTrip checks that the printed configuration loads back as
+// the same settings, apart from the redacted secrets.
+func TestWriteRoundTrip(t *testing.T) {
+	config, err := Load("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Server.Port = "9000"
+	config.Server.CORSOrigins = []string{"https://a.example"}
+	config.Cache.MaxAge = 90 * time.Second
+	config.Security.CSP = "default-src 'self'; script-src \"quoted\""
+	config.PoW.Secret = "s3cret"
+
+	var sb strings.Builder
+	if err := config.Write(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sb.String(), "s3cret") {
+		t.Errorf("secret printed:\n%s", sb.String())
+	}
+
+	loaded, err := Load(writeFile(t, "minibb.toml", sb.String()), nil)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, sb.String())
+	}
+	reloadable, restart := config.Changes(loaded)
+	if len(reloadable) != 0 || !reflect.DeepEqual(restart, []string{"pow.secret"}) {
+		t.Errorf("changes after a round trip: %v, %v", reloadable, restart)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	current := Default()
+	next := Default()
+	next.Log.Level = "debug"
+	next.Server.Port = "9000"
+
+	reloadable, restart := current.Changes(next)
+	if !reflect.DeepEqual(reloadable, []string{"log.level"}) {
+		t.Errorf("reloadable = %v", reloadable)
+	}
+	if !reflect.DeepEqual(restart, []string{"server.port"}) {
+		t.Errorf("restart = %v", restart)
+	}
+}
@@ -0,0 +1,310 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const redacted = "<redacted>"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field is one setting, found through the struct tags of Config.
+type field struct {
+	section string
+	name    string
+	key     string
+	env     string
+	secret  bool
+	reload  bool
+	value   reflect.Value
+}
+
+// fields lists the settings in declaration order. The values are
+// addressable, so setting them changes c.
+func (c *Config) fields() []field {
+	var fields []field
+	root := reflect.ValueOf(c).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Type().Field(i).Tag.Get("toml")
+		sv := root.Field(i)
+		for j := 0; j < sv.NumField(); j++ {
+			tag := sv.Type().Field(j).Tag
+			options := strings.Split(tag.Get("toml"), ",")
+			if options[0] == "" {
+				continue
+			}
+			f := field{
+				section: section,
+				name:    options[0],
+				key:     section + "." + options[0],
+				env:     tag.Get("env"),
+				value:   sv.Field(j),
+			}
+			for _, option := range options[1:] {
+				switch option {
+				case "secret":
+					f.secret = true
+				case "reload":
+					f.reload = true
+				}
+			}
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// decode applies the tables of a parsed file. Unknown tables and keys are
+// errors so that typos don't go unnoticed.
+func (c *Config) decode(tables map[string]map[string]interface{}) error {
+	byKey := make(map[string]field)
+	sections := make(map[string]bool)
+	for _, f := range c.fields() {
+		byKey[f.key] = f
+		sections[f.section] = true
+	}
+
+	for name, table := range tables {
+		if name == "" {
+			for key := range table {
+				return fmt.Errorf("key %q must be in a table such as [server]", key)
+			}
+			continue
+		}
+		if !sections[name] {
+			return fmt.Errorf("unknown table [%s]", name)
+		}
+		for key, value := range table {
+			f, ok := byKey[name+"."+key]
+			if !ok {
+				return fmt.Errorf("unknown setting %s.%s", name, key)
+			}
+			if err := f.setTOML(value); err != nil {
+				return fmt.Errorf("%s: %w", f.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// setString sets the field from an environment variable or flag. Lists
+// are comma separated.
+func (f field) setString(s string) error {
+	v := f.value
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(x)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		panic("config: unsupported type " + v.Type().String())
+	}
+	return nil
+}
+
+// setTOML sets the field from a value parsed from the file, TOML or YAML.
+// Durations are given as strings such as "30s".
+func (f field) setTOML(value interface{}) error {
+	v := f.value
+	if v.Type() == durationType {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a duration such as \"30s\"")
+		}
+		return f.setString(s)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		if s, ok := value.(string); ok {
+			v.SetString(s)
+			return nil
+		}
+		return fmt.Errorf("expected a string")
+	case reflect.Bool:
+		if b, ok := value.(bool); ok {
+			v.SetBool(b)
+			return nil
+		}
+		return fmt.Errorf("expected true or false")
+	case reflect.Int:
+		if n, ok := value.(int64); ok {
+			v.SetInt(n)
+			return nil
+		}
+		return fmt.Errorf("expected an integer")
+	case reflect.Float64:
+		switch x := value.(type) {
+		case float64:
+			v.SetFloat(x)
+			return nil
+		case int64:
+			v.SetFloat(float64(x))
+			return nil
+		}
+		return fmt.Errorf("expected a number")
+	case reflect.Slice:
+		values, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected an array of strings")
+		}
+		// Nil when empty, as from the environment
+		var items []string
+		for _, item := range values {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("expected an array of strings")
+			}
+			items = append(items, s)
+		}
+		v.Set(reflect.ValueOf(items))
+		return nil
+	}
+	panic("config: unsupported type " + v.Type().String())
+}
+
+// Changes lists the keys whose values differ in next, split into those
+// that can be applied to a running server and those that need a restart.
+func (c Config) Changes(next Config) (reloadable, restart []string) {
+	nextFields := next.fields()
+	for i, f := range c.fields() {
+		if reflect.DeepEqual(f.value.Interface(), nextFields[i].value.Interface()) {
+			continue
+		}
+		if f.reload {
+			reloadable = append(reloadable, f.key)
+		} else {
+			restart = append(restart, f.key)
+		}
+	}
+	return reloadable, restart
+}
+
+// Write prints the configuration as a TOML file, each key annotated with
+// its environment variable and whether it can be reloaded. Secrets are
+// redacted.
+func (c Config) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	section := ""
+	for _, f := range c.fields() {
+		if f.section != section {
+			if section != "" {
+				bw.WriteString("\n")
+			}
+			section = f.section
+			bw.WriteString("[" + section + "]\n")
+		}
+		var notes []string
+		if f.env != "" {
+			notes = append(notes, f.env)
+		}
+		if f.reload {
+			notes = append(notes, "reloadable")
+		}
+		line := f.name + " = " + f.format()
+		if len(notes) > 0 {
+			line += " # " + strings.Join(notes, ", ")
+		}
+		bw.WriteString(line + "\n")
+	}
+	return bw.Flush()
+}
+
+func (f field) format() string {
+	v := f.value
+	if v.Type() == durationType {
+		return quote(time.Duration(v.Int()).String())
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		if f.secret && v.String() != "" {
+			return quote(redacted)
+		}
+		return quote(v.String())
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Float64:
+		s := strconv.FormatFloat(v.Float(), 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			if f.secret {
+				items[i] = quote(redacted)
+			} else {
+				items[i] = quote(v.Index(i).String())
+			}
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	panic("config: unsupported type " + v.Type().String())
+}
+
+// quote writes s as a TOML basic string.
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f || r == utf8.RuneError:
+			fmt.Fprintf(&sb, `\u%04X`, r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// parseFile reads a configuration file, as YAML if its name ends in .yaml
+// or .yml and as TOML otherwise. It returns the keys by table, with
+// integers as int64 whichever the format; keys outside a table are in
+// table "".
+func parseFile(path string, data []byte) (map[string]map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &root); err != nil {
+			return nil, err
+		}
+	default:
+		if _, err := toml.Decode(string(data), &root); err != nil {
+			return nil, err
+		}
+	}
+
+	tables := map[string]map[string]interface{}{"": {}}
+	for name, value := range root {
+		if value == nil {
+			// An empty YAML section
+			continue
+		}
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			tables[""][name] = normalize(value)
+			continue
+		}
+		for key, value := range table {
+			table[key] = normalize(value)
+		}
+		tables[name] = table
+	}
+	return tables, nil
+}
+
+// normalize converts the integers YAML decodes as int to int64, as TOML
+// decodes them, including in arrays.
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = normalize(v[i])
+		}
+	}
+	return value
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Backup writes a consistent snapshot of the database to path using
+// VACUUM INTO. It runs on its own connection, so in WAL mode the server
+// keeps reading and writing while the snapshot is taken.
+func Backup(config Config, path string) error {
+	if _, err := os.Stat(config.Path); err != nil {
+		return fmt.Errorf("database %s not found: %w", config.Path, err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup target %s already exists", path)
+	}
+
+	params := url.Values{}
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", config.BusyTimeout.Milliseconds()))
+	conn, err := sql.Open("sqlite", config.Path+"?"+params.Encode())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	return nil
+}
+
+// CheckIntegrity runs PRAGMA integrity_check against the database file at
+// path and makes sure it is a MiniBB database.
+func CheckIntegrity(path string) error {
+	// Opening a missing file would silently create an empty database.
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	conn, err := sql.Open("sqlite", path+"?_pragma=query_only(1)")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rows, err := conn.Query("PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("integrity check failed: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var message string
+		if err := rows.Scan(&message); err != nil {
+			return err
+		}
+		if message != "ok" {
+			problems = append(problems, message)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("integrity check failed: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+
+	var tables int
+	if err := conn.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'migrations'",
+	).Scan(&tables); err != nil {
+		return err
+	}
+	if tables == 0 {
+		return fmt.Errorf("%s is not a MiniBB database", path)
+	}
+
+	return nil
+}
+
+// Restore replaces the configured database with the backup at
+// backupPath after verifying its integrity. The current database is kept
+// next to it and its path returned. The server must not be running.
+func Restore(config Config, backupPath string) (string, error) {
+	if err := CheckIntegrity(backupPath); err != nil {
+		return "", err
+	}
+
+	// Copy first so that the swap below is a rename within one directory.
+	staged := config.Path + ".restore"
+	if err := copyFile(backupPath, staged); err != nil {
+		os.Remove(staged)
+		return "", fmt.Errorf("failed to stage backup: %w", err)
+	}
+
+	var previous string
+	if _, err := os.Stat(config.Path); err == nil {
+		// Fold the WAL into the main file so the kept copy is complete.
+		if err := checkpoint(config.Path); err != nil {
+			os.Remove(staged)
+			return "", fmt.Errorf("failed to checkpoint current database: %w", err)
+		}
+		previous = config.Path + ".pre-restore-" + time.Now().UTC().Format("20060102-150405")
+		if err := os.Rename(config.Path, previous); err != nil {
+			os.Remove(staged)
+			return "", err
+		}
+		os.Remove(config.Path + "-wal")
+		os.Remove(config.Path + "-shm")
+	}
+
+	if err := os.Rename(staged, config.Path); err != nil {
+		return previous, err
+	}
+
+	return previous, nil
+}
+
+func checkpoint(path string) error {
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	return err
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
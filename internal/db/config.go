@@ -0,0 +1,94 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultJournalMode = "WAL"
+	defaultSynchronous = "NORMAL"
+	defaultBusyTimeout = 5 * time.Second
+	defaultReadConns   = 4
+)
+
+var (
+	journalModes = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	synchronous  = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+)
+
+// Config holds the connection settings shared by the write and read
+// pools. The write pool always has a single connection.
+type Config struct {
+	Path        string        `json:"path" toml:"path" env:"DATABASE_PATH"`
+	JournalMode string        `json:"journal_mode" toml:"journal_mode" env:"DATABASE_JOURNAL_MODE"`
+	Synchronous string        `json:"synchronous" toml:"synchronous" env:"DATABASE_SYNCHRONOUS"`
+	BusyTimeout time.Duration `json:"busy_timeout" toml:"busy_timeout" env:"DATABASE_BUSY_TIMEOUT"`
+	ForeignKeys bool          `json:"foreign_keys" toml:"foreign_keys" env:"DATABASE_FOREIGN_KEYS"`
+	ReadConns   int           `json:"read_conns" toml:"read_conns" env:"DATABASE_READ_CONNS"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Path:        "minibb.db",
+		JournalMode: defaultJournalMode,
+		Synchronous: defaultSynchronous,
+		BusyTimeout: defaultBusyTimeout,
+		ForeignKeys: true,
+		ReadConns:   defaultReadConns,
+	}
+}
+
+func (c Config) Validate() error {
+	if c.Path == "" {
+		return fmt.Errorf("database path must not be empty")
+	}
+	if !contains(journalModes, c.JournalMode) {
+		return fmt.Errorf("journal mode must be one of %s", strings.Join(journalModes, ", "))
+	}
+	if !contains(synchronous, c.Synchronous) {
+		return fmt.Errorf("synchronous must be one of %s", strings.Join(synchronous, ", "))
+	}
+	if c.BusyTimeout < 0 {
+		return fmt.Errorf("busy timeout must not be negative")
+	}
+	if c.ReadConns < 1 {
+		return fmt.Errorf("read pool needs at least one connection")
+	}
+	return nil
+}
+
+// dsn builds the connection string. Pragmas are passed through the DSN so
+// that every connection in the pool gets them, not just the first one.
+func (c Config) dsn(readOnly bool) string {
+	params := url.Values{}
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", c.BusyTimeout.Milliseconds()))
+	if readOnly {
+		// The journal mode is persistent and set by the write pool.
+		params.Add("_pragma", "query_only(1)")
+	} else {
+		params.Add("_pragma", "journal_mode("+c.JournalMode+")")
+		// Take the write lock when a transaction begins instead of on its
+		// first write, so the busy timeout applies rather than failing
+		// with SQLITE_BUSY on lock upgrade.
+		params.Set("_txlock", "immediate")
+	}
+	params.Add("_pragma", "synchronous("+c.Synchronous+")")
+	if c.ForeignKeys {
+		params.Add("_pragma", "foreign_keys(1)")
+	} else {
+		params.Add("_pragma", "foreign_keys(0)")
+	}
+	return c.Path + "?" + params.Encode()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -20,3 +20,35 @@ func FromContext(ctx context.Context) *sql.DB {
 	}
 	return db
 }
+
+const readerContextKey contextKey = "reader"
+
+// WithReader attaches the read-only pool to ctx.
+func WithReader(ctx context.Context, db *sql.DB) context.Context {
+	return context.WithValue(ctx, readerContextKey, db)
+}
+
+// ReaderFromContext returns the read-only pool, falling back to the write
+// pool when no reader was attached.
+func ReaderFromContext(ctx context.Context) *sql.DB {
+	if db, ok := ctx.Value(readerContextKey).(*sql.DB); ok {
+		return db
+	}
+	return FromContext(ctx)
+}
+
+const configContextKey contextKey = "config"
+
+// WithConfig attaches the database configuration to ctx, for handlers
+// that work on the database file rather than through a pool.
+func WithConfig(ctx context.Context, config Config) context.Context {
+	return context.WithValue(ctx, configContextKey, config)
+}
+
+func ConfigFromContext(ctx context.Context) Config {
+	config, ok := ctx.Value(configContextKey).(Config)
+	if !ok {
+		panic("database configuration not found in context")
+	}
+	return config
+}
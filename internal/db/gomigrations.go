@@ -0,0 +1,51 @@
+package db
+
+import (
+	"database/sql"
+
+	"minibb/internal/models"
+)
+
+// goMigrations are interleaved with the SQL migrations by number.
+var goMigrations = []GoMigration{
+	{
+		Number: 10,
+		Name:   "backfill_content_hash",
+		Up:     backfillContentHash,
+		// The hashes are only an index for duplicate detection, so there
+		// is nothing to undo.
+		Down: func(tx *sql.Tx) error { return nil },
+	},
+}
+
+// backfillContentHash hashes posts created before 0006 added the column.
+// The hash normalizes whitespace, which SQLite's built-in functions can't.
+func backfillContentHash(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, content FROM posts WHERE content_hash = ''`)
+	if err != nil {
+		return err
+	}
+
+	hashes := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return err
+		}
+		hashes[id] = models.ContentHash(content)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, hash := range hashes {
+		if _, err := tx.Exec(`UPDATE posts SET content_hash = ? WHERE id = ?`, hash, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,446 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+const downSuffix = ".down.sql"
+
+// GoMigration is a migration implemented in Go for data changes that SQL
+// cannot express. Down may be nil if the migration cannot be reverted.
+type GoMigration struct {
+	Number int
+	Name   string
+	Up     func(tx *sql.Tx) error
+	Down   func(tx *sql.Tx) error
+}
+
+// Migration describes a migration and whether it has been applied.
+// Modified is set when the checksum recorded at apply time no longer
+// matches the migration shipped with the binary.
+type Migration struct {
+	Number     int        `json:"number"`
+	Filename   string     `json:"filename"`
+	Checksum   string     `json:"checksum"`
+	Reversible bool       `json:"reversible"`
+	Applied    bool       `json:"applied"`
+	AppliedAt  *time.Time `json:"applied_at"`
+	Modified   bool       `json:"modified"`
+
+	upSQL   string
+	downSQL string
+	goFunc  *GoMigration
+}
+
+// UpScript returns the SQL the migration runs when applied. Go
+// migrations are described by a comment.
+func (m Migration) UpScript() string {
+	if m.goFunc != nil {
+		return fmt.Sprintf("-- Go migration %s\n", m.Filename)
+	}
+	return m.upSQL
+}
+
+// DownScript returns the SQL the migration runs when reverted.
+func (m Migration) DownScript() string {
+	if m.goFunc != nil {
+		return fmt.Sprintf("-- Go migration %s (down)\n", m.Filename)
+	}
+	return m.downSQL
+}
+
+func createMigrationsTable(db *sql.DB) error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS migrations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		migration_number INTEGER NOT NULL UNIQUE,
+		filename TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		checksum TEXT
+	);`
+
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+
+	// Databases created before checksums were tracked lack the column.
+	var hasChecksum int
+	if err := db.QueryRow(
+		"SELECT COUNT(*) FROM pragma_table_info('migrations') WHERE name = 'checksum'",
+	).Scan(&hasChecksum); err != nil {
+		return err
+	}
+	if hasChecksum == 0 {
+		if _, err := db.Exec("ALTER TABLE migrations ADD COLUMN checksum TEXT"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runMigrations(db *sql.DB) error {
+	return MigrateTo(db, 0)
+}
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadMigrations reads the embedded SQL migrations and the registered Go
+// migrations and returns them ordered by number.
+func loadMigrations() ([]Migration, error) {
+	files, err := fs.ReadDir(migrationsFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+
+	byNumber := make(map[int]*Migration)
+	downFiles := make(map[int]string)
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+
+		number, err := extractMigrationNumber(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration filename %s: %w", name, err)
+		}
+
+		content, err := fs.ReadFile(migrationsFS, "migrations/"+name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration file: %w", err)
+		}
+
+		if strings.HasSuffix(name, downSuffix) {
+			downFiles[number] = string(content)
+			continue
+		}
+
+		if _, ok := byNumber[number]; ok {
+			return nil, fmt.Errorf("duplicate migration number %04d", number)
+		}
+		byNumber[number] = &Migration{
+			Number:   number,
+			Filename: name,
+			Checksum: checksum(string(content)),
+			upSQL:    string(content),
+		}
+	}
+
+	for number, content := range downFiles {
+		migration, ok := byNumber[number]
+		if !ok {
+			return nil, fmt.Errorf("down migration %04d has no matching up migration", number)
+		}
+		migration.downSQL = content
+		migration.Reversible = true
+	}
+
+	for i := range goMigrations {
+		goMigration := &goMigrations[i]
+		if _, ok := byNumber[goMigration.Number]; ok {
+			return nil, fmt.Errorf("duplicate migration number %04d", goMigration.Number)
+		}
+		filename := fmt.Sprintf("%04d_%s", goMigration.Number, goMigration.Name)
+		byNumber[goMigration.Number] = &Migration{
+			Number:     goMigration.Number,
+			Filename:   filename,
+			Checksum:   checksum("go:" + filename),
+			Reversible: goMigration.Down != nil,
+			goFunc:     goMigration,
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byNumber))
+	for _, migration := range byNumber {
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Number < migrations[j].Number
+	})
+
+	return migrations, nil
+}
+
+// Migrations returns all known migrations in order together with their
+// applied state.
+func Migrations(db *sql.DB) ([]Migration, error) {
+	if err := createMigrationsTable(db); err != nil {
+		return nil, fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	return migrationStates(db)
+}
+
+// migrationStates loads the known migrations and marks those recorded in
+// the migrations table. It only reads, so it works on the read pool.
+func migrationStates(db *sql.DB) ([]Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	type appliedMigration struct {
+		at       time.Time
+		checksum sql.NullString
+	}
+	applied := make(map[int]appliedMigration)
+	rows, err := db.Query("SELECT migration_number, applied_at, checksum FROM migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var number int
+		var record appliedMigration
+		if err := rows.Scan(&number, &record.at, &record.checksum); err != nil {
+			return nil, err
+		}
+		applied[number] = record
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range migrations {
+		record, ok := applied[migrations[i].Number]
+		if !ok {
+			continue
+		}
+		migrations[i].Applied = true
+		migrations[i].AppliedAt = &record.at
+		migrations[i].Modified = record.checksum.Valid && record.checksum.String != migrations[i].Checksum
+	}
+
+	return migrations, nil
+}
+
+// CheckMigrations fails unless every migration shipped with the binary has
+// been applied unmodified. Unlike Migrations it never writes.
+func CheckMigrations(db *sql.DB) error {
+	migrations, err := migrationStates(db)
+	if err != nil {
+		return err
+	}
+
+	var pending, modified []string
+	for _, migration := range migrations {
+		if !migration.Applied {
+			pending = append(pending, migration.Filename)
+		} else if migration.Modified {
+			modified = append(modified, migration.Filename)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("pending migrations: %s", strings.Join(pending, ", "))
+	}
+	if len(modified) > 0 {
+		return fmt.Errorf("modified migrations: %s", strings.Join(modified, ", "))
+	}
+	return nil
+}
+
+// VerifyChecksums fails if an applied migration was edited after it ran.
+// Migrations applied before checksums were recorded are adopted with
+// their current checksum.
+func VerifyChecksums(db *sql.DB) error {
+	migrations, err := Migrations(db)
+	if err != nil {
+		return err
+	}
+
+	var modified []string
+	for _, migration := range migrations {
+		if migration.Modified {
+			modified = append(modified, migration.Filename)
+		}
+	}
+	if len(modified) > 0 {
+		return fmt.Errorf("checksum mismatch for applied migrations: %s", strings.Join(modified, ", "))
+	}
+
+	for _, migration := range migrations {
+		if !migration.Applied {
+			continue
+		}
+		if _, err := db.Exec(
+			"UPDATE migrations SET checksum = ? WHERE migration_number = ? AND checksum IS NULL",
+			migration.Checksum, migration.Number,
+		); err != nil {
+			return fmt.Errorf("failed to record checksum: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// PendingMigrations returns the migrations MigrateTo would apply for the
+// given target.
+func PendingMigrations(db *sql.DB, target int) ([]Migration, error) {
+	migrations, err := Migrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range migrations {
+		if target > 0 && migration.Number > target {
+			break
+		}
+		if !migration.Applied {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// MigrateTo applies all pending migrations up to and including target.
+// A target of 0 applies every pending migration.
+func MigrateTo(db *sql.DB, target int) error {
+	if err := VerifyChecksums(db); err != nil {
+		return err
+	}
+
+	pending, err := PendingMigrations(db, target)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range pending {
+		if err := applyMigration(db, migration); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", migration.Filename, err)
+		}
+	}
+
+	return nil
+}
+
+// RevertibleMigrations returns the last steps applied migrations, newest
+// first, as MigrateDown would revert them.
+func RevertibleMigrations(db *sql.DB, steps int) ([]Migration, error) {
+	migrations, err := Migrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var revert []Migration
+	for i := len(migrations) - 1; i >= 0 && len(revert) < steps; i-- {
+		migration := migrations[i]
+		if !migration.Applied {
+			continue
+		}
+		if !migration.Reversible {
+			return nil, fmt.Errorf("migration %s cannot be reverted", migration.Filename)
+		}
+		revert = append(revert, migration)
+	}
+
+	return revert, nil
+}
+
+// MigrateDown reverts the last steps applied migrations.
+func MigrateDown(db *sql.DB, steps int) error {
+	if err := VerifyChecksums(db); err != nil {
+		return err
+	}
+
+	revert, err := RevertibleMigrations(db, steps)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range revert {
+		if err := revertMigration(db, migration); err != nil {
+			return fmt.Errorf("failed to revert migration %s: %w", migration.Filename, err)
+		}
+	}
+
+	return nil
+}
+
+func extractMigrationNumber(filename string) (int, error) {
+	parts := strings.Split(filename, "_")
+	if len(parts) < 2 {
+		return 0, fmt.Errorf("filename should be in format NNNN_description.sql")
+	}
+
+	migrationNumber, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("migration number should be numeric: %w", err)
+	}
+
+	return migrationNumber, nil
+}
+
+func applyMigration(db *sql.DB, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if migration.goFunc != nil {
+		if err := migration.goFunc.Up(tx); err != nil {
+			return err
+		}
+	} else if _, err := tx.Exec(migration.upSQL); err != nil {
+		return fmt.Errorf("failed to execute migration SQL: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO migrations (migration_number, filename, checksum) VALUES (?, ?, ?)",
+		migration.Number, migration.Filename, migration.Checksum,
+	); err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration: %w", err)
+	}
+
+	fmt.Printf("Applied migration: %s\n", migration.Filename)
+	return nil
+}
+
+func revertMigration(db *sql.DB, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if migration.goFunc != nil {
+		if err := migration.goFunc.Down(tx); err != nil {
+			return err
+		}
+	} else if _, err := tx.Exec(migration.downSQL); err != nil {
+		return fmt.Errorf("failed to execute migration SQL: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM migrations WHERE migration_number = ?", migration.Number); err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration: %w", err)
+	}
+
+	fmt.Printf("Reverted migration: %s\n", migration.Filename)
+	return nil
+}
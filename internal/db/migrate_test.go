@@ -0,0 +1,165 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	config := DefaultConfig()
+	config.Path = filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// schema describes the tables, indexes, triggers and views of db, leaving
+// out the migrations table itself.
+func schema(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	rows, err := db.Query(`
+		SELECT type, name, COALESCE(sql, '') FROM sqlite_master
+		WHERE name NOT LIKE 'sqlite_%' AND name != 'migrations'
+		ORDER BY type, name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var sb strings.Builder
+	for rows.Next() {
+		var kind, name, definition string
+		if err := rows.Scan(&kind, &name, &definition); err != nil {
+			t.Fatal(err)
+		}
+		// Renaming a table quotes its name in the stored definition
+		definition = strings.ReplaceAll(definition, `"`, "")
+		sb.WriteString(kind + " " + name + ": " + definition + "\n")
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return sb.String()
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, migration := range migrations {
+		if i > 0 && migration.Number <= migrations[i-1].Number {
+			t.Errorf("%s is out of order", migration.Filename)
+		}
+		if migration.Reversible && migration.goFunc == nil && strings.TrimSpace(migration.downSQL) == "" {
+			t.Errorf("%s has an empty down file", migration.Filename)
+		}
+		// Only the initial schema and its seed data are permanent
+		if migration.Number > 2 && !migration.Reversible {
+			t.Errorf("%s has no down migration", migration.Filename)
+		}
+	}
+}
+
+// TestUpDown applies the migrations one at a time, then reverts them one
+// at a time, checking that each down migration restores the schema its up
+// migration started from, and finally applies them all again.
+func TestUpDown(t *testing.T) {
+	db := openTestDB(t)
+	migrations, err := Migrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := make(map[int]string)
+	for _, migration := range migrations {
+		before[migration.Number] = schema(t, db)
+		if err := MigrateTo(db, migration.Number); err != nil {
+			t.Fatal(err)
+		}
+	}
+	latest := schema(t, db)
+
+	for i := len(migrations) - 1; i >= 0 && migrations[i].Reversible; i-- {
+		migration := migrations[i]
+		if err := MigrateDown(db, 1); err != nil {
+			t.Fatal(err)
+		}
+		if got := schema(t, db); got != before[migration.Number] {
+			t.Errorf("reverting %s left the schema\n%s\nwant\n%s", migration.Filename, got, before[migration.Number])
+		}
+	}
+
+	if err := MigrateTo(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := schema(t, db); got != latest {
+		t.Errorf("reapplying the migrations gave the schema\n%s\nwant\n%s", got, latest)
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	db := openTestDB(t)
+	if err := MigrateTo(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckMigrations(db); err != nil {
+		t.Fatalf("freshly migrated: %v", err)
+	}
+
+	// A database migrated before checksums were recorded adopts them
+	if _, err := db.Exec("UPDATE migrations SET checksum = NULL WHERE migration_number = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyChecksums(db); err != nil {
+		t.Fatalf("missing checksum: %v", err)
+	}
+	var recorded string
+	if err := db.QueryRow("SELECT checksum FROM migrations WHERE migration_number = 1").Scan(&recorded); err != nil {
+		t.Fatal(err)
+	}
+	if recorded == "" {
+		t.Error("checksum not recorded")
+	}
+
+	// An applied migration edited afterwards is refused
+	if _, err := db.Exec("UPDATE migrations SET checksum = 'edited' WHERE migration_number = 3"); err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := Migrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range migrations {
+		if migration.Modified != (migration.Number == 3) {
+			t.Errorf("%s: modified = %v", migration.Filename, migration.Modified)
+		}
+	}
+	for name, check := range map[string]func(*sql.DB) error{
+		"VerifyChecksums": VerifyChecksums,
+		"CheckMigrations": CheckMigrations,
+		"MigrateTo":       func(db *sql.DB) error { return MigrateTo(db, 0) },
+		"MigrateDown":     func(db *sql.DB) error { return MigrateDown(db, 1) },
+	} {
+		if err := check(db); err == nil || !strings.Contains(err.Error(), "0003_bans.sql") {
+			t.Errorf("%s: got %v, want an error naming 0003_bans.sql", name, err)
+		}
+	}
+}
+
+func TestCheckMigrationsPending(t *testing.T) {
+	db := openTestDB(t)
+	if err := MigrateTo(db, 3); err != nil {
+		t.Fatal(err)
+	}
+	err := CheckMigrations(db)
+	if err == nil || !strings.Contains(err.Error(), "pending migrations: 0004_mod_log.sql") {
+		t.Errorf("got %v, want the pending migrations listed", err)
+	}
+}
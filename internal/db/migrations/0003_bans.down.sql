@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_bans_ip;
+DROP TABLE IF EXISTS bans;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS bans (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    ip TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME,
+    lifted_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_bans_ip ON bans(ip);
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS mod_log_no_delete;
+DROP TRIGGER IF EXISTS mod_log_no_update;
+DROP INDEX IF EXISTS idx_mod_log_target;
+DROP INDEX IF EXISTS idx_mod_log_board_id;
+DROP TABLE IF EXISTS mod_log;
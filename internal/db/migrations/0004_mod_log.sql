@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS mod_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL,
+    target_id INTEGER NOT NULL,
+    board_id INTEGER,
+    before_json TEXT,
+    after_json TEXT,
+    ip TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_mod_log_board_id ON mod_log(board_id);
+CREATE INDEX IF NOT EXISTS idx_mod_log_target ON mod_log(target_type, target_id);
+
+CREATE TRIGGER IF NOT EXISTS mod_log_no_update BEFORE UPDATE ON mod_log
+BEGIN
+    SELECT RAISE(ABORT, 'mod_log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS mod_log_no_delete BEFORE DELETE ON mod_log
+BEGIN
+    SELECT RAISE(ABORT, 'mod_log is append-only');
+END;
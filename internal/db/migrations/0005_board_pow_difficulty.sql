@@ -0,0 +1 @@
+ALTER TABLE boards ADD COLUMN pow_difficulty INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_filters_board_id;
+DROP TABLE IF EXISTS filters;
+
+DROP INDEX IF EXISTS idx_posts_content_hash;
+ALTER TABLE posts DROP COLUMN content_hash;
+ALTER TABLE posts DROP COLUMN ip;
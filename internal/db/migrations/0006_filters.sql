@@ -0,0 +1,20 @@
+ALTER TABLE posts ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE posts ADD COLUMN content_hash TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_posts_content_hash ON posts(content_hash, ip);
+
+CREATE TABLE IF NOT EXISTS filters (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    board_id INTEGER,
+    kind TEXT NOT NULL,
+    pattern TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL DEFAULT 'reject',
+    replacement TEXT NOT NULL DEFAULT '',
+    max_links INTEGER NOT NULL DEFAULT 0,
+    window_minutes INTEGER NOT NULL DEFAULT 0,
+    enabled INTEGER NOT NULL DEFAULT 1,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (board_id) REFERENCES boards(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_filters_board_id ON filters(board_id);
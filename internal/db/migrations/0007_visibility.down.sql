@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_posts_visibility;
+
+ALTER TABLE boards DROP COLUMN premoderate;
+ALTER TABLE topics DROP COLUMN visibility;
+ALTER TABLE posts DROP COLUMN visibility;
@@ -0,0 +1,5 @@
+ALTER TABLE posts ADD COLUMN visibility TEXT NOT NULL DEFAULT 'visible';
+ALTER TABLE topics ADD COLUMN visibility TEXT NOT NULL DEFAULT 'visible';
+ALTER TABLE boards ADD COLUMN premoderate INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_posts_visibility ON posts(visibility);
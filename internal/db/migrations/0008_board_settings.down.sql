@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_topics_board_bumped_at;
+ALTER TABLE topics DROP COLUMN bumped_at;
+
+ALTER TABLE boards ADD COLUMN pow_difficulty INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE boards ADD COLUMN premoderate INTEGER NOT NULL DEFAULT 0;
+
+UPDATE boards SET
+    pow_difficulty = COALESCE((SELECT pow_difficulty FROM board_settings WHERE board_id = boards.id), 0),
+    premoderate = COALESCE((SELECT premoderate FROM board_settings WHERE board_id = boards.id), 0);
+
+DROP TABLE IF EXISTS board_settings;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS board_settings (
+    board_id INTEGER PRIMARY KEY,
+    read_only INTEGER NOT NULL DEFAULT 0,
+    default_name TEXT NOT NULL DEFAULT 'Anonymous',
+    max_title_length INTEGER NOT NULL DEFAULT 200,
+    max_body_length INTEGER NOT NULL DEFAULT 20000,
+    max_active_threads INTEGER NOT NULL DEFAULT 0,
+    bump_limit INTEGER NOT NULL DEFAULT 0,
+    markup_extensions TEXT NOT NULL DEFAULT 'strikethrough,linkify',
+    pow_difficulty INTEGER NOT NULL DEFAULT 0,
+    premoderate INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (board_id) REFERENCES boards(id)
+);
+
+INSERT INTO board_settings (board_id, pow_difficulty, premoderate)
+    SELECT id, pow_difficulty, premoderate FROM boards;
+
+ALTER TABLE boards DROP COLUMN pow_difficulty;
+ALTER TABLE boards DROP COLUMN premoderate;
+
+ALTER TABLE topics ADD COLUMN bumped_at DATETIME;
+
+UPDATE topics SET bumped_at = COALESCE(
+    (SELECT MAX(pub_date) FROM posts WHERE topic_id = topics.id AND visibility = 'visible'),
+    pub_date
+);
+
+CREATE INDEX IF NOT EXISTS idx_topics_board_bumped_at ON topics(board_id, bumped_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_topics_archived_at;
+ALTER TABLE topics DROP COLUMN archived_at;
@@ -0,0 +1,3 @@
+ALTER TABLE topics ADD COLUMN archived_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS idx_topics_archived_at ON topics(archived_at);
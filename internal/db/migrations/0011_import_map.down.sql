@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_import_map_new_id;
+DROP TABLE IF EXISTS import_map;
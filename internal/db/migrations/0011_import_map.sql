@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS import_map (
+    source TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    old_id INTEGER NOT NULL,
+    new_id INTEGER NOT NULL,
+    PRIMARY KEY (source, kind, old_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_map_new_id ON import_map(kind, new_id);
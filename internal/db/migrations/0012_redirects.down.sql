@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_redirects_target;
+DROP TABLE IF EXISTS redirects;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS redirects (
+    path TEXT PRIMARY KEY,
+    target_type TEXT NOT NULL CHECK (target_type IN ('board', 'topic', 'post')),
+    target_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_redirects_target ON redirects(target_type, target_id);
@@ -0,0 +1,15 @@
+DROP TRIGGER IF EXISTS board_settings_update_version;
+DROP TRIGGER IF EXISTS board_settings_insert_version;
+DROP TRIGGER IF EXISTS boards_update_version;
+DROP TRIGGER IF EXISTS boards_insert_version;
+DROP TRIGGER IF EXISTS topics_delete_board_version;
+DROP TRIGGER IF EXISTS topics_update_board_version;
+DROP TRIGGER IF EXISTS topics_insert_board_version;
+DROP TRIGGER IF EXISTS topics_update_version;
+DROP TRIGGER IF EXISTS posts_delete_version;
+DROP TRIGGER IF EXISTS posts_update_version;
+DROP TRIGGER IF EXISTS posts_insert_version;
+ALTER TABLE topics DROP COLUMN modified_at;
+ALTER TABLE topics DROP COLUMN version;
+ALTER TABLE boards DROP COLUMN modified_at;
+ALTER TABLE boards DROP COLUMN version;
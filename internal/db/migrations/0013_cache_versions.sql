@@ -0,0 +1,72 @@
+-- Version markers for HTTP caching. Triggers bump a topic on any change to
+-- it or its posts and a board on any change to it, its settings or its
+-- topics, so every write path keeps them current.
+ALTER TABLE boards ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE boards ADD COLUMN modified_at DATETIME;
+ALTER TABLE topics ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE topics ADD COLUMN modified_at DATETIME;
+
+UPDATE topics SET modified_at = COALESCE(
+    (SELECT MAX(pub_date) FROM posts WHERE posts.topic_id = topics.id), pub_date, CURRENT_TIMESTAMP);
+UPDATE boards SET modified_at = COALESCE(
+    (SELECT MAX(modified_at) FROM topics WHERE topics.board_id = boards.id), CURRENT_TIMESTAMP);
+
+CREATE TRIGGER IF NOT EXISTS posts_insert_version AFTER INSERT ON posts
+BEGIN
+    UPDATE topics SET version = version + 1, modified_at = CURRENT_TIMESTAMP WHERE id = NEW.topic_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_update_version AFTER UPDATE ON posts
+BEGIN
+    UPDATE topics SET version = version + 1, modified_at = CURRENT_TIMESTAMP
+        WHERE id IN (OLD.topic_id, NEW.topic_id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_delete_version AFTER DELETE ON posts
+BEGIN
+    UPDATE topics SET version = version + 1, modified_at = CURRENT_TIMESTAMP WHERE id = OLD.topic_id;
+END;
+
+-- Updates that already bumped the version come from the triggers above.
+CREATE TRIGGER IF NOT EXISTS topics_update_version AFTER UPDATE ON topics
+WHEN NEW.version = OLD.version
+BEGIN
+    UPDATE topics SET version = version + 1, modified_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS topics_insert_board_version AFTER INSERT ON topics
+BEGIN
+    UPDATE boards SET version = version + 1, modified_at = CURRENT_TIMESTAMP WHERE id = NEW.board_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS topics_update_board_version AFTER UPDATE ON topics
+BEGIN
+    UPDATE boards SET version = version + 1, modified_at = CURRENT_TIMESTAMP
+        WHERE id IN (OLD.board_id, NEW.board_id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS topics_delete_board_version AFTER DELETE ON topics
+BEGIN
+    UPDATE boards SET version = version + 1, modified_at = CURRENT_TIMESTAMP WHERE id = OLD.board_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS boards_insert_version AFTER INSERT ON boards
+BEGIN
+    UPDATE boards SET modified_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS boards_update_version AFTER UPDATE ON boards
+WHEN NEW.version = OLD.version
+BEGIN
+    UPDATE boards SET version = version + 1, modified_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS board_settings_insert_version AFTER INSERT ON board_settings
+BEGIN
+    UPDATE boards SET version = version + 1, modified_at = CURRENT_TIMESTAMP WHERE id = NEW.board_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS board_settings_update_version AFTER UPDATE ON board_settings
+BEGIN
+    UPDATE boards SET version = version + 1, modified_at = CURRENT_TIMESTAMP WHERE id = NEW.board_id;
+END;
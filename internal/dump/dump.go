@@ -0,0 +1,744 @@
+// Package dump exports the forum as JSON Lines and imports such dumps into
+// an empty database.
+//
+// Every line is an object with a "type" naming the record kind and a
+// "data" object holding the record. The first line is a header carrying
+// the format version. Records are written in dependency order (boards,
+// filters, bans, topics, posts, then the redirects and import map entries
+// pointing at them) so that an import can validate and insert them as they
+// are read. Posts cannot be edited, so there are no revisions to export.
+package dump
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"minibb/internal/filters"
+	"minibb/internal/models"
+)
+
+// Version 2 added redirect and import_map records.
+const (
+	Format  = "minibb-dump"
+	Version = 2
+)
+
+// progressInterval is the number of records between progress reports.
+const progressInterval = 1000
+
+type Header struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+type BoardRecord struct {
+	ID          int                   `json:"id"`
+	Slug        string                `json:"slug"`
+	Description string                `json:"description"`
+	Settings    *models.BoardSettings `json:"settings"`
+}
+
+type TopicRecord struct {
+	ID         int        `json:"id"`
+	BoardID    int        `json:"board_id"`
+	Title      string     `json:"title"`
+	Author     string     `json:"author"`
+	PubDate    time.Time  `json:"pub_date"`
+	Status     string     `json:"status"`
+	Visibility string     `json:"visibility"`
+	LastPostID *int       `json:"last_post_id"`
+	PostCount  int        `json:"post_count"`
+	BumpedAt   time.Time  `json:"bumped_at"`
+	ArchivedAt *time.Time `json:"archived_at"`
+}
+
+type PostRecord struct {
+	ID         int       `json:"id"`
+	TopicID    int       `json:"topic_id"`
+	Author     string    `json:"author"`
+	Content    string    `json:"content"`
+	PubDate    time.Time `json:"pub_date"`
+	Visibility string    `json:"visibility"`
+	IP         string    `json:"ip"`
+}
+
+// RedirectRecord keeps an old URL working, such as a topic's route before
+// it was moved or a phpBB URL.
+type RedirectRecord struct {
+	Path       string    `json:"path"`
+	TargetType string    `json:"target_type"`
+	TargetID   int       `json:"target_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ImportMapRecord maps the ID of a record in a forum imported from
+// elsewhere to the ID it was imported as.
+type ImportMapRecord struct {
+	Source string `json:"source"`
+	Kind   string `json:"kind"`
+	OldID  int    `json:"old_id"`
+	NewID  int    `json:"new_id"`
+}
+
+// Stats counts the records written or read so far.
+type Stats struct {
+	Boards    int `json:"boards"`
+	Filters   int `json:"filters"`
+	Bans      int `json:"bans"`
+	Topics    int `json:"topics"`
+	Posts     int `json:"posts"`
+	Redirects int `json:"redirects"`
+	ImportMap int `json:"import_map"`
+}
+
+func (s Stats) Total() int {
+	return s.Boards + s.Filters + s.Bans + s.Topics + s.Posts + s.Redirects + s.ImportMap
+}
+
+func (s *Stats) add(recordType string) {
+	switch recordType {
+	case "board":
+		s.Boards++
+	case "filter":
+		s.Filters++
+	case "ban":
+		s.Bans++
+	case "topic":
+		s.Topics++
+	case "post":
+		s.Posts++
+	case "redirect":
+		s.Redirects++
+	case "import_map":
+		s.ImportMap++
+	}
+}
+
+// Progress is called every thousand records and once at the end of an
+// export or import. It may be nil.
+type Progress func(Stats)
+
+type line struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type writeFunc func(recordType string, record interface{}) error
+
+// Export writes the whole forum to w. It reads in a single transaction, so
+// the dump is a consistent snapshot even while the forum is in use.
+func Export(db *sql.DB, w io.Writer, progress Progress) (Stats, error) {
+	var stats Stats
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return stats, err
+	}
+	defer tx.Rollback()
+
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+
+	write := func(recordType string, record interface{}) error {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if err := encoder.Encode(line{Type: recordType, Data: data}); err != nil {
+			return err
+		}
+		if recordType == "header" {
+			return nil
+		}
+		stats.add(recordType)
+		if progress != nil && stats.Total()%progressInterval == 0 {
+			progress(stats)
+		}
+		return nil
+	}
+
+	header := Header{Format: Format, Version: Version, ExportedAt: time.Now().UTC()}
+	if err := write("header", header); err != nil {
+		return stats, err
+	}
+
+	exports := []struct {
+		name string
+		run  func(models.Querier, writeFunc) error
+	}{
+		{"boards", exportBoards},
+		{"filters", exportFilters},
+		{"bans", exportBans},
+		{"topics", exportTopics},
+		{"posts", exportPosts},
+		{"redirects", exportRedirects},
+		{"import map", exportImportMap},
+	}
+	for _, export := range exports {
+		if err := export.run(tx, write); err != nil {
+			return stats, fmt.Errorf("failed to export %s: %w", export.name, err)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return stats, err
+	}
+	if progress != nil {
+		progress(stats)
+	}
+	return stats, nil
+}
+
+func exportBoards(db models.Querier, write writeFunc) error {
+	boards, err := models.GetAllBoards(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	for _, board := range boards {
+		settings, err := models.GetBoardSettings(context.Background(), db, board.ID)
+		if err != nil {
+			return err
+		}
+		record := BoardRecord{
+			ID:          board.ID,
+			Slug:        board.Slug,
+			Description: board.Description,
+			Settings:    settings,
+		}
+		if err := write("board", record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportFilters(db models.Querier, write writeFunc) error {
+	rules, err := models.GetAllFilterRules(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if err := write("filter", rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportBans(db models.Querier, write writeFunc) error {
+	// Lifted and expired bans are kept for the record.
+	rows, err := db.Query(`SELECT id, ip, reason, created_by, created_at, expires_at, lifted_at
+		FROM bans ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ban models.Ban
+		var expiresAt, liftedAt sql.NullTime
+		if err := rows.Scan(
+			&ban.ID, &ban.IP, &ban.Reason, &ban.CreatedBy, &ban.CreatedAt,
+			&expiresAt, &liftedAt,
+		); err != nil {
+			return err
+		}
+		if expiresAt.Valid {
+			ban.ExpiresAt = &expiresAt.Time
+		}
+		if liftedAt.Valid {
+			ban.LiftedAt = &liftedAt.Time
+		}
+		if err := write("ban", ban); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func exportTopics(db models.Querier, write writeFunc) error {
+	rows, err := db.Query(`SELECT id, board_id, title, author, pub_date, status, visibility,
+		last_post_id, post_count, bumped_at, archived_at FROM topics ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record TopicRecord
+		var archivedAt sql.NullTime
+		if err := rows.Scan(
+			&record.ID, &record.BoardID, &record.Title, &record.Author, &record.PubDate,
+			&record.Status, &record.Visibility, &record.LastPostID, &record.PostCount,
+			&record.BumpedAt, &archivedAt,
+		); err != nil {
+			return err
+		}
+		if archivedAt.Valid {
+			record.ArchivedAt = &archivedAt.Time
+		}
+		if err := write("topic", record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func exportPosts(db models.Querier, write writeFunc) error {
+	rows, err := db.Query(`SELECT id, topic_id, author, content, pub_date, visibility, ip
+		FROM posts ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record PostRecord
+		if err := rows.Scan(
+			&record.ID, &record.TopicID, &record.Author, &record.Content,
+			&record.PubDate, &record.Visibility, &record.IP,
+		); err != nil {
+			return err
+		}
+		if err := write("post", record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func exportRedirects(db models.Querier, write writeFunc) error {
+	rows, err := db.Query(`SELECT path, target_type, target_id, created_at FROM redirects ORDER BY path`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record RedirectRecord
+		if err := rows.Scan(&record.Path, &record.TargetType, &record.TargetID, &record.CreatedAt); err != nil {
+			return err
+		}
+		if err := write("redirect", record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func exportImportMap(db models.Querier, write writeFunc) error {
+	rows, err := db.Query(`SELECT source, kind, old_id, new_id FROM import_map
+		ORDER BY source, kind, old_id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record ImportMapRecord
+		if err := rows.Scan(&record.Source, &record.Kind, &record.OldID, &record.NewID); err != nil {
+			return err
+		}
+		if err := write("import_map", record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// importer validates records against what has been imported so far.
+type importer struct {
+	tx         *sql.Tx
+	stats      Stats
+	header     bool
+	boards     map[int]bool
+	filters    map[int]bool
+	bans       map[int]bool
+	topics     map[int]bool
+	posts      map[int]int // post ID to topic ID
+	lastPostID map[int]int // topic ID to its last_post_id
+	redirects  map[string]bool
+	importMap  map[ImportMapRecord]bool
+}
+
+// Import loads a dump into a database without topics, posts, bans,
+// filters, redirects or import map entries. The boards created by the
+// initial migrations are replaced by the ones in the dump. IDs and timestamps are preserved so links to
+// posts keep working. The import runs in a single transaction, so a
+// failed import leaves the database unchanged.
+func Import(db *sql.DB, r io.Reader, progress Progress) (Stats, error) {
+	var existing int
+	if err := db.QueryRow(`SELECT (SELECT COUNT(*) FROM topics) + (SELECT COUNT(*) FROM posts)
+		+ (SELECT COUNT(*) FROM bans) + (SELECT COUNT(*) FROM filters)
+		+ (SELECT COUNT(*) FROM redirects) + (SELECT COUNT(*) FROM import_map)`).Scan(&existing); err != nil {
+		return Stats{}, err
+	}
+	if existing > 0 {
+		return Stats{}, fmt.Errorf("database is not empty")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return Stats{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM board_settings`); err != nil {
+		return Stats{}, err
+	}
+	if _, err := tx.Exec(`DELETE FROM boards`); err != nil {
+		return Stats{}, err
+	}
+
+	imp := &importer{
+		tx:         tx,
+		boards:     make(map[int]bool),
+		filters:    make(map[int]bool),
+		bans:       make(map[int]bool),
+		topics:     make(map[int]bool),
+		posts:      make(map[int]int),
+		lastPostID: make(map[int]int),
+		redirects:  make(map[string]bool),
+		importMap:  make(map[ImportMapRecord]bool),
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		var l line
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return imp.stats, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if err := imp.record(l); err != nil {
+			return imp.stats, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if l.Type != "header" {
+			imp.stats.add(l.Type)
+			if progress != nil && imp.stats.Total()%progressInterval == 0 {
+				progress(imp.stats)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return imp.stats, err
+	}
+	if !imp.header {
+		return imp.stats, fmt.Errorf("dump is empty")
+	}
+
+	if err := imp.checkLastPosts(); err != nil {
+		return imp.stats, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return imp.stats, err
+	}
+	if progress != nil {
+		progress(imp.stats)
+	}
+	return imp.stats, nil
+}
+
+func (imp *importer) record(l line) error {
+	if !imp.header {
+		if l.Type != "header" {
+			return fmt.Errorf("dump does not start with a header")
+		}
+		var header Header
+		if err := json.Unmarshal(l.Data, &header); err != nil {
+			return err
+		}
+		if header.Format != Format {
+			return fmt.Errorf("not a MiniBB dump")
+		}
+		if header.Version < 1 || header.Version > Version {
+			return fmt.Errorf("unsupported dump version %d", header.Version)
+		}
+		imp.header = true
+		return nil
+	}
+
+	switch l.Type {
+	case "board":
+		var record BoardRecord
+		if err := json.Unmarshal(l.Data, &record); err != nil {
+			return err
+		}
+		return imp.board(record)
+	case "filter":
+		var record models.FilterRule
+		if err := json.Unmarshal(l.Data, &record); err != nil {
+			return err
+		}
+		return imp.filter(record)
+	case "ban":
+		var record models.Ban
+		if err := json.Unmarshal(l.Data, &record); err != nil {
+			return err
+		}
+		return imp.ban(record)
+	case "topic":
+		var record TopicRecord
+		if err := json.Unmarshal(l.Data, &record); err != nil {
+			return err
+		}
+		return imp.topic(record)
+	case "post":
+		var record PostRecord
+		if err := json.Unmarshal(l.Data, &record); err != nil {
+			return err
+		}
+		return imp.post(record)
+	case "redirect":
+		var record RedirectRecord
+		if err := json.Unmarshal(l.Data, &record); err != nil {
+			return err
+		}
+		return imp.redirect(record)
+	case "import_map":
+		var record ImportMapRecord
+		if err := json.Unmarshal(l.Data, &record); err != nil {
+			return err
+		}
+		return imp.importMapEntry(record)
+	}
+	return fmt.Errorf("unknown record type %q", l.Type)
+}
+
+func (imp *importer) board(record BoardRecord) error {
+	if imp.boards[record.ID] {
+		return fmt.Errorf("duplicate board %d", record.ID)
+	}
+	if record.Slug == "" {
+		return fmt.Errorf("board %d has no slug", record.ID)
+	}
+
+	settings := models.DefaultBoardSettings(record.ID)
+	if record.Settings != nil {
+		settings = *record.Settings
+		settings.BoardID = record.ID
+	}
+	if err := settings.Validate(); err != nil {
+		return fmt.Errorf("board %d: %w", record.ID, err)
+	}
+
+	if _, err := imp.tx.Exec(`INSERT INTO boards (id, slug, description) VALUES (?, ?, ?)`,
+		record.ID, record.Slug, record.Description); err != nil {
+		return err
+	}
+
+	_, err := imp.tx.Exec(`INSERT INTO board_settings (board_id, read_only, default_name,
+		max_title_length, max_body_length, max_active_threads, bump_limit,
+		markup_extensions, pow_difficulty, premoderate)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		settings.BoardID, settings.ReadOnly, settings.DefaultName, settings.MaxTitleLength,
+		settings.MaxBodyLength, settings.MaxActiveThreads, settings.BumpLimit,
+		strings.Join(settings.MarkupExtensions, ","), settings.PowDifficulty, settings.Premoderate,
+	)
+	if err != nil {
+		return err
+	}
+
+	imp.boards[record.ID] = true
+	return nil
+}
+
+func (imp *importer) filter(record models.FilterRule) error {
+	if imp.filters[record.ID] {
+		return fmt.Errorf("duplicate filter %d", record.ID)
+	}
+	if record.BoardID != nil && !imp.boards[*record.BoardID] {
+		return fmt.Errorf("filter %d references unknown board %d", record.ID, *record.BoardID)
+	}
+	// As when a rule is saved through the API; the filter store skips
+	// rules that don't compile
+	if _, err := filters.Compile(record); err != nil {
+		return fmt.Errorf("filter %d: %w", record.ID, err)
+	}
+
+	_, err := imp.tx.Exec(`INSERT INTO filters (id, board_id, kind, pattern, action,
+		replacement, max_links, window_minutes, enabled, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.ID, record.BoardID, record.Kind, record.Pattern, record.Action,
+		record.Replacement, record.MaxLinks, record.WindowMinutes, record.Enabled,
+		models.FormatDBTime(record.CreatedAt),
+	)
+	if err != nil {
+		return err
+	}
+
+	imp.filters[record.ID] = true
+	return nil
+}
+
+func (imp *importer) ban(record models.Ban) error {
+	if imp.bans[record.ID] {
+		return fmt.Errorf("duplicate ban %d", record.ID)
+	}
+
+	_, err := imp.tx.Exec(`INSERT INTO bans (id, ip, reason, created_by, created_at,
+		expires_at, lifted_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		record.ID, record.IP, record.Reason, record.CreatedBy,
+		models.FormatDBTime(record.CreatedAt), nullableTime(record.ExpiresAt),
+		nullableTime(record.LiftedAt),
+	)
+	if err != nil {
+		return err
+	}
+
+	imp.bans[record.ID] = true
+	return nil
+}
+
+func (imp *importer) topic(record TopicRecord) error {
+	if imp.topics[record.ID] {
+		return fmt.Errorf("duplicate topic %d", record.ID)
+	}
+	if !imp.boards[record.BoardID] {
+		return fmt.Errorf("topic %d references unknown board %d", record.ID, record.BoardID)
+	}
+	if record.PubDate.IsZero() || record.BumpedAt.IsZero() {
+		return fmt.Errorf("topic %d is missing a timestamp", record.ID)
+	}
+
+	_, err := imp.tx.Exec(`INSERT INTO topics (id, board_id, title, author, pub_date, status,
+		visibility, last_post_id, post_count, bumped_at, archived_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.ID, record.BoardID, record.Title, record.Author,
+		models.FormatDBTime(record.PubDate), record.Status, record.Visibility,
+		record.LastPostID, record.PostCount, models.FormatDBTime(record.BumpedAt),
+		nullableTime(record.ArchivedAt),
+	)
+	if err != nil {
+		return err
+	}
+
+	imp.topics[record.ID] = true
+	if record.LastPostID != nil {
+		imp.lastPostID[record.ID] = *record.LastPostID
+	}
+	return nil
+}
+
+func (imp *importer) post(record PostRecord) error {
+	if _, ok := imp.posts[record.ID]; ok {
+		return fmt.Errorf("duplicate post %d", record.ID)
+	}
+	if !imp.topics[record.TopicID] {
+		return fmt.Errorf("post %d references unknown topic %d", record.ID, record.TopicID)
+	}
+	if record.PubDate.IsZero() {
+		return fmt.Errorf("post %d is missing a timestamp", record.ID)
+	}
+
+	_, err := imp.tx.Exec(`INSERT INTO posts (id, topic_id, author, content, pub_date,
+		visibility, ip, content_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.ID, record.TopicID, record.Author, record.Content,
+		models.FormatDBTime(record.PubDate), record.Visibility, record.IP,
+		models.ContentHash(record.Content),
+	)
+	if err != nil {
+		return err
+	}
+
+	imp.posts[record.ID] = record.TopicID
+	return nil
+}
+
+// exists reports whether a board, topic or post of the given ID has been
+// imported.
+func (imp *importer) exists(targetType string, id int) bool {
+	switch targetType {
+	case models.RedirectBoard:
+		return imp.boards[id]
+	case models.RedirectTopic:
+		return imp.topics[id]
+	case models.RedirectPost:
+		_, ok := imp.posts[id]
+		return ok
+	}
+	return false
+}
+
+func (imp *importer) redirect(record RedirectRecord) error {
+	if imp.redirects[record.Path] {
+		return fmt.Errorf("duplicate redirect %s", record.Path)
+	}
+	if !imp.exists(record.TargetType, record.TargetID) {
+		return fmt.Errorf("redirect %s references unknown %s %d", record.Path, record.TargetType, record.TargetID)
+	}
+	if record.CreatedAt.IsZero() {
+		return fmt.Errorf("redirect %s is missing a timestamp", record.Path)
+	}
+
+	_, err := imp.tx.Exec(`INSERT INTO redirects (path, target_type, target_id, created_at)
+		VALUES (?, ?, ?, ?)`,
+		record.Path, record.TargetType, record.TargetID, models.FormatDBTime(record.CreatedAt),
+	)
+	if err != nil {
+		return err
+	}
+
+	imp.redirects[record.Path] = true
+	return nil
+}
+
+// importMapKinds are the kinds of import map entries and what their new
+// IDs refer to.
+var importMapKinds = map[string]string{
+	"forum": models.RedirectBoard,
+	"topic": models.RedirectTopic,
+	"post":  models.RedirectPost,
+}
+
+func (imp *importer) importMapEntry(record ImportMapRecord) error {
+	key := ImportMapRecord{Source: record.Source, Kind: record.Kind, OldID: record.OldID}
+	if imp.importMap[key] {
+		return fmt.Errorf("duplicate import map entry for %s %s %d", record.Source, record.Kind, record.OldID)
+	}
+	targetType, ok := importMapKinds[record.Kind]
+	if !ok {
+		return fmt.Errorf("import map entry for %s %d has unknown kind %q", record.Source, record.OldID, record.Kind)
+	}
+	if !imp.exists(targetType, record.NewID) {
+		return fmt.Errorf("import map entry for %s %s %d references unknown %s %d",
+			record.Source, record.Kind, record.OldID, targetType, record.NewID)
+	}
+
+	_, err := imp.tx.Exec(`INSERT INTO import_map (source, kind, old_id, new_id) VALUES (?, ?, ?, ?)`,
+		record.Source, record.Kind, record.OldID, record.NewID)
+	if err != nil {
+		return err
+	}
+
+	imp.importMap[key] = true
+	return nil
+}
+
+// checkLastPosts verifies that every topic's last_post_id points at one of
+// its own posts. Posts follow topics in a dump, so this can only be
+// checked once everything has been read.
+func (imp *importer) checkLastPosts() error {
+	for topicID, postID := range imp.lastPostID {
+		if imp.posts[postID] != topicID {
+			return fmt.Errorf("topic %d has last post %d which is not one of its posts", topicID, postID)
+		}
+	}
+	return nil
+}
+
+func nullableTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return models.FormatDBTime(*t)
+}
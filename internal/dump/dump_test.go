@@ -0,0 +1,171 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"minibb/internal/db"
+	"minibb/internal/models"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	config := db.DefaultConfig()
+	config.Path = filepath.Join(t.TempDir(), "test.db")
+	database, err := db.Init(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+// populate writes one record of every kind the dump carries.
+func populate(t *testing.T, database *sql.DB) {
+	t.Helper()
+	ctx := context.Background()
+
+	settings := models.DefaultBoardSettings(2)
+	settings.Premoderate = true
+	settings.PowDifficulty = 12
+	if err := models.SaveBoardSettings(ctx, database, settings); err != nil {
+		t.Fatal(err)
+	}
+	boardID := 1
+	if _, err := models.CreateFilterRule(ctx, database, models.FilterRule{
+		BoardID: &boardID, Kind: "word", Pattern: "(?i)spam", Action: "replace", Replacement: "***", Enabled: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.CreateBan(ctx, database, "10.0.0.9", "spam", "alice", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	topic, err := models.CreateTopic(ctx, database, 1, "Hello", "bob", "First post", "10.0.0.1", models.VisibilityVisible)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.CreatePost(ctx, database, topic.ID, "carol", "A reply", "10.0.0.2", models.VisibilityVisible, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.CreatePost(ctx, database, topic.ID, "dave", "Held reply", "10.0.0.3", models.VisibilityPending, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.CreateTopic(ctx, database, 2, "Waiting", "erin", "Held topic", "10.0.0.4", models.VisibilityPending); err != nil {
+		t.Fatal(err)
+	}
+	// Moving leaves a redirect from the old route
+	if err := models.MoveTopic(ctx, database, topic.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Exec(`INSERT INTO import_map (source, kind, old_id, new_id) VALUES ('phpbb', 'topic', 7, ?)`, topic.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// records returns the lines of a dump without its header, which carries
+// the export time.
+func records(t *testing.T, dump []byte) []string {
+	t.Helper()
+	lines := strings.Split(strings.TrimSpace(string(dump)), "\n")
+	if !strings.HasPrefix(lines[0], `{"type":"header"`) {
+		t.Fatalf("dump starts with %s", lines[0])
+	}
+	return lines[1:]
+}
+
+func TestRoundTrip(t *testing.T) {
+	source := openTestDB(t)
+	populate(t, source)
+
+	var first bytes.Buffer
+	stats, err := Export(source, &first, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{Boards: 2, Filters: 1, Bans: 1, Topics: 2, Posts: 4, Redirects: 1, ImportMap: 1}
+	if stats != want {
+		t.Errorf("exported %+v, want %+v", stats, want)
+	}
+
+	target := openTestDB(t)
+	imported, err := Import(target, bytes.NewReader(first.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != want {
+		t.Errorf("imported %+v, want %+v", imported, want)
+	}
+
+	var second bytes.Buffer
+	if _, err := Export(target, &second, nil); err != nil {
+		t.Fatal(err)
+	}
+	a, b := records(t, first.Bytes()), records(t, second.Bytes())
+	if len(a) != len(b) {
+		t.Fatalf("%d records exported after import, want %d", len(b), len(a))
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Errorf("record %d differs after import:\n%s\n%s", i, a[i], b[i])
+		}
+	}
+
+	// Old links keep working
+	path, err := models.ResolveRedirect(context.Background(), target, []string{models.TopicPath("general", 1)})
+	if err != nil || path != models.TopicPath("watercooler", 1) {
+		t.Errorf("redirect resolves to %q, %v", path, err)
+	}
+}
+
+func TestImportRejects(t *testing.T) {
+	header := `{"type":"header","data":{"format":"minibb-dump","version":2}}` + "\n"
+	board := `{"type":"board","data":{"id":1,"slug":"general"}}` + "\n"
+	tests := []struct {
+		name, dump, err string
+	}{
+		{"empty", "", "dump is empty"},
+		{"no header", board, "does not start with a header"},
+		{"newer version", `{"type":"header","data":{"format":"minibb-dump","version":99}}`, "unsupported dump version 99"},
+		{"unknown type", header + `{"type":"revision","data":{}}`, `unknown record type "revision"`},
+		{"bad pattern", header + board + `{"type":"filter","data":{"id":1,"kind":"word","pattern":"(","action":"reject"}}`, "filter 1: invalid pattern"},
+		{"unknown kind", header + `{"type":"filter","data":{"id":1,"kind":"captcha","action":"reject"}}`, `filter 1: unknown filter kind "captcha"`},
+		{"unknown board", header + `{"type":"topic","data":{"id":1,"board_id":5,"title":"x","status":"open","visibility":"visible"}}`, "unknown board 5"},
+		{"orphan post", header + board + `{"type":"post","data":{"id":1,"topic_id":3,"content":"x","visibility":"visible"}}`, "topic 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := openTestDB(t)
+			_, err := Import(database, strings.NewReader(tt.dump), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.err)
+			}
+
+			// The failed import left the initial boards in place
+			boards, err := models.GetAllBoards(context.Background(), database)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(boards) != 2 {
+				t.Errorf("%d boards after a failed import, want 2", len(boards))
+			}
+		})
+	}
+}
+
+func TestImportNotEmpty(t *testing.T) {
+	source := openTestDB(t)
+	populate(t, source)
+	var dump bytes.Buffer
+	if _, err := Export(source, &dump, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Import(source, &dump, nil); err == nil || err.Error() != "database is not empty" {
+		t.Errorf("got %v, want the non-empty database refused", err)
+	}
+}
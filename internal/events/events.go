@@ -0,0 +1,66 @@
+// Package events tells the parts of the server that keep state derived
+// from the database, such as the response cache, about committed writes.
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// Event describes a committed write.
+type Event struct {
+	// Kind names the write, for example "post.create" or "topic.move".
+	Kind string
+	// BoardID is the board whose content changed, or 0 if the write may
+	// have changed any board.
+	BoardID int
+	// TopicID is the topic whose content changed, or 0 if no single
+	// topic did.
+	TopicID int
+}
+
+// Hub passes events to its subscribers. Publish calls them synchronously,
+// so by the time a write's response is sent nothing derived from the old
+// data is served any more.
+type Hub struct {
+	mu          sync.RWMutex
+	subscribers []func(Event)
+}
+
+func NewHub() *Hub {
+	return &Hub{}
+}
+
+// Subscribe calls fn for every event published from now on. fn must not
+// block.
+func (h *Hub) Subscribe(fn func(Event)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers = append(h.subscribers, fn)
+}
+
+func (h *Hub) Publish(events ...Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, e := range events {
+		for _, fn := range h.subscribers {
+			fn(e)
+		}
+	}
+}
+
+type contextKey string
+
+const hubContextKey contextKey = "events"
+
+func WithHub(ctx context.Context, h *Hub) context.Context {
+	return context.WithValue(ctx, hubContextKey, h)
+}
+
+func FromContext(ctx context.Context) *Hub {
+	h, ok := ctx.Value(hubContextKey).(*Hub)
+	if !ok {
+		panic("event hub not found in context")
+	}
+	return h
+}
@@ -0,0 +1,284 @@
+// Package filters implements the content filter pipeline every new post
+// runs through before it is written.
+package filters
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"minibb/internal/logging"
+	"minibb/internal/models"
+)
+
+type Action string
+
+const (
+	ActionReplace Action = "replace"
+	ActionReject  Action = "reject"
+	ActionHold    Action = "hold"
+)
+
+const (
+	KindWord      = "word"
+	KindLinks     = "links"
+	KindDuplicate = "duplicate"
+)
+
+// Submission is a post about to be written. Filters may rewrite Title and
+// Content in place.
+type Submission struct {
+	BoardID int
+	TopicID int
+	Title   string
+	Author  string
+	Content string
+	IP      string
+}
+
+// Outcome is the decision of a single filter. The zero value accepts the
+// submission unchanged.
+type Outcome struct {
+	Action Action
+	Reason string
+}
+
+type Filter interface {
+	Name() string
+	Apply(ctx context.Context, db *sql.DB, sub *Submission) (Outcome, error)
+}
+
+// Result is the combined decision of a pipeline run.
+type Result struct {
+	Rejected bool
+	Held     bool
+	Reason   string
+}
+
+type Pipeline struct {
+	filters []Filter
+}
+
+func NewPipeline(filters ...Filter) *Pipeline {
+	return &Pipeline{filters: filters}
+}
+
+// Run applies all filters in order. A rejection stops the pipeline; a hold
+// is remembered but later filters still run so they can reject.
+func (p *Pipeline) Run(ctx context.Context, db *sql.DB, sub *Submission) (Result, error) {
+	var result Result
+	for _, filter := range p.filters {
+		outcome, err := filter.Apply(ctx, db, sub)
+		if err != nil {
+			return Result{}, fmt.Errorf("filter %s: %w", filter.Name(), err)
+		}
+		switch outcome.Action {
+		case ActionReject:
+			return Result{Rejected: true, Reason: outcome.Reason}, nil
+		case ActionHold:
+			if !result.Held {
+				result.Held = true
+				result.Reason = outcome.Reason
+			}
+		}
+	}
+	return result, nil
+}
+
+type WordFilter struct {
+	Pattern     *regexp.Regexp
+	Action      Action
+	Replacement string
+}
+
+func (f *WordFilter) Name() string {
+	return "word:" + f.Pattern.String()
+}
+
+func (f *WordFilter) Apply(ctx context.Context, db *sql.DB, sub *Submission) (Outcome, error) {
+	if !f.Pattern.MatchString(sub.Title) && !f.Pattern.MatchString(sub.Content) {
+		return Outcome{}, nil
+	}
+	if f.Action == ActionReplace {
+		sub.Title = f.Pattern.ReplaceAllString(sub.Title, f.Replacement)
+		sub.Content = f.Pattern.ReplaceAllString(sub.Content, f.Replacement)
+		return Outcome{Action: ActionReplace}, nil
+	}
+	return Outcome{Action: f.Action, Reason: "post contains a filtered word"}, nil
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://`)
+
+type LinkLimitFilter struct {
+	MaxLinks int
+	Action   Action
+}
+
+func (f *LinkLimitFilter) Name() string {
+	return "links"
+}
+
+func (f *LinkLimitFilter) Apply(ctx context.Context, db *sql.DB, sub *Submission) (Outcome, error) {
+	if len(linkPattern.FindAllStringIndex(sub.Content, -1)) <= f.MaxLinks {
+		return Outcome{}, nil
+	}
+	return Outcome{
+		Action: f.Action,
+		Reason: fmt.Sprintf("post contains more than %d links", f.MaxLinks),
+	}, nil
+}
+
+type DuplicateFilter struct {
+	Window time.Duration
+	Action Action
+}
+
+func (f *DuplicateFilter) Name() string {
+	return "duplicate"
+}
+
+func (f *DuplicateFilter) Apply(ctx context.Context, db *sql.DB, sub *Submission) (Outcome, error) {
+	if sub.IP == "" {
+		// Clients without an address can't be told apart
+		return Outcome{}, nil
+	}
+	count, err := models.CountRecentDuplicatePosts(
+		ctx, db, models.ContentHash(sub.Content), sub.IP, time.Now().Add(-f.Window),
+	)
+	if err != nil {
+		return Outcome{}, err
+	}
+	if count == 0 {
+		return Outcome{}, nil
+	}
+	return Outcome{Action: f.Action, Reason: "duplicate post"}, nil
+}
+
+// Compile turns a stored filter rule into a filter.
+func Compile(rule models.FilterRule) (Filter, error) {
+	action := Action(rule.Action)
+	switch rule.Kind {
+	case KindWord:
+		if action != ActionReplace && action != ActionReject && action != ActionHold {
+			return nil, fmt.Errorf("word filters support replace, reject and hold actions")
+		}
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		return &WordFilter{Pattern: pattern, Action: action, Replacement: rule.Replacement}, nil
+	case KindLinks:
+		if action != ActionReject && action != ActionHold {
+			return nil, fmt.Errorf("link filters support reject and hold actions")
+		}
+		if rule.MaxLinks < 0 {
+			return nil, fmt.Errorf("max_links must not be negative")
+		}
+		return &LinkLimitFilter{MaxLinks: rule.MaxLinks, Action: action}, nil
+	case KindDuplicate:
+		if action != ActionReject && action != ActionHold {
+			return nil, fmt.Errorf("duplicate filters support reject and hold actions")
+		}
+		if rule.WindowMinutes <= 0 {
+			return nil, fmt.Errorf("window_minutes must be positive")
+		}
+		return &DuplicateFilter{
+			Window: time.Duration(rule.WindowMinutes) * time.Minute,
+			Action: action,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown filter kind %q", rule.Kind)
+}
+
+// Store caches the compiled filter rules. Call Invalidate after changing
+// rules so the next post picks them up. Rules that fail to compile are
+// skipped.
+type Store struct {
+	mu    sync.Mutex
+	rules []compiledRule
+	valid bool
+}
+
+type compiledRule struct {
+	boardID *int
+	filter  Filter
+}
+
+func NewStore() *Store {
+	return &Store{}
+}
+
+func (s *Store) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.valid = false
+	s.rules = nil
+}
+
+func (s *Store) load(ctx context.Context, db *sql.DB) ([]compiledRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.valid {
+		return s.rules, nil
+	}
+
+	rules, err := models.GetAllFilterRules(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var compiled []compiledRule
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		filter, err := Compile(rule)
+		if err != nil {
+			// Rules are validated when saved, so this is a row written by
+			// hand or by an older version. Posting goes on without it; the
+			// admin filter list shows the error.
+			logging.FromContext(ctx).Warn("skipping invalid filter", "filter", rule.ID, "error", err)
+			continue
+		}
+		compiled = append(compiled, compiledRule{boardID: rule.BoardID, filter: filter})
+	}
+
+	s.rules = compiled
+	s.valid = true
+	return compiled, nil
+}
+
+// Pipeline returns the filters that apply to the given board.
+func (s *Store) Pipeline(ctx context.Context, db *sql.DB, boardID int) (*Pipeline, error) {
+	rules, err := s.load(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var filters []Filter
+	for _, rule := range rules {
+		if rule.boardID == nil || *rule.boardID == boardID {
+			filters = append(filters, rule.filter)
+		}
+	}
+	return NewPipeline(filters...), nil
+}
+
+type contextKey string
+
+const storeContextKey contextKey = "filters"
+
+func WithStore(ctx context.Context, s *Store) context.Context {
+	return context.WithValue(ctx, storeContextKey, s)
+}
+
+func FromContext(ctx context.Context) *Store {
+	s, ok := ctx.Value(storeContextKey).(*Store)
+	if !ok {
+		panic("filter store not found in context")
+	}
+	return s
+}
@@ -0,0 +1,196 @@
+package filters
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"minibb/internal/db"
+	"minibb/internal/models"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	config := db.DefaultConfig()
+	config.Path = filepath.Join(t.TempDir(), "test.db")
+	database, err := db.Init(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		rule models.FilterRule
+		err  string
+	}{
+		{models.FilterRule{Kind: KindWord, Pattern: `(?i)spam`, Action: "replace"}, ""},
+		{models.FilterRule{Kind: KindWord, Pattern: `(`, Action: "reject"}, "invalid pattern"},
+		{models.FilterRule{Kind: KindWord, Pattern: `x`, Action: "delete"}, "word filters support"},
+		{models.FilterRule{Kind: KindLinks, MaxLinks: 2, Action: "hold"}, ""},
+		{models.FilterRule{Kind: KindLinks, MaxLinks: 2, Action: "replace"}, "link filters support"},
+		{models.FilterRule{Kind: KindLinks, MaxLinks: -1, Action: "reject"}, "max_links"},
+		{models.FilterRule{Kind: KindDuplicate, WindowMinutes: 10, Action: "reject"}, ""},
+		{models.FilterRule{Kind: KindDuplicate, Action: "reject"}, "window_minutes"},
+		{models.FilterRule{Kind: "captcha", Action: "reject"}, "unknown filter kind"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.rule)
+		if tt.err == "" && err != nil {
+			t.Errorf("Compile(%+v): %v", tt.rule, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("Compile(%+v) = %v, want an error containing %q", tt.rule, err, tt.err)
+		}
+	}
+}
+
+func compile(t *testing.T, rule models.FilterRule) Filter {
+	t.Helper()
+	filter, err := Compile(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filter
+}
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	replace := compile(t, models.FilterRule{Kind: KindWord, Pattern: `(?i)darn`, Action: "replace", Replacement: "***"})
+	hold := compile(t, models.FilterRule{Kind: KindLinks, MaxLinks: 1, Action: "hold"})
+	reject := compile(t, models.FilterRule{Kind: KindWord, Pattern: `casino`, Action: "reject"})
+	pipeline := NewPipeline(replace, hold, reject)
+
+	sub := &Submission{Title: "Darn it", Content: "darn http://a.example"}
+	result, err := pipeline.Run(ctx, nil, sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Rejected || result.Held {
+		t.Errorf("result %+v, want accepted", result)
+	}
+	if sub.Title != "*** it" || sub.Content != "*** http://a.example" {
+		t.Errorf("not replaced: %q, %q", sub.Title, sub.Content)
+	}
+
+	// A hold is reported, but a later filter can still reject
+	sub = &Submission{Content: "http://a.example http://b.example"}
+	if result, err = pipeline.Run(ctx, nil, sub); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Held || result.Rejected || !strings.Contains(result.Reason, "more than 1 links") {
+		t.Errorf("result %+v, want held for links", result)
+	}
+	sub = &Submission{Content: "http://a.example http://b.example casino"}
+	if result, err = pipeline.Run(ctx, nil, sub); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Rejected || result.Reason != "post contains a filtered word" {
+		t.Errorf("result %+v, want rejected", result)
+	}
+}
+
+func TestDuplicateFilter(t *testing.T) {
+	ctx := context.Background()
+	database := openTestDB(t)
+	filter := compile(t, models.FilterRule{Kind: KindDuplicate, WindowMinutes: 10, Action: "reject"})
+
+	if _, err := models.CreateTopic(ctx, database, 1, "First", "alice", "Same  text", "10.0.0.1", models.VisibilityVisible); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		content, ip string
+		duplicate   bool
+	}{
+		{"Same text", "10.0.0.1", true},
+		{"Different text", "10.0.0.1", false},
+		{"Same text", "10.0.0.2", false},
+		// Clients without an address, as on an untrusted Unix socket,
+		// are not all taken for one
+		{"Same text", "", false},
+	}
+	for _, tt := range tests {
+		outcome, err := filter.Apply(ctx, database, &Submission{BoardID: 1, Content: tt.content, IP: tt.ip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := outcome.Action == ActionReject; got != tt.duplicate {
+			t.Errorf("%q from %s: outcome %+v", tt.content, tt.ip, outcome)
+		}
+	}
+}
+
+// TestStoreSkipsInvalidRules checks that a stored rule that no longer
+// compiles is left out instead of failing every post.
+func TestStoreSkipsInvalidRules(t *testing.T) {
+	ctx := context.Background()
+	database := openTestDB(t)
+	for _, rule := range []struct {
+		board                 interface{}
+		kind, pattern, action string
+		enabled               bool
+	}{
+		{nil, KindWord, "global", "reject", true},
+		{1, KindWord, "first", "reject", true},
+		{2, KindWord, "second", "reject", true},
+		{nil, KindWord, "(", "reject", true},
+		{nil, "captcha", "", "reject", true},
+		{nil, KindWord, "disabled", "reject", false},
+	} {
+		if _, err := database.Exec(
+			"INSERT INTO filters (board_id, kind, pattern, action, enabled) VALUES (?, ?, ?, ?, ?)",
+			rule.board, rule.kind, rule.pattern, rule.action, rule.enabled,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := NewStore()
+	rejects := func(boardID int, content string) bool {
+		t.Helper()
+		pipeline, err := store.Pipeline(ctx, database, boardID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := pipeline.Run(ctx, database, &Submission{BoardID: boardID, Content: content})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Rejected
+	}
+
+	for _, tt := range []struct {
+		board   int
+		content string
+		want    bool
+	}{
+		{1, "global", true},
+		{2, "global", true},
+		{1, "first", true},
+		{2, "first", false},
+		{2, "second", true},
+		{1, "disabled", false},
+		{1, "harmless", false},
+	} {
+		if got := rejects(tt.board, tt.content); got != tt.want {
+			t.Errorf("board %d, %q: rejected = %v, want %v", tt.board, tt.content, got, tt.want)
+		}
+	}
+
+	// Rules are cached until invalidated
+	if _, err := database.Exec("UPDATE filters SET enabled = 1 WHERE pattern = 'disabled'"); err != nil {
+		t.Fatal(err)
+	}
+	if rejects(1, "disabled") {
+		t.Error("rule change seen before Invalidate")
+	}
+	store.Invalidate()
+	if !rejects(1, "disabled") {
+		t.Error("rule change not seen after Invalidate")
+	}
+}
@@ -0,0 +1,260 @@
+// Package fsck checks the denormalized data in the database against the
+// rows it is derived from and optionally repairs it. Board topic and post
+// counts are computed when read rather than stored, and there is no
+// full-text index, so neither has anything to check.
+package fsck
+
+import (
+	"database/sql"
+	"fmt"
+
+	"minibb/internal/models"
+)
+
+// Discrepancy describes a single value that does not match its source.
+// Expected and Actual are nil when the value is missing altogether.
+type Discrepancy struct {
+	Check      string      `json:"check"`
+	TargetType string      `json:"target_type"`
+	TargetID   int         `json:"target_id"`
+	Expected   interface{} `json:"expected"`
+	Actual     interface{} `json:"actual"`
+}
+
+type Report struct {
+	Discrepancies []Discrepancy `json:"discrepancies"`
+	Fixed         bool          `json:"fixed"`
+}
+
+func (r *Report) OK() bool {
+	return len(r.Discrepancies) == 0
+}
+
+// Summary counts the discrepancies by check, which is what the mod log
+// records for a repair.
+func (r *Report) Summary() map[string]int {
+	summary := map[string]int{}
+	for _, d := range r.Discrepancies {
+		summary[d.Check]++
+	}
+	return summary
+}
+
+type check struct {
+	name       string
+	targetType string
+	// detect returns one row per discrepancy with the columns id,
+	// expected and actual.
+	detect string
+	// fix repairs a single discrepancy.
+	fix func(tx *sql.Tx, d Discrepancy) error
+}
+
+var checks = []check{
+	{
+		name:       "orphaned_post",
+		targetType: "post",
+		detect: `SELECT p.id, NULL, p.topic_id FROM posts p
+			LEFT JOIN topics t ON t.id = p.topic_id WHERE t.id IS NULL`,
+		fix: func(tx *sql.Tx, d Discrepancy) error {
+			_, err := tx.Exec(`DELETE FROM posts WHERE id = ?`, d.TargetID)
+			return err
+		},
+	},
+	{
+		// Topics without posts are hidden rather than deleted, so that an
+		// admin can still look at them and nothing is lost to a bad check.
+		name:       "empty_topic",
+		targetType: "topic",
+		detect: `SELECT t.id, 'hidden', t.visibility FROM topics t
+			WHERE t.visibility != 'hidden'
+			AND NOT EXISTS (SELECT 1 FROM posts WHERE topic_id = t.id)`,
+		fix: func(tx *sql.Tx, d Discrepancy) error {
+			_, err := tx.Exec(`UPDATE topics SET visibility = 'hidden' WHERE id = ?`, d.TargetID)
+			return err
+		},
+	},
+	{
+		name:       "topic_visibility",
+		targetType: "topic",
+		detect: `SELECT t.id, p.visibility, t.visibility FROM topics t
+			JOIN posts p ON p.id = (SELECT MIN(id) FROM posts WHERE topic_id = t.id)
+			WHERE p.visibility != t.visibility`,
+		fix: func(tx *sql.Tx, d Discrepancy) error {
+			_, err := tx.Exec(`UPDATE topics SET visibility = ? WHERE id = ?`, d.Expected, d.TargetID)
+			return err
+		},
+	},
+	{
+		name:       "topic_post_count",
+		targetType: "topic",
+		detect: `SELECT t.id, c.count, t.post_count FROM topics t
+			JOIN (SELECT t2.id AS topic_id, COUNT(p.id) AS count FROM topics t2
+				LEFT JOIN posts p ON p.topic_id = t2.id AND p.visibility = 'visible'
+				GROUP BY t2.id) c ON c.topic_id = t.id
+			WHERE t.post_count IS NOT c.count`,
+		fix: func(tx *sql.Tx, d Discrepancy) error {
+			_, err := tx.Exec(`UPDATE topics SET post_count = ? WHERE id = ?`, d.Expected, d.TargetID)
+			return err
+		},
+	},
+	{
+		name:       "topic_last_post_id",
+		targetType: "topic",
+		detect: `SELECT t.id, m.last_post_id, t.last_post_id FROM topics t
+			JOIN (SELECT t2.id AS topic_id, MAX(p.id) AS last_post_id FROM topics t2
+				LEFT JOIN posts p ON p.topic_id = t2.id AND p.visibility = 'visible'
+				GROUP BY t2.id) m ON m.topic_id = t.id
+			WHERE t.last_post_id IS NOT m.last_post_id`,
+		fix: func(tx *sql.Tx, d Discrepancy) error {
+			_, err := tx.Exec(`UPDATE topics SET last_post_id = ? WHERE id = ?`, d.Expected, d.TargetID)
+			return err
+		},
+	},
+	{
+		name:       "topic_bumped_at",
+		targetType: "topic",
+		detect:     `SELECT id, pub_date, NULL FROM topics WHERE bumped_at IS NULL`,
+		fix: func(tx *sql.Tx, d Discrepancy) error {
+			_, err := tx.Exec(`UPDATE topics SET bumped_at = pub_date WHERE id = ?`, d.TargetID)
+			return err
+		},
+	},
+	{
+		// A board's modified_at is its Last-Modified; behind its topics,
+		// clients would be told their stale copies are current.
+		name:       "board_modified_at",
+		targetType: "board",
+		detect: `SELECT b.id, m.modified_at, b.modified_at FROM boards b
+			JOIN (SELECT board_id, MAX(modified_at) AS modified_at FROM topics
+				GROUP BY board_id) m ON m.board_id = b.id
+			WHERE b.modified_at IS NULL OR julianday(b.modified_at) < julianday(m.modified_at)`,
+		fix: func(tx *sql.Tx, d Discrepancy) error {
+			_, err := tx.Exec(`UPDATE boards SET modified_at = ? WHERE id = ?`, d.Expected, d.TargetID)
+			return err
+		},
+	},
+	{
+		name:       "board_settings",
+		targetType: "board",
+		detect: `SELECT b.id, 'defaults', NULL FROM boards b
+			LEFT JOIN board_settings s ON s.board_id = b.id WHERE s.board_id IS NULL`,
+		fix: func(tx *sql.Tx, d Discrepancy) error {
+			_, err := tx.Exec(`INSERT INTO board_settings (board_id) VALUES (?)`, d.TargetID)
+			return err
+		},
+	},
+}
+
+// Run checks the database and, if fix is set, repairs every discrepancy in
+// a single transaction. Without fix the database is left untouched.
+func Run(db *sql.DB, fix bool) (*Report, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report, err := RunTx(tx, fix)
+	if err != nil {
+		return nil, err
+	}
+
+	if fix {
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		report.Fixed = true
+	}
+
+	return report, nil
+}
+
+// RunTx is Run within the caller's transaction, which the caller commits
+// to keep the repairs.
+func RunTx(tx *sql.Tx, fix bool) (*Report, error) {
+	report := &Report{Discrepancies: []Discrepancy{}}
+	for _, c := range checks {
+		found, err := detect(tx, c)
+		if err != nil {
+			return nil, fmt.Errorf("check %s: %w", c.name, err)
+		}
+		if fix {
+			for _, d := range found {
+				if err := c.fix(tx, d); err != nil {
+					return nil, fmt.Errorf("fix %s for %s %d: %w", c.name, d.TargetType, d.TargetID, err)
+				}
+			}
+		}
+		report.Discrepancies = append(report.Discrepancies, found...)
+	}
+
+	hashes, err := checkContentHashes(tx, fix)
+	if err != nil {
+		return nil, fmt.Errorf("check content_hash: %w", err)
+	}
+	report.Discrepancies = append(report.Discrepancies, hashes...)
+
+	return report, nil
+}
+
+func detect(tx *sql.Tx, c check) ([]Discrepancy, error) {
+	rows, err := tx.Query(c.detect)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found []Discrepancy
+	for rows.Next() {
+		d := Discrepancy{Check: c.name, TargetType: c.targetType}
+		if err := rows.Scan(&d.TargetID, &d.Expected, &d.Actual); err != nil {
+			return nil, err
+		}
+		found = append(found, d)
+	}
+
+	return found, rows.Err()
+}
+
+// checkContentHashes recomputes the duplicate detection hash of every post.
+// The hash is computed in Go, so this cannot be expressed as a query.
+func checkContentHashes(tx *sql.Tx, fix bool) ([]Discrepancy, error) {
+	rows, err := tx.Query(`SELECT id, content, content_hash FROM posts`)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Discrepancy
+	for rows.Next() {
+		var id int
+		var content, hash string
+		if err := rows.Scan(&id, &content, &hash); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if expected := models.ContentHash(content); expected != hash {
+			found = append(found, Discrepancy{
+				Check:      "post_content_hash",
+				TargetType: "post",
+				TargetID:   id,
+				Expected:   expected,
+				Actual:     hash,
+			})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if fix {
+		for _, d := range found {
+			if _, err := tx.Exec(`UPDATE posts SET content_hash = ? WHERE id = ?`, d.Expected, d.TargetID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return found, nil
+}
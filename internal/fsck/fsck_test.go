@@ -0,0 +1,141 @@
+package fsck
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"minibb/internal/db"
+	"minibb/internal/models"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	config := db.DefaultConfig()
+	config.Path = filepath.Join(t.TempDir(), "test.db")
+	database, err := db.Init(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func exec(t *testing.T, database *sql.DB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := database.Exec(query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunClean(t *testing.T) {
+	database := openTestDB(t)
+	ctx := context.Background()
+
+	topic, err := models.CreateTopic(ctx, database, 1, "Hello", "alice", "first", "10.0.0.1", models.VisibilityVisible)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.CreatePost(ctx, database, topic.ID, "bob", "reply", "10.0.0.2", models.VisibilityVisible, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.CreatePost(ctx, database, topic.ID, "carol", "held", "10.0.0.3", models.VisibilityPending, true); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Run(database, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("discrepancies in a consistent database: %+v", report.Discrepancies)
+	}
+}
+
+func TestRunDetectsAndFixes(t *testing.T) {
+	database := openTestDB(t)
+	ctx := context.Background()
+
+	topic, err := models.CreateTopic(ctx, database, 1, "Hello", "alice", "first", "10.0.0.1", models.VisibilityVisible)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := models.CreatePost(ctx, database, topic.ID, "bob", "reply", "10.0.0.2", models.VisibilityVisible, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := models.CreateTopic(ctx, database, 2, "Empty", "alice", "gone", "10.0.0.1", models.VisibilityVisible)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exec(t, database, `UPDATE topics SET post_count = 7, last_post_id = NULL WHERE id = ?`, topic.ID)
+	exec(t, database, `UPDATE posts SET content_hash = 'stale' WHERE id = ?`, reply.ID)
+	exec(t, database, `DELETE FROM posts WHERE topic_id = ?`, empty.ID)
+	exec(t, database, `DELETE FROM board_settings WHERE board_id = 2`)
+
+	// Deleting the empty topic's posts also leaves its counters behind.
+	want := map[string]int{
+		"topic_post_count":   2,
+		"topic_last_post_id": 2,
+		"post_content_hash":  1,
+		"empty_topic":        1,
+		"board_settings":     1,
+	}
+
+	report, err := Run(database, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Fixed {
+		t.Error("report without fix marked as fixed")
+	}
+	summary := report.Summary()
+	for check, count := range want {
+		if summary[check] != count {
+			t.Errorf("%s: found %d discrepancies, want %d (%+v)", check, summary[check], count, report.Discrepancies)
+		}
+	}
+
+	// A check without fix must leave the database alone.
+	again, err := Run(database, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Discrepancies) != len(report.Discrepancies) {
+		t.Fatalf("check changed the database: %d discrepancies, then %d",
+			len(report.Discrepancies), len(again.Discrepancies))
+	}
+
+	fixed, err := Run(database, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fixed.Fixed {
+		t.Error("report with fix not marked as fixed")
+	}
+
+	after, err := Run(database, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.OK() {
+		t.Fatalf("discrepancies left after fix: %+v", after.Discrepancies)
+	}
+
+	got, err := models.GetTopicByID(ctx, database, topic.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.PostCount != 2 || got.LastPostID == nil || *got.LastPostID != reply.ID {
+		t.Errorf("topic counters = %d, %v; want 2, %d", got.PostCount, got.LastPostID, reply.ID)
+	}
+	hidden, err := models.GetTopicByID(ctx, database, empty.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hidden.Visibility != models.VisibilityHidden {
+		t.Errorf("empty topic visibility = %q, want hidden", hidden.Visibility)
+	}
+}
@@ -0,0 +1,480 @@
+package handlers
+
+import (
+	"database/sql"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"minibb/internal/auth"
+	"minibb/internal/db"
+	"minibb/internal/events"
+	"minibb/internal/models"
+	"minibb/internal/utils"
+)
+
+// recordModAction writes an entry to the moderation log for the admin
+// performing the current request. Actions pass the transaction they made
+// their change in, so that the change is never made without its entry.
+func recordModAction(r *http.Request, database models.Querier, action, targetType string, targetID int, boardID *int, before, after interface{}) error {
+	entry := models.ModLogEntry{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		BoardID:    boardID,
+		IP:         utils.ClientIP(r),
+	}
+	if admin := auth.FromContext(r.Context()); admin != nil {
+		entry.Actor = admin.Label
+	}
+
+	return models.RecordModAction(r.Context(), database, entry, before, after)
+}
+
+// loadTopicParam resolves the {topicId} URL parameter and writes an error
+// response if the topic cannot be found.
+func loadTopicParam(w http.ResponseWriter, r *http.Request, database *sql.DB) *models.Topic {
+	topicID, err := utils.ParseInt(chi.URLParam(r, "topicId"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid topic ID"})
+		return nil
+	}
+
+	topic, err := models.GetTopicByID(r.Context(), database, topicID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return nil
+	}
+	if topic == nil {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "topic not found"})
+		return nil
+	}
+	return topic
+}
+
+type TopicStatusRequest struct {
+	Status string `json:"status"`
+}
+
+func SetTopicStatus(w http.ResponseWriter, r *http.Request) {
+	database := db.FromContext(r.Context())
+
+	topic := loadTopicParam(w, r, database)
+	if topic == nil {
+		return
+	}
+
+	var req TopicStatusRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid request body"})
+		return
+	}
+	if req.Status != "open" && req.Status != "locked" {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "status must be open or locked"})
+		return
+	}
+
+	action := "topic.lock"
+	if req.Status == "open" {
+		action = "topic.unlock"
+	}
+
+	var updated *models.Topic
+	err := models.WithTx(database, func(tx *sql.Tx) error {
+		if err := models.SetTopicStatus(r.Context(), tx, topic.ID, req.Status); err != nil {
+			return err
+		}
+		var err error
+		if updated, err = models.GetTopicByID(r.Context(), tx, topic.ID); err != nil {
+			return err
+		}
+		return recordModAction(r, tx, action, "topic", topic.ID, &topic.BoardID, topic, updated)
+	})
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	events.FromContext(r.Context()).Publish(events.Event{Kind: action, BoardID: topic.BoardID, TopicID: topic.ID})
+
+	utils.RespondWithJSON(w, http.StatusOK, updated)
+}
+
+type MoveTopicRequest struct {
+	Board string `json:"board"`
+}
+
+func MoveTopic(w http.ResponseWriter, r *http.Request) {
+	database := db.FromContext(r.Context())
+
+	topic := loadTopicParam(w, r, database)
+	if topic == nil {
+		return
+	}
+
+	var req MoveTopicRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid request body"})
+		return
+	}
+
+	board, err := models.GetBoardBySlug(r.Context(), database, req.Board)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	if board == nil {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "board not found"})
+		return
+	}
+
+	var updated *models.Topic
+	err = models.WithTx(database, func(tx *sql.Tx) error {
+		if err := models.MoveTopic(r.Context(), tx, topic.ID, board.ID); err != nil {
+			return err
+		}
+		var err error
+		if updated, err = models.GetTopicByID(r.Context(), tx, topic.ID); err != nil {
+			return err
+		}
+		return recordModAction(r, tx, "topic.move", "topic", topic.ID, &board.ID, topic, updated)
+	})
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	events.FromContext(r.Context()).Publish(
+		events.Event{Kind: "topic.move", BoardID: topic.BoardID, TopicID: topic.ID},
+		events.Event{Kind: "topic.move", BoardID: board.ID, TopicID: topic.ID},
+	)
+
+	utils.RespondWithJSON(w, http.StatusOK, updated)
+}
+
+func DeleteTopic(w http.ResponseWriter, r *http.Request) {
+	database := db.FromContext(r.Context())
+
+	topic := loadTopicParam(w, r, database)
+	if topic == nil {
+		return
+	}
+
+	err := models.WithTx(database, func(tx *sql.Tx) error {
+		if err := models.DeleteTopic(r.Context(), tx, topic.ID); err != nil {
+			return err
+		}
+		return recordModAction(r, tx, "topic.delete", "topic", topic.ID, &topic.BoardID, topic, nil)
+	})
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	events.FromContext(r.Context()).Publish(events.Event{Kind: "topic.delete", BoardID: topic.BoardID, TopicID: topic.ID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func DeletePost(w http.ResponseWriter, r *http.Request) {
+	database := db.FromContext(r.Context())
+
+	postID, err := utils.ParseInt(chi.URLParam(r, "postId"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid post ID"})
+		return
+	}
+
+	post, err := models.GetPostByID(r.Context(), database, postID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	if post == nil {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "post not found"})
+		return
+	}
+
+	topic, err := models.GetTopicByID(r.Context(), database, post.TopicID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	if topic == nil {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "topic not found"})
+		return
+	}
+
+	err = models.WithTx(database, func(tx *sql.Tx) error {
+		if err := models.DeletePost(r.Context(), tx, post.ID); err != nil {
+			return err
+		}
+		return recordModAction(r, tx, "post.delete", "post", post.ID, &topic.BoardID, post, nil)
+	})
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	events.FromContext(r.Context()).Publish(events.Event{Kind: "post.delete", BoardID: topic.BoardID, TopicID: topic.ID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type BansResponse struct {
+	Bans []models.Ban `json:"bans"`
+}
+
+func ListBans(w http.ResponseWriter, r *http.Request) {
+	database := db.ReaderFromContext(r.Context())
+
+	bans, err := models.GetActiveBans(r.Context(), database)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, BansResponse{Bans: bans})
+}
+
+type CreateBanRequest struct {
+	IP       string `json:"ip"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+}
+
+func CreateBan(w http.ResponseWriter, r *http.Request) {
+	database := db.FromContext(r.Context())
+
+	var req CreateBanRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid request body"})
+		return
+	}
+	if net.ParseIP(req.IP) == nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid IP address"})
+		return
+	}
+
+	var duration time.Duration
+	if req.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(req.Duration)
+		if err != nil || duration < 0 {
+			utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid ban duration"})
+			return
+		}
+	}
+
+	var ban *models.Ban
+	err := models.WithTx(database, func(tx *sql.Tx) error {
+		var err error
+		ban, err = models.CreateBan(r.Context(), tx, req.IP, req.Reason, auth.FromContext(r.Context()).Label, duration)
+		if err != nil {
+			return err
+		}
+		return recordModAction(r, tx, "ban.create", "ban", ban.ID, nil, nil, ban)
+	})
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusCreated, ban)
+}
+
+func LiftBan(w http.ResponseWriter, r *http.Request) {
+	database := db.FromContext(r.Context())
+
+	banID, err := utils.ParseInt(chi.URLParam(r, "banId"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid ban ID"})
+		return
+	}
+
+	ban, err := models.GetBanByID(r.Context(), database, banID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	if ban == nil {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "ban not found"})
+		return
+	}
+
+	var updated *models.Ban
+	err = models.WithTx(database, func(tx *sql.Tx) error {
+		if err := models.LiftBan(r.Context(), tx, ban.ID); err != nil {
+			return err
+		}
+		var err error
+		if updated, err = models.GetBanByID(r.Context(), tx, ban.ID); err != nil {
+			return err
+		}
+		return recordModAction(r, tx, "ban.lift", "ban", ban.ID, nil, ban, updated)
+	})
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, updated)
+}
+
+type UpdateBoardRequest struct {
+	Description *string `json:"description"`
+}
+
+func UpdateBoard(w http.ResponseWriter, r *http.Request) {
+	database := db.FromContext(r.Context())
+
+	board := loadBoardParam(w, r, database)
+	if board == nil {
+		return
+	}
+
+	var req UpdateBoardRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid request body"})
+		return
+	}
+
+	changed := *board
+	if req.Description != nil {
+		changed.Description = *req.Description
+	}
+
+	var updated *models.Board
+	err := models.WithTx(database, func(tx *sql.Tx) error {
+		if err := models.UpdateBoard(r.Context(), tx, changed); err != nil {
+			return err
+		}
+		var err error
+		if updated, err = models.GetBoardByID(r.Context(), tx, board.ID); err != nil {
+			return err
+		}
+		return recordModAction(r, tx, "board.update", "board", board.ID, &board.ID, board, updated)
+	})
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	events.FromContext(r.Context()).Publish(events.Event{Kind: "board.update", BoardID: board.ID})
+
+	utils.RespondWithJSON(w, http.StatusOK, updated)
+}
+
+func GetBoardSettings(w http.ResponseWriter, r *http.Request) {
+	database := db.ReaderFromContext(r.Context())
+
+	board := loadBoardParam(w, r, database)
+	if board == nil {
+		return
+	}
+
+	settings, err := models.GetBoardSettings(r.Context(), database, board.ID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, settings)
+}
+
+type UpdateBoardSettingsRequest struct {
+	ReadOnly         *bool     `json:"read_only"`
+	DefaultName      *string   `json:"default_name"`
+	MaxTitleLength   *int      `json:"max_title_length"`
+	MaxBodyLength    *int      `json:"max_body_length"`
+	MaxActiveThreads *int      `json:"max_active_threads"`
+	BumpLimit        *int      `json:"bump_limit"`
+	MarkupExtensions *[]string `json:"markup_extensions"`
+	PowDifficulty    *int      `json:"pow_difficulty"`
+	Premoderate      *bool     `json:"premoderate"`
+}
+
+// Apply copies the fields present in the request onto settings.
+func (req UpdateBoardSettingsRequest) Apply(settings *models.BoardSettings) {
+	if req.ReadOnly != nil {
+		settings.ReadOnly = *req.ReadOnly
+	}
+	if req.DefaultName != nil {
+		settings.DefaultName = *req.DefaultName
+	}
+	if req.MaxTitleLength != nil {
+		settings.MaxTitleLength = *req.MaxTitleLength
+	}
+	if req.MaxBodyLength != nil {
+		settings.MaxBodyLength = *req.MaxBodyLength
+	}
+	if req.MaxActiveThreads != nil {
+		settings.MaxActiveThreads = *req.MaxActiveThreads
+	}
+	if req.BumpLimit != nil {
+		settings.BumpLimit = *req.BumpLimit
+	}
+	if req.MarkupExtensions != nil {
+		settings.MarkupExtensions = *req.MarkupExtensions
+	}
+	if req.PowDifficulty != nil {
+		settings.PowDifficulty = *req.PowDifficulty
+	}
+	if req.Premoderate != nil {
+		settings.Premoderate = *req.Premoderate
+	}
+}
+
+func UpdateBoardSettings(w http.ResponseWriter, r *http.Request) {
+	database := db.FromContext(r.Context())
+
+	board := loadBoardParam(w, r, database)
+	if board == nil {
+		return
+	}
+
+	settings, err := models.GetBoardSettings(r.Context(), database, board.ID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	var req UpdateBoardSettingsRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid request body"})
+		return
+	}
+
+	changed := *settings
+	req.Apply(&changed)
+	if err := changed.Validate(); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: err.Error()})
+		return
+	}
+
+	err = models.WithTx(database, func(tx *sql.Tx) error {
+		if err := models.SaveBoardSettings(r.Context(), tx, changed); err != nil {
+			return err
+		}
+		return recordModAction(r, tx, "board.settings", "board", board.ID, &board.ID, settings, changed)
+	})
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	events.FromContext(r.Context()).Publish(events.Event{Kind: "board.settings", BoardID: board.ID})
+
+	utils.RespondWithJSON(w, http.StatusOK, changed)
+}
+
+// loadBoardParam resolves the {board} URL parameter and writes an error
+// response if the board cannot be found.
+func loadBoardParam(w http.ResponseWriter, r *http.Request, database *sql.DB) *models.Board {
+	board, err := models.GetBoardBySlug(r.Context(), database, chi.URLParam(r, "board"))
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return nil
+	}
+	if board == nil {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "board not found"})
+		return nil
+	}
+	return board
+}
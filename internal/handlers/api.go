@@ -1,13 +1,16 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 
+	"minibb/internal/auth"
 	"minibb/internal/db"
 	"minibb/internal/models"
+	"minibb/internal/respcache"
 	"minibb/internal/utils"
 )
 
@@ -31,26 +34,39 @@ type BoardsResponse struct {
 
 type BoardWithRecent struct {
 	models.Board
-	RecentTopic *models.Topic `json:"recent_topic"`
-	RecentPost  *models.Post  `json:"recent_post"`
+	Settings    models.PublicBoardSettings `json:"settings"`
+	RecentTopic *models.Topic              `json:"recent_topic"`
+	RecentPost  *models.Post               `json:"recent_post"`
 }
 
 func ListBoards(w http.ResponseWriter, r *http.Request) {
-	database := db.FromContext(r.Context())
+	database := db.ReaderFromContext(r.Context())
 
-	boards, err := getBoardsWithRecent(database)
+	version, err := models.GetBoardsVersion(r.Context(), database)
 	if err != nil {
-		utils.InternalServerError(w, err)
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	cache := newValidators(r, version)
+	if cache.notModified(w, r) {
+		return
+	}
+	respcache.DependOnBoard(r.Context(), 0)
+
+	boards, err := getBoardsWithRecent(r.Context(), database)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
 		return
 	}
 
 	response := BoardsResponse{Boards: boards}
+	cache.setHeaders(w)
 	utils.RespondWithJSON(w, http.StatusOK, response)
 }
 
-func getBoardsWithRecent(database *sql.DB) ([]BoardWithRecent, error) {
+func getBoardsWithRecent(ctx context.Context, database *sql.DB) ([]BoardWithRecent, error) {
 	// Get all boards using the model
-	boards, err := models.GetAllBoards(database)
+	boards, err := models.GetAllBoards(ctx, database)
 	if err != nil {
 		return nil, err
 	}
@@ -60,15 +76,21 @@ func getBoardsWithRecent(database *sql.DB) ([]BoardWithRecent, error) {
 	for _, board := range boards {
 		boardWithRecent := BoardWithRecent{Board: board}
 
+		settings, err := models.GetBoardSettings(ctx, database, board.ID)
+		if err != nil {
+			return nil, err
+		}
+		boardWithRecent.Settings = settings.Public()
+
 		// Get most recent topic for this board
-		recentTopic, err := models.GetMostRecentTopicByBoardID(database, board.ID)
+		recentTopic, err := models.GetMostRecentTopicByBoardID(ctx, database, board.ID)
 		if err != nil {
 			return nil, err
 		}
 		boardWithRecent.RecentTopic = recentTopic
 
 		// Get most recent post for this board
-		recentPost, err := models.GetMostRecentPostByBoardID(database, board.ID)
+		recentPost, err := models.GetMostRecentPostByBoardID(ctx, database, board.ID)
 		if err != nil {
 			return nil, err
 		}
@@ -86,12 +108,26 @@ type TopicsResponse struct {
 }
 
 func ListTopics(w http.ResponseWriter, r *http.Request) {
-	database := db.FromContext(r.Context())
+	database := db.ReaderFromContext(r.Context())
 	boardSlug := chi.URLParam(r, "board")
 
-	board, err := models.GetBoardBySlug(database, boardSlug)
+	version, err := models.GetBoardVersion(r.Context(), database, boardSlug)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	if version == nil {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "board not found"})
+		return
+	}
+	cache := newValidators(r, *version)
+	if cache.notModified(w, r) {
+		return
+	}
+
+	board, err := models.GetBoardBySlug(r.Context(), database, boardSlug)
 	if err != nil {
-		utils.InternalServerError(w, err)
+		utils.InternalServerError(w, r, err)
 		return
 	}
 	if board == nil {
@@ -99,17 +135,20 @@ func ListTopics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	respcache.DependOnBoard(r.Context(), board.ID)
+
 	params := utils.ParsePaginationParams(r)
+	visibleOnly := !auth.IsAdmin(r.Context())
 
-	topics, err := models.GetTopicsByBoardIDWithPagination(database, board.ID, params.PerPage, params.Offset)
+	topics, err := models.GetTopicsByBoardIDWithPagination(r.Context(), database, board.ID, visibleOnly, params.PerPage, params.Offset)
 	if err != nil {
-		utils.InternalServerError(w, err)
+		utils.InternalServerError(w, r, err)
 		return
 	}
 
-	total, err := models.CountTopicsByBoardID(database, board.ID)
+	total, err := models.CountTopicsByBoardID(r.Context(), database, board.ID, visibleOnly)
 	if err != nil {
-		utils.InternalServerError(w, err)
+		utils.InternalServerError(w, r, err)
 		return
 	}
 
@@ -119,6 +158,7 @@ func ListTopics(w http.ResponseWriter, r *http.Request) {
 		Pagination: meta,
 	}
 
+	cache.setHeaders(w)
 	utils.RespondWithJSON(w, http.StatusOK, response)
 }
 
@@ -129,7 +169,7 @@ type PostsResponse struct {
 }
 
 func ListPosts(w http.ResponseWriter, r *http.Request) {
-	database := db.FromContext(r.Context())
+	database := db.ReaderFromContext(r.Context())
 	topicIDStr := chi.URLParam(r, "topicId")
 
 	topicID, err := utils.ParseInt(topicIDStr)
@@ -138,27 +178,43 @@ func ListPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	topic, err := models.GetTopicByID(database, topicID)
+	version, err := models.GetTopicVersion(r.Context(), database, topicID)
 	if err != nil {
-		utils.InternalServerError(w, err)
+		utils.InternalServerError(w, r, err)
 		return
 	}
-	if topic == nil {
+	if version == nil {
 		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "topic not found"})
 		return
 	}
+	cache := newValidators(r, *version)
+	if cache.notModified(w, r) {
+		return
+	}
+
+	topic, err := models.GetTopicByID(r.Context(), database, topicID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	visibleOnly := !auth.IsAdmin(r.Context())
+	if topic == nil || (visibleOnly && topic.Visibility != models.VisibilityVisible) {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "topic not found"})
+		return
+	}
+	respcache.DependOnTopic(r.Context(), topic.ID)
 
 	params := utils.ParsePaginationParams(r)
 
-	posts, err := models.GetPostsByTopicIDWithPagination(database, topicID, params.PerPage, params.Offset)
+	posts, err := models.GetPostsByTopicIDWithPagination(r.Context(), database, topicID, visibleOnly, params.PerPage, params.Offset)
 	if err != nil {
-		utils.InternalServerError(w, err)
+		utils.InternalServerError(w, r, err)
 		return
 	}
 
-	total, err := models.CountPostsByTopicID(database, topicID)
+	total, err := models.CountPostsByTopicID(r.Context(), database, topicID, visibleOnly)
 	if err != nil {
-		utils.InternalServerError(w, err)
+		utils.InternalServerError(w, r, err)
 		return
 	}
 
@@ -169,5 +225,61 @@ func ListPosts(w http.ResponseWriter, r *http.Request) {
 		Pagination: meta,
 	}
 
+	cache.setHeaders(w)
+	utils.RespondWithJSON(w, http.StatusOK, response)
+}
+
+func ListArchivedTopics(w http.ResponseWriter, r *http.Request) {
+	database := db.ReaderFromContext(r.Context())
+	boardSlug := chi.URLParam(r, "board")
+
+	version, err := models.GetBoardVersion(r.Context(), database, boardSlug)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	if version == nil {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "board not found"})
+		return
+	}
+	cache := newValidators(r, *version)
+	if cache.notModified(w, r) {
+		return
+	}
+
+	board, err := models.GetBoardBySlug(r.Context(), database, boardSlug)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	if board == nil {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "board not found"})
+		return
+	}
+
+	respcache.DependOnBoard(r.Context(), board.ID)
+
+	params := utils.ParsePaginationParams(r)
+	visibleOnly := !auth.IsAdmin(r.Context())
+
+	topics, err := models.GetArchivedTopicsByBoardIDWithPagination(r.Context(), database, board.ID, visibleOnly, params.PerPage, params.Offset)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	total, err := models.CountArchivedTopicsByBoardID(r.Context(), database, board.ID, visibleOnly)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	meta := utils.CalculatePaginationMeta(params.Page, params.PerPage, total)
+	response := TopicsResponse{
+		Topics:     topics,
+		Pagination: meta,
+	}
+
+	cache.setHeaders(w)
 	utils.RespondWithJSON(w, http.StatusOK, response)
 }
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"minibb/internal/db"
+	"minibb/internal/utils"
+)
+
+// DownloadBackup streams a consistent snapshot of the database. The
+// snapshot is written to a temporary file first because VACUUM INTO
+// cannot write to a stream.
+func DownloadBackup(w http.ResponseWriter, r *http.Request) {
+	dir, err := os.MkdirTemp("", "minibb-backup-")
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "minibb.db")
+	if err := db.Backup(db.ConfigFromContext(r.Context()), path); err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	if err := recordModAction(r, db.FromContext(r.Context()), "backup.download", "database", 0, nil, nil, nil); err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	filename := fmt.Sprintf("minibb-%s.db", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, f)
+}
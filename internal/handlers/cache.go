@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"minibb/internal/auth"
+	"minibb/internal/models"
+	"minibb/internal/utils"
+)
+
+// publicCacheControl lets browsers keep read responses but revalidate
+// them on every use, while shared caches such as a CDN may serve them for
+// a few seconds and keep serving them while they revalidate.
+const publicCacheControl = "public, max-age=0, s-maxage=5, stale-while-revalidate=30"
+
+// validators are the ETag and Last-Modified of a read response. Admins see
+// hidden content, so their responses get their own tag and stay out of
+// shared caches.
+type validators struct {
+	etag     string
+	modified time.Time
+	admin    bool
+}
+
+func newValidators(r *http.Request, version models.Version) validators {
+	v := validators{etag: version.Tag, modified: version.Modified.UTC(), admin: auth.IsAdmin(r.Context())}
+	if v.admin {
+		v.etag += "-admin"
+	}
+	v.etag = `W/"` + v.etag + `"`
+	return v
+}
+
+// setHeaders adds the validators and caching headers to a response.
+func (v validators) setHeaders(w http.ResponseWriter) {
+	h := w.Header()
+	h.Set("ETag", v.etag)
+	if !v.modified.IsZero() {
+		h.Set("Last-Modified", v.modified.Format(http.TimeFormat))
+	}
+	if v.admin {
+		h.Set("Cache-Control", "private, no-cache")
+	} else {
+		h.Set("Cache-Control", publicCacheControl)
+	}
+	h.Add("Vary", "Authorization")
+}
+
+// notModified responds with 304 Not Modified if the request's If-None-Match
+// or, without one, If-Modified-Since shows the client's copy is current.
+func (v validators) notModified(w http.ResponseWriter, r *http.Request) bool {
+	if !utils.NotModified(r, v.etag, v.modified) {
+		return false
+	}
+
+	v.setHeaders(w)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
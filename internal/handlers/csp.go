@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"minibb/internal/logging"
+	"minibb/internal/metrics"
+	"minibb/internal/utils"
+)
+
+const maxCSPReportSize = 64 << 10
+
+// cspDirectives are the directives counted by name in the metrics; anything
+// else a client sends is counted as other.
+var cspDirectives = map[string]bool{
+	"base-uri": true, "child-src": true, "connect-src": true, "default-src": true,
+	"font-src": true, "form-action": true, "frame-ancestors": true, "frame-src": true,
+	"img-src": true, "manifest-src": true, "media-src": true, "object-src": true,
+	"script-src": true, "script-src-attr": true, "script-src-elem": true,
+	"style-src": true, "style-src-attr": true, "style-src-elem": true,
+	"worker-src": true,
+}
+
+type cspViolation struct {
+	DocumentURL string
+	BlockedURL  string
+	Directive   string
+	SourceFile  string
+	Line        int
+	Disposition string
+}
+
+// legacyCSPReport is the body sent for the report-uri directive.
+type legacyCSPReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		Disposition        string `json:"disposition"`
+	} `json:"csp-report"`
+}
+
+// reportingAPIReport is one report sent for the report-to directive.
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		Disposition        string `json:"disposition"`
+	} `json:"body"`
+}
+
+// CSPReport collects the Content-Security-Policy violations reported by
+// browsers, in the report-uri format or as Reporting API reports. Each one
+// is counted, and the first of a report is logged.
+func CSPReport(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCSPReportSize))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid report"})
+		return
+	}
+	violations, err := parseCSPReport(body)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid report"})
+		return
+	}
+	if len(violations) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	for _, v := range violations {
+		directive := v.Directive
+		if !cspDirectives[directive] {
+			directive = "other"
+		}
+		metrics.CSPViolations.Inc(directive)
+	}
+
+	// One line per report however many violations it carries; the metric
+	// shows the volume
+	first := violations[0]
+	logging.FromContext(r.Context()).Warn("content security policy violation",
+		"violations", len(violations),
+		"document", first.DocumentURL,
+		"directive", first.Directive,
+		"blocked", first.BlockedURL,
+		"source", first.SourceFile,
+		"line", first.Line,
+		"disposition", first.Disposition,
+	)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseCSPReport reads either format: the Reporting API sends an array of
+// reports of which only csp-violation ones are kept.
+func parseCSPReport(body []byte) ([]cspViolation, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		var reports []reportingAPIReport
+		if err := json.Unmarshal(body, &reports); err != nil {
+			return nil, err
+		}
+		var violations []cspViolation
+		for _, report := range reports {
+			if report.Type != "csp-violation" {
+				continue
+			}
+			violations = append(violations, cspViolation{
+				DocumentURL: report.Body.DocumentURL,
+				BlockedURL:  report.Body.BlockedURL,
+				Directive:   report.Body.EffectiveDirective,
+				SourceFile:  report.Body.SourceFile,
+				Line:        report.Body.LineNumber,
+				Disposition: report.Body.Disposition,
+			})
+		}
+		return violations, nil
+	}
+
+	var report legacyCSPReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, err
+	}
+	directive := report.Report.EffectiveDirective
+	if directive == "" {
+		// Older browsers only send the violated directive with its value
+		directive, _, _ = strings.Cut(report.Report.ViolatedDirective, " ")
+	}
+	return []cspViolation{{
+		DocumentURL: report.Report.DocumentURI,
+		BlockedURL:  report.Report.BlockedURI,
+		Directive:   directive,
+		SourceFile:  report.Report.SourceFile,
+		Line:        report.Report.LineNumber,
+		Disposition: report.Report.Disposition,
+	}}, nil
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"minibb/internal/db"
+	"minibb/internal/filters"
+	"minibb/internal/models"
+	"minibb/internal/utils"
+)
+
+type FiltersResponse struct {
+	Filters []FilterRuleResponse `json:"filters"`
+}
+
+// FilterRuleResponse is a stored rule with the reason it fails to compile,
+// if it does. Such rules are skipped when posting.
+type FilterRuleResponse struct {
+	models.FilterRule
+	Error string `json:"error,omitempty"`
+}
+
+type FilterRuleRequest struct {
+	Board         string `json:"board"`
+	Kind          string `json:"kind"`
+	Pattern       string `json:"pattern"`
+	Action        string `json:"action"`
+	Replacement   string `json:"replacement"`
+	MaxLinks      int    `json:"max_links"`
+	WindowMinutes int    `json:"window_minutes"`
+	Enabled       *bool  `json:"enabled"`
+}
+
+func ListFilters(w http.ResponseWriter, r *http.Request) {
+	database := db.ReaderFromContext(r.Context())
+
+	rules, err := models.GetAllFilterRules(r.Context(), database)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	response := FiltersResponse{Filters: make([]FilterRuleResponse, 0, len(rules))}
+	for _, rule := range rules {
+		item := FilterRuleResponse{FilterRule: rule}
+		if _, err := filters.Compile(rule); err != nil {
+			item.Error = err.Error()
+		}
+		response.Filters = append(response.Filters, item)
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, response)
+}
+
+// parseFilterRuleRequest decodes and validates a filter rule. It writes an
+// error response and returns nil if the rule is invalid.
+func parseFilterRuleRequest(w http.ResponseWriter, r *http.Request) *models.FilterRule {
+	database := db.FromContext(r.Context())
+
+	var req FilterRuleRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid request body"})
+		return nil
+	}
+
+	rule := models.FilterRule{
+		Kind:          req.Kind,
+		Pattern:       req.Pattern,
+		Action:        req.Action,
+		Replacement:   req.Replacement,
+		MaxLinks:      req.MaxLinks,
+		WindowMinutes: req.WindowMinutes,
+		Enabled:       req.Enabled == nil || *req.Enabled,
+	}
+
+	if req.Board != "" {
+		board, err := models.GetBoardBySlug(r.Context(), database, req.Board)
+		if err != nil {
+			utils.InternalServerError(w, r, err)
+			return nil
+		}
+		if board == nil {
+			utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "board not found"})
+			return nil
+		}
+		rule.BoardID = &board.ID
+	}
+
+	if _, err := filters.Compile(rule); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: err.Error()})
+		return nil
+	}
+
+	return &rule
+}
+
+func CreateFilter(w http.ResponseWriter, r *http.Request) {
+	database := db.FromContext(r.Context())
+
+	rule := parseFilterRuleRequest(w, r)
+	if rule == nil {
+		return
+	}
+
+	var created *models.FilterRule
+	err := models.WithTx(database, func(tx *sql.Tx) error {
+		var err error
+		if created, err = models.CreateFilterRule(r.Context(), tx, *rule); err != nil {
+			return err
+		}
+		return recordModAction(r, tx, "filter.create", "filter", created.ID, created.BoardID, nil, created)
+	})
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	filters.FromContext(r.Context()).Invalidate()
+
+	utils.RespondWithJSON(w, http.StatusCreated, created)
+}
+
+// loadFilterParam resolves the {filterId} URL parameter and writes an error
+// response if the filter cannot be found.
+func loadFilterParam(w http.ResponseWriter, r *http.Request) *models.FilterRule {
+	database := db.FromContext(r.Context())
+
+	filterID, err := utils.ParseInt(chi.URLParam(r, "filterId"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid filter ID"})
+		return nil
+	}
+
+	rule, err := models.GetFilterRuleByID(r.Context(), database, filterID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return nil
+	}
+	if rule == nil {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "filter not found"})
+		return nil
+	}
+	return rule
+}
+
+func UpdateFilter(w http.ResponseWriter, r *http.Request) {
+	database := db.FromContext(r.Context())
+
+	existing := loadFilterParam(w, r)
+	if existing == nil {
+		return
+	}
+
+	rule := parseFilterRuleRequest(w, r)
+	if rule == nil {
+		return
+	}
+	rule.ID = existing.ID
+
+	var updated *models.FilterRule
+	err := models.WithTx(database, func(tx *sql.Tx) error {
+		if err := models.UpdateFilterRule(r.Context(), tx, *rule); err != nil {
+			return err
+		}
+		var err error
+		if updated, err = models.GetFilterRuleByID(r.Context(), tx, existing.ID); err != nil {
+			return err
+		}
+		return recordModAction(r, tx, "filter.update", "filter", existing.ID, updated.BoardID, existing, updated)
+	})
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	filters.FromContext(r.Context()).Invalidate()
+
+	utils.RespondWithJSON(w, http.StatusOK, updated)
+}
+
+func DeleteFilter(w http.ResponseWriter, r *http.Request) {
+	database := db.FromContext(r.Context())
+
+	existing := loadFilterParam(w, r)
+	if existing == nil {
+		return
+	}
+
+	err := models.WithTx(database, func(tx *sql.Tx) error {
+		if err := models.DeleteFilterRule(r.Context(), tx, existing.ID); err != nil {
+			return err
+		}
+		return recordModAction(r, tx, "filter.delete", "filter", existing.ID, existing.BoardID, existing, nil)
+	})
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	filters.FromContext(r.Context()).Invalidate()
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+
+	"minibb/internal/db"
+	"minibb/internal/events"
+	"minibb/internal/fsck"
+	"minibb/internal/models"
+	"minibb/internal/utils"
+)
+
+// CheckConsistency reports discrepancies in the denormalized data without
+// changing anything.
+func CheckConsistency(w http.ResponseWriter, r *http.Request) {
+	database := db.ReaderFromContext(r.Context())
+
+	report, err := fsck.Run(database, false)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, report)
+}
+
+// RepairConsistency fixes all discrepancies in a single transaction, which
+// also records the repair in the mod log.
+func RepairConsistency(w http.ResponseWriter, r *http.Request) {
+	database := db.FromContext(r.Context())
+
+	var report *fsck.Report
+	err := models.WithTx(database, func(tx *sql.Tx) error {
+		var err error
+		if report, err = fsck.RunTx(tx, true); err != nil || report.OK() {
+			return err
+		}
+		return recordModAction(r, tx, "fsck.repair", "database", 0, nil, nil, report.Summary())
+	})
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	report.Fixed = true
+	if !report.OK() {
+		events.FromContext(r.Context()).Publish(events.Event{Kind: "fsck.repair"})
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+
+	"minibb/internal/health"
+	"minibb/internal/utils"
+)
+
+// Live reports that the process is up and serving requests. It checks no
+// dependencies, so a failing database doesn't get the process restarted.
+func Live(w http.ResponseWriter, r *http.Request) {
+	utils.RespondWithJSON(w, http.StatusOK, HealthResponse{Status: "ok", Message: "MiniBB server is running"})
+}
+
+// Ready reports whether the server can take traffic, with the status and
+// latency of each dependency check.
+func Ready(w http.ResponseWriter, r *http.Request) {
+	report := health.FromContext(r.Context()).Ready(r.Context())
+
+	status := http.StatusOK
+	if report.Status != health.StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	utils.RespondWithJSON(w, status, report)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"minibb/internal/db"
+	"minibb/internal/models"
+	"minibb/internal/utils"
+)
+
+type ModLogResponse struct {
+	Entries    []models.ModLogEntry `json:"entries"`
+	Pagination utils.PaginationMeta `json:"pagination"`
+}
+
+func ListModLog(w http.ResponseWriter, r *http.Request) {
+	database := db.ReaderFromContext(r.Context())
+	query := r.URL.Query()
+
+	filter := models.ModLogFilter{
+		Actor:      query.Get("actor"),
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+	}
+	if targetID := query.Get("target_id"); targetID != "" {
+		id, err := utils.ParseInt(targetID)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid target ID"})
+			return
+		}
+		filter.TargetID = id
+	}
+	if boardSlug := query.Get("board"); boardSlug != "" {
+		board, err := models.GetBoardBySlug(r.Context(), database, boardSlug)
+		if err != nil {
+			utils.InternalServerError(w, r, err)
+			return
+		}
+		if board == nil {
+			utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "board not found"})
+			return
+		}
+		filter.BoardID = board.ID
+	}
+
+	params := utils.ParsePaginationParams(r)
+
+	entries, err := models.GetModLogEntriesWithPagination(r.Context(), database, filter, params.PerPage, params.Offset)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	total, err := models.CountModLogEntries(r.Context(), database, filter)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	meta := utils.CalculatePaginationMeta(params.Page, params.PerPage, total)
+	utils.RespondWithJSON(w, http.StatusOK, ModLogResponse{
+		Entries:    entries,
+		Pagination: meta,
+	})
+}
+
+// PublicModLogEntry is the redacted form of a mod log entry. It omits the
+// acting admin, the request IP and the before/after snapshots.
+type PublicModLogEntry struct {
+	ID         int       `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type"`
+	TargetID   int       `json:"target_id"`
+}
+
+type PublicModLogResponse struct {
+	Entries    []PublicModLogEntry  `json:"entries"`
+	Pagination utils.PaginationMeta `json:"pagination"`
+}
+
+func ListBoardModLog(w http.ResponseWriter, r *http.Request) {
+	database := db.ReaderFromContext(r.Context())
+	boardSlug := chi.URLParam(r, "board")
+
+	board, err := models.GetBoardBySlug(r.Context(), database, boardSlug)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	if board == nil {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "board not found"})
+		return
+	}
+
+	filter := models.ModLogFilter{BoardID: board.ID}
+	params := utils.ParsePaginationParams(r)
+
+	entries, err := models.GetModLogEntriesWithPagination(r.Context(), database, filter, params.PerPage, params.Offset)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	total, err := models.CountModLogEntries(r.Context(), database, filter)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	publicEntries := make([]PublicModLogEntry, 0, len(entries))
+	for _, entry := range entries {
+		publicEntries = append(publicEntries, PublicModLogEntry{
+			ID:         entry.ID,
+			CreatedAt:  entry.CreatedAt,
+			Action:     entry.Action,
+			TargetType: entry.TargetType,
+			TargetID:   entry.TargetID,
+		})
+	}
+
+	meta := utils.CalculatePaginationMeta(params.Page, params.PerPage, total)
+	utils.RespondWithJSON(w, http.StatusOK, PublicModLogResponse{
+		Entries:    publicEntries,
+		Pagination: meta,
+	})
+}
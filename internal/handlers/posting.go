@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	"minibb/internal/auth"
+	"minibb/internal/db"
+	"minibb/internal/events"
+	"minibb/internal/filters"
+	"minibb/internal/metrics"
+	"minibb/internal/models"
+	"minibb/internal/pow"
+	"minibb/internal/utils"
+)
+
+const maxAuthorLength = 100
+
+type CreateTopicRequest struct {
+	Title   string `json:"title"`
+	Author  string `json:"author"`
+	Content string `json:"content"`
+}
+
+type CreatePostRequest struct {
+	Author  string `json:"author"`
+	Content string `json:"content"`
+}
+
+func CreateTopic(w http.ResponseWriter, r *http.Request) {
+	database := db.FromContext(r.Context())
+	boardSlug := chi.URLParam(r, "board")
+
+	board, err := models.GetBoardBySlug(r.Context(), database, boardSlug)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	if board == nil {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "board not found"})
+		return
+	}
+
+	var req CreateTopicRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid request body"})
+		return
+	}
+
+	settings, err := models.GetBoardSettings(r.Context(), database, board.ID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" || utf8.RuneCountInString(title) > settings.MaxTitleLength {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{
+			Detail: fmt.Sprintf("title must be between 1 and %d characters", settings.MaxTitleLength),
+		})
+		return
+	}
+	author, content, ok := validatePostFields(w, settings, req.Author, req.Content)
+	if !ok {
+		return
+	}
+
+	if !checkPostingAllowed(w, r, database, board, settings) {
+		return
+	}
+
+	sub := &filters.Submission{
+		BoardID: board.ID,
+		Title:   title,
+		Author:  author,
+		Content: content,
+		IP:      utils.ClientIP(r),
+	}
+	visibility, ok := applyFilters(w, r, database, settings, sub)
+	if !ok {
+		return
+	}
+
+	topic, err := models.CreateTopic(r.Context(), database, board.ID, sub.Title, sub.Author, sub.Content, sub.IP, visibility)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	events.FromContext(r.Context()).Publish(events.Event{Kind: "topic.create", BoardID: board.ID, TopicID: topic.ID})
+	metrics.TopicsCreated.Inc()
+	metrics.PostsCreated.Inc()
+
+	utils.RespondWithJSON(w, createdStatus(visibility), topic)
+}
+
+func CreatePost(w http.ResponseWriter, r *http.Request) {
+	database := db.FromContext(r.Context())
+
+	topic := loadTopicParam(w, r, database)
+	if topic == nil {
+		return
+	}
+	if topic.Status != "open" || topic.Visibility != models.VisibilityVisible {
+		utils.RespondWithError(w, http.StatusForbidden, utils.APIError{Detail: "topic is not open for replies"})
+		return
+	}
+
+	board, err := models.GetBoardByID(r.Context(), database, topic.BoardID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	var req CreatePostRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid request body"})
+		return
+	}
+
+	settings, err := models.GetBoardSettings(r.Context(), database, board.ID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	author, content, ok := validatePostFields(w, settings, req.Author, req.Content)
+	if !ok {
+		return
+	}
+
+	if !checkPostingAllowed(w, r, database, board, settings) {
+		return
+	}
+
+	sub := &filters.Submission{
+		BoardID: board.ID,
+		TopicID: topic.ID,
+		Author:  author,
+		Content: content,
+		IP:      utils.ClientIP(r),
+	}
+	visibility, ok := applyFilters(w, r, database, settings, sub)
+	if !ok {
+		return
+	}
+
+	bump := settings.BumpLimit == 0 || topic.PostCount < settings.BumpLimit
+	post, err := models.CreatePost(r.Context(), database, topic.ID, sub.Author, sub.Content, sub.IP, visibility, bump)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	events.FromContext(r.Context()).Publish(events.Event{Kind: "post.create", BoardID: board.ID, TopicID: topic.ID})
+	metrics.PostsCreated.Inc()
+
+	utils.RespondWithJSON(w, createdStatus(visibility), post)
+}
+
+func validatePostFields(w http.ResponseWriter, settings *models.BoardSettings, author, content string) (string, string, bool) {
+	author = strings.TrimSpace(author)
+	if author == "" {
+		author = settings.DefaultName
+	}
+	if utf8.RuneCountInString(author) > maxAuthorLength {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "author name is too long"})
+		return "", "", false
+	}
+
+	if strings.TrimSpace(content) == "" || utf8.RuneCountInString(content) > settings.MaxBodyLength {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{
+			Detail: fmt.Sprintf("content must be between 1 and %d characters", settings.MaxBodyLength),
+		})
+		return "", "", false
+	}
+
+	return author, content, true
+}
+
+// checkPostingAllowed rejects writes to read-only boards, writes from banned
+// addresses and, on boards that require it, writes without a valid
+// proof-of-work solution. Admins bypass all checks.
+func checkPostingAllowed(w http.ResponseWriter, r *http.Request, database *sql.DB, board *models.Board, settings *models.BoardSettings) bool {
+	if auth.IsAdmin(r.Context()) {
+		return true
+	}
+
+	if settings.ReadOnly {
+		metrics.PostingRejections.Inc(metrics.RejectReadOnly)
+		utils.RespondWithError(w, http.StatusForbidden, utils.APIError{Detail: "board is read-only"})
+		return false
+	}
+
+	// Without a client address, as behind an untrusted Unix socket, there
+	// is nothing a ban could match
+	var ban *models.Ban
+	if ip := utils.ClientIP(r); ip != "" {
+		var err error
+		if ban, err = models.GetActiveBanByIP(r.Context(), database, ip); err != nil {
+			utils.InternalServerError(w, r, err)
+			return false
+		}
+	}
+	if ban != nil {
+		metrics.PostingRejections.Inc(metrics.RejectBan)
+		detail := "you are banned from posting"
+		if ban.Reason != "" {
+			detail += ": " + ban.Reason
+		}
+		utils.RespondWithError(w, http.StatusForbidden, utils.APIError{Detail: detail})
+		return false
+	}
+
+	if settings.PowDifficulty > 0 {
+		verifier := pow.FromContext(r.Context())
+		err := verifier.Verify(
+			r.Header.Get(pow.ChallengeHeader),
+			r.Header.Get(pow.NonceHeader),
+			board.Slug,
+			settings.PowDifficulty,
+		)
+		if err != nil {
+			metrics.PostingRejections.Inc(metrics.RejectPow)
+			utils.RespondWithError(w, http.StatusForbidden, utils.APIError{Detail: "proof of work rejected: " + err.Error()})
+			return false
+		}
+	}
+
+	return true
+}
+
+// applyFilters runs the board's content filters over the submission,
+// possibly rewriting it, and decides the visibility of the new post. Posts
+// held by a filter or written to a premoderated board start out pending.
+// Admin posts are neither filtered nor held.
+func applyFilters(w http.ResponseWriter, r *http.Request, database *sql.DB, settings *models.BoardSettings, sub *filters.Submission) (string, bool) {
+	if auth.IsAdmin(r.Context()) {
+		return models.VisibilityVisible, true
+	}
+
+	pipeline, err := filters.FromContext(r.Context()).Pipeline(r.Context(), database, sub.BoardID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return "", false
+	}
+
+	result, err := pipeline.Run(r.Context(), database, sub)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return "", false
+	}
+	if result.Rejected {
+		metrics.PostingRejections.Inc(metrics.RejectFilter)
+		utils.RespondWithError(w, http.StatusForbidden, utils.APIError{Detail: "post rejected: " + result.Reason})
+		return "", false
+	}
+
+	if result.Held || settings.Premoderate {
+		return models.VisibilityPending, true
+	}
+	return models.VisibilityVisible, true
+}
+
+// createdStatus is 201 for published posts and 202 for posts waiting in
+// the moderation queue.
+func createdStatus(visibility string) int {
+	if visibility == models.VisibilityPending {
+		return http.StatusAccepted
+	}
+	return http.StatusCreated
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+
+	"minibb/internal/db"
+	"minibb/internal/models"
+	"minibb/internal/pow"
+	"minibb/internal/utils"
+)
+
+func IssuePowChallenge(w http.ResponseWriter, r *http.Request) {
+	database := db.ReaderFromContext(r.Context())
+
+	board, err := models.GetBoardBySlug(r.Context(), database, r.URL.Query().Get("board"))
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	if board == nil {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "board not found"})
+		return
+	}
+
+	settings, err := models.GetBoardSettings(r.Context(), database, board.ID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	challenge, err := pow.FromContext(r.Context()).Issue(board.Slug, settings.PowDifficulty)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, challenge)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"minibb/internal/db"
+	"minibb/internal/events"
+	"minibb/internal/models"
+	"minibb/internal/utils"
+)
+
+type QueueEntry struct {
+	models.Post
+	Topic *models.Topic `json:"topic"`
+}
+
+type QueueResponse struct {
+	Posts      []QueueEntry         `json:"posts"`
+	Pagination utils.PaginationMeta `json:"pagination"`
+}
+
+func ListQueue(w http.ResponseWriter, r *http.Request) {
+	database := db.ReaderFromContext(r.Context())
+	params := utils.ParsePaginationParams(r)
+
+	posts, err := models.GetPendingPostsWithPagination(r.Context(), database, params.PerPage, params.Offset)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	total, err := models.CountPendingPosts(r.Context(), database)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	entries := make([]QueueEntry, 0, len(posts))
+	for _, post := range posts {
+		topic, err := models.GetTopicByID(r.Context(), database, post.TopicID)
+		if err != nil {
+			utils.InternalServerError(w, r, err)
+			return
+		}
+		entries = append(entries, QueueEntry{Post: post, Topic: topic})
+	}
+
+	meta := utils.CalculatePaginationMeta(params.Page, params.PerPage, total)
+	utils.RespondWithJSON(w, http.StatusOK, QueueResponse{
+		Posts:      entries,
+		Pagination: meta,
+	})
+}
+
+func ApprovePost(w http.ResponseWriter, r *http.Request) {
+	moderatePost(w, r, "post.approve", models.VisibilityVisible)
+}
+
+func RejectPost(w http.ResponseWriter, r *http.Request) {
+	moderatePost(w, r, "post.reject", models.VisibilityHidden)
+}
+
+func moderatePost(w http.ResponseWriter, r *http.Request, action, visibility string) {
+	database := db.FromContext(r.Context())
+
+	postID, err := utils.ParseInt(chi.URLParam(r, "postId"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, utils.APIError{Detail: "invalid post ID"})
+		return
+	}
+
+	post, err := models.GetPostByID(r.Context(), database, postID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	if post == nil {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "post not found"})
+		return
+	}
+
+	topic, err := models.GetTopicByID(r.Context(), database, post.TopicID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	if topic == nil {
+		utils.RespondWithError(w, http.StatusNotFound, utils.APIError{Detail: "topic not found"})
+		return
+	}
+
+	settings, err := models.GetBoardSettings(r.Context(), database, topic.BoardID)
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+
+	// An approved reply bumps its topic as it would have when posted,
+	// counting the posts that are visible now
+	bump := post.Visibility == models.VisibilityPending &&
+		(settings.BumpLimit == 0 || topic.PostCount < settings.BumpLimit)
+	var updated *models.Post
+	err = models.WithTx(database, func(tx *sql.Tx) error {
+		if err := models.SetPostVisibility(r.Context(), tx, post.ID, visibility, bump); err != nil {
+			return err
+		}
+		var err error
+		if updated, err = models.GetPostByID(r.Context(), tx, post.ID); err != nil {
+			return err
+		}
+		return recordModAction(r, tx, action, "post", post.ID, &topic.BoardID, post, updated)
+	})
+	if err != nil {
+		utils.InternalServerError(w, r, err)
+		return
+	}
+	events.FromContext(r.Context()).Publish(events.Event{Kind: action, BoardID: topic.BoardID, TopicID: topic.ID})
+
+	utils.RespondWithJSON(w, http.StatusOK, updated)
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"minibb/internal/db"
+	"minibb/internal/events"
+	"minibb/internal/models"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	config := db.DefaultConfig()
+	config.Path = filepath.Join(t.TempDir(), "test.db")
+	database, err := db.Init(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+// queueRouter serves the queue routes against database and collects the
+// events they publish.
+func queueRouter(database *sql.DB, published *[]events.Event) http.Handler {
+	hub := events.NewHub()
+	hub.Subscribe(func(e events.Event) { *published = append(*published, e) })
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := db.WithDB(r.Context(), database)
+			ctx = events.WithHub(ctx, hub)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+	r.Get("/queue", ListQueue)
+	r.Post("/posts/{postId}/approve", ApprovePost)
+	r.Post("/posts/{postId}/reject", RejectPost)
+	return r
+}
+
+func request(t *testing.T, handler http.Handler, method, path string, out interface{}) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	if out != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return w.Code
+}
+
+func TestListQueue(t *testing.T) {
+	database := openTestDB(t)
+	ctx := context.Background()
+	var published []events.Event
+	router := queueRouter(database, &published)
+
+	var empty map[string]json.RawMessage
+	if code := request(t, router, http.MethodGet, "/queue", &empty); code != http.StatusOK {
+		t.Fatalf("empty queue: status %d", code)
+	}
+	if string(empty["posts"]) != "[]" {
+		t.Errorf("empty queue posts = %s, want []", empty["posts"])
+	}
+
+	topic, err := models.CreateTopic(ctx, database, 1, "Held", "alice", "first", "10.0.0.1", models.VisibilityPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	visible, err := models.CreateTopic(ctx, database, 2, "Open", "bob", "first", "10.0.0.2", models.VisibilityVisible)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := models.CreatePost(ctx, database, visible.ID, "carol", "held", "10.0.0.3", models.VisibilityPending, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var queue QueueResponse
+	if code := request(t, router, http.MethodGet, "/queue", &queue); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if len(queue.Posts) != 2 || queue.Pagination.Total != 2 {
+		t.Fatalf("queue = %+v, want 2 posts", queue)
+	}
+	if queue.Posts[0].Topic.ID != topic.ID || queue.Posts[1].ID != reply.ID || queue.Posts[1].Topic.ID != visible.ID {
+		t.Errorf("queue not oldest first with topics: %+v", queue.Posts)
+	}
+}
+
+func TestModeratePost(t *testing.T) {
+	database := openTestDB(t)
+	ctx := context.Background()
+	var published []events.Event
+	router := queueRouter(database, &published)
+
+	topic, err := models.CreateTopic(ctx, database, 1, "Hello", "alice", "first", "10.0.0.1", models.VisibilityVisible)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approved, err := models.CreatePost(ctx, database, topic.ID, "bob", "good", "10.0.0.2", models.VisibilityPending, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected, err := models.CreatePost(ctx, database, topic.ID, "carol", "bad", "10.0.0.3", models.VisibilityPending, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Exec(`UPDATE topics SET bumped_at = '2000-01-01 00:00:00' WHERE id = ?`, topic.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var post models.Post
+	if code := request(t, router, http.MethodPost, "/posts/"+strconv.Itoa(approved.ID)+"/approve", &post); code != http.StatusOK {
+		t.Fatalf("approve: status %d", code)
+	}
+	if post.Visibility != models.VisibilityVisible {
+		t.Errorf("approved post visibility = %q", post.Visibility)
+	}
+	if code := request(t, router, http.MethodPost, "/posts/"+strconv.Itoa(rejected.ID)+"/reject", &post); code != http.StatusOK {
+		t.Fatalf("reject: status %d", code)
+	}
+	if post.Visibility != models.VisibilityHidden {
+		t.Errorf("rejected post visibility = %q", post.Visibility)
+	}
+
+	updated, err := models.GetTopicByID(ctx, database, topic.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.PostCount != 2 || *updated.LastPostID != approved.ID {
+		t.Errorf("topic counters = %d, %d; want 2, %d", updated.PostCount, *updated.LastPostID, approved.ID)
+	}
+	if updated.BumpedAt.Year() == 2000 {
+		t.Error("approving a pending reply did not bump its topic")
+	}
+
+	entries, err := models.GetModLogEntriesWithPagination(ctx, database, models.ModLogFilter{}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := map[string]int{}
+	for _, e := range entries {
+		actions[e.Action] = e.TargetID
+	}
+	if actions["post.approve"] != approved.ID || actions["post.reject"] != rejected.ID {
+		t.Errorf("mod log = %+v, want an approve and a reject entry", entries)
+	}
+	if len(published) != 2 || published[0].Kind != "post.approve" || published[0].TopicID != topic.ID {
+		t.Errorf("published events = %+v", published)
+	}
+
+	if code := request(t, router, http.MethodPost, "/posts/9999/approve", nil); code != http.StatusNotFound {
+		t.Errorf("unknown post: status %d, want 404", code)
+	}
+	if code := request(t, router, http.MethodPost, "/posts/abc/approve", nil); code != http.StatusBadRequest {
+		t.Errorf("invalid post ID: status %d, want 400", code)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd)
+
+package health
+
+import "errors"
+
+var errUnsupported = errors.New("unsupported platform")
+
+func freeSpace(dir string) (uint64, error) {
+	return 0, errUnsupported
+}
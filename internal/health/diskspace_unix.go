@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package health
+
+import (
+	"errors"
+	"syscall"
+)
+
+var errUnsupported = errors.New("unsupported platform")
+
+func freeSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
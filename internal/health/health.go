@@ -0,0 +1,187 @@
+// Package health runs the dependency checks behind the readiness
+// endpoint.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"minibb/internal/db"
+	"minibb/internal/jobs"
+)
+
+const checkTimeout = 2 * time.Second
+
+// Check statuses. A warning is a failed check that isn't critical.
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+// Check is a named dependency check. Run returns a detail to report on
+// success. Failing checks that aren't critical are reported as warnings
+// without failing readiness.
+type Check struct {
+	Name     string
+	Critical bool
+	Run      func(ctx context.Context) (string, error)
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Detail    string  `json:"detail,omitempty"`
+}
+
+// Report is the outcome of all checks. Status is ok or fail.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Checker runs the readiness checks. Once draining it reports failure so
+// that load balancers stop sending traffic before the server stops.
+type Checker struct {
+	checks   []Check
+	draining atomic.Bool
+}
+
+func NewChecker(checks ...Check) *Checker {
+	return &Checker{checks: checks}
+}
+
+// Drain makes readiness fail from now on.
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Ready runs all checks concurrently.
+func (c *Checker) Ready(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(c.checks)+1)}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			result := run(ctx, check)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[check.Name] = result
+			if result.Status == StatusFail {
+				report.Status = StatusFail
+			}
+		}(check)
+	}
+	wg.Wait()
+
+	if c.draining.Load() {
+		report.Status = StatusFail
+		report.Checks["shutdown"] = Result{Status: StatusFail, Detail: "server is shutting down"}
+	}
+
+	return report
+}
+
+func run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	detail, err := check.Run(ctx)
+	result := Result{
+		Status:    StatusOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		Detail:    detail,
+	}
+	if err != nil {
+		result.Status = StatusWarn
+		if check.Critical {
+			result.Status = StatusFail
+		}
+		result.Detail = err.Error()
+	}
+	return result
+}
+
+// Database checks that the database file can be queried.
+func Database(database *sql.DB) Check {
+	return Check{Name: "database", Critical: true, Run: func(ctx context.Context) (string, error) {
+		var tables int
+		err := database.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master").Scan(&tables)
+		return "", err
+	}}
+}
+
+// Migrations checks that the schema matches the binary.
+func Migrations(database *sql.DB) Check {
+	return Check{Name: "migrations", Critical: true, Run: func(ctx context.Context) (string, error) {
+		return "", db.CheckMigrations(database)
+	}}
+}
+
+// DiskSpace checks that the volume holding path has at least minFree
+// bytes available.
+func DiskSpace(path string, minFree uint64) Check {
+	dir := filepath.Dir(path)
+	return Check{Name: "disk", Critical: true, Run: func(ctx context.Context) (string, error) {
+		free, err := freeSpace(dir)
+		if err == errUnsupported {
+			return "free space is not checked on this platform", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		detail := fmt.Sprintf("%d MB free in %s", free>>20, dir)
+		if free < minFree {
+			return "", fmt.Errorf("only %s, need %d MB", detail, minFree>>20)
+		}
+		return detail, nil
+	}}
+}
+
+// Jobs reports background jobs whose last run failed. It is not critical:
+// a failed backup shouldn't take the forum out of rotation.
+func Jobs(trackers ...interface{ Status() jobs.Status }) Check {
+	return Check{Name: "jobs", Run: func(ctx context.Context) (string, error) {
+		var failed, ran []string
+		for _, tracker := range trackers {
+			status := tracker.Status()
+			switch {
+			case status.LastError != "":
+				failed = append(failed, status.Name+": "+status.LastError)
+			case !status.LastRun.IsZero():
+				ran = append(ran, status.Name+" ran "+status.LastRun.UTC().Format(time.RFC3339))
+			}
+		}
+		if len(failed) > 0 {
+			return "", fmt.Errorf("%s", strings.Join(failed, "; "))
+		}
+		return strings.Join(ran, ", "), nil
+	}}
+}
+
+type contextKey string
+
+const checkerContextKey contextKey = "health"
+
+func WithChecker(ctx context.Context, c *Checker) context.Context {
+	return context.WithValue(ctx, checkerContextKey, c)
+}
+
+func FromContext(ctx context.Context) *Checker {
+	c, ok := ctx.Value(checkerContextKey).(*Checker)
+	if !ok {
+		panic("health checker not found in context")
+	}
+	return c
+}
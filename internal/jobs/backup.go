@@ -0,0 +1,105 @@
+package jobs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"minibb/internal/db"
+	"minibb/internal/logging"
+)
+
+const (
+	backupPrefix = "minibb-"
+	backupSuffix = ".db"
+)
+
+// Backups writes periodic snapshots of the database into a directory and
+// keeps only the most recent ones.
+type Backups struct {
+	tracker
+	database db.Config
+	dir      string
+	interval time.Duration
+	keep     int
+}
+
+func NewBackups(database db.Config, dir string, interval time.Duration, keep int) *Backups {
+	b := &Backups{database: database, dir: dir, interval: interval, keep: keep}
+	b.status.Name = "backup"
+	return b
+}
+
+// ConfigureBackups sets up scheduled backups of database into dir,
+// creating it if needed. It returns nil if dir is empty.
+func ConfigureBackups(database db.Config, dir string, interval time.Duration, keep int) (*Backups, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return NewBackups(database, dir, interval, keep), nil
+}
+
+// Run takes a snapshot on every interval until ctx is cancelled.
+func (b *Backups) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		path, err := b.RunOnce()
+		b.record(err)
+		if err != nil {
+			logging.For(logging.Jobs).Error("scheduled backup failed", "error", err)
+			continue
+		}
+		logging.For(logging.Jobs).Info("wrote backup", "path", path)
+	}
+}
+
+// RunOnce writes a snapshot and removes all but the newest snapshots.
+func (b *Backups) RunOnce() (string, error) {
+	name := backupPrefix + time.Now().UTC().Format("20060102-150405") + backupSuffix
+	path := filepath.Join(b.dir, name)
+	if err := db.Backup(b.database, path); err != nil {
+		return "", err
+	}
+
+	return path, b.rotate()
+}
+
+func (b *Backups) rotate() error {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return err
+	}
+
+	// The timestamped names sort chronologically.
+	var snapshots []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			snapshots = append(snapshots, name)
+		}
+	}
+	sort.Strings(snapshots)
+
+	for len(snapshots) > b.keep {
+		if err := os.Remove(filepath.Join(b.dir, snapshots[0])); err != nil {
+			return err
+		}
+		snapshots = snapshots[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+// Package jobs contains the background jobs run by the server.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"minibb/internal/events"
+	"minibb/internal/logging"
+	"minibb/internal/models"
+	"minibb/internal/tracing"
+)
+
+// Pruner archives threads beyond each board's active thread limit and
+// deletes archived threads once the retention period has passed.
+type Pruner struct {
+	tracker
+	db        *sql.DB
+	events    *events.Hub
+	interval  time.Duration
+	retention time.Duration
+	now       func() time.Time
+}
+
+// NewPruner creates a pruner that runs every interval and publishes the
+// threads it archives and deletes to hub. A retention of 0 keeps archived
+// threads forever.
+func NewPruner(db *sql.DB, hub *events.Hub, interval, retention time.Duration) *Pruner {
+	p := &Pruner{db: db, events: hub, interval: interval, retention: retention, now: time.Now}
+	p.status.Name = "prune"
+	return p
+}
+
+// WithClock replaces the time source the retention period is measured
+// against.
+func (p *Pruner) WithClock(now func() time.Time) *Pruner {
+	p.now = now
+	return p
+}
+
+// Run prunes once immediately and then on every interval until ctx is
+// cancelled.
+func (p *Pruner) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		err := p.RunOnce(ctx)
+		p.record(err)
+		if err != nil {
+			logging.For(logging.Jobs).Error("thread pruning failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pruner) RunOnce(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "jobs.Prune")
+	defer span.End()
+
+	boards, err := models.GetAllBoards(ctx, p.db)
+	if err != nil {
+		return err
+	}
+
+	for _, board := range boards {
+		settings, err := models.GetBoardSettings(ctx, p.db, board.ID)
+		if err != nil {
+			return err
+		}
+		if settings.MaxActiveThreads == 0 {
+			continue
+		}
+
+		archived, err := models.ArchiveExcessTopics(ctx, p.db, board.ID, settings.MaxActiveThreads)
+		if err != nil {
+			return err
+		}
+		if archived > 0 {
+			p.events.Publish(events.Event{Kind: "topics.archive", BoardID: board.ID})
+			logging.For(logging.Jobs).Info("archived threads", "board", board.Slug, "count", archived)
+		}
+	}
+
+	if p.retention > 0 {
+		deleted, err := models.DeleteArchivedTopicsBefore(ctx, p.db, p.now().Add(-p.retention))
+		if err != nil {
+			return err
+		}
+		if deleted > 0 {
+			p.events.Publish(events.Event{Kind: "topics.expire"})
+			logging.For(logging.Jobs).Info("deleted expired archived threads", "count", deleted)
+		}
+	}
+
+	return nil
+}
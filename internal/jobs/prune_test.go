@@ -0,0 +1,186 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"minibb/internal/db"
+	"minibb/internal/events"
+	"minibb/internal/models"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	config := db.DefaultConfig()
+	config.Path = filepath.Join(t.TempDir(), "test.db")
+	database, err := db.Init(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+// createTopics creates n visible topics on board, bumped a minute apart in
+// the order they are returned.
+func createTopics(t *testing.T, database *sql.DB, board, n int) []int {
+	t.Helper()
+	start := time.Now().Add(-time.Hour)
+	var ids []int
+	for i := 0; i < n; i++ {
+		topic, err := models.CreateTopic(context.Background(), database, board, "Topic", "alice", "text", "10.0.0.1", models.VisibilityVisible)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bumped := models.FormatDBTime(start.Add(time.Duration(i) * time.Minute))
+		if _, err := database.Exec(`UPDATE topics SET bumped_at = ? WHERE id = ?`, bumped, topic.ID); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, topic.ID)
+	}
+	return ids
+}
+
+func statuses(t *testing.T, database *sql.DB, ids []int) []string {
+	t.Helper()
+	var result []string
+	for _, id := range ids {
+		topic, err := models.GetTopicByID(context.Background(), database, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if topic == nil {
+			result = append(result, "deleted")
+			continue
+		}
+		result = append(result, topic.Status)
+	}
+	return result
+}
+
+func TestPrunerArchivesExcessTopics(t *testing.T) {
+	database := openTestDB(t)
+	ctx := context.Background()
+
+	settings, err := models.GetBoardSettings(ctx, database, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.MaxActiveThreads = 2
+	if err := models.SaveBoardSettings(ctx, database, *settings); err != nil {
+		t.Fatal(err)
+	}
+
+	general := createTopics(t, database, 1, 4)
+	// A pending topic neither counts towards the limit nor is archived.
+	pending, err := models.CreateTopic(ctx, database, 1, "Held", "bob", "text", "10.0.0.2", models.VisibilityPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The other board has no limit.
+	watercooler := createTopics(t, database, 2, 3)
+
+	hub := events.NewHub()
+	var published []events.Event
+	hub.Subscribe(func(e events.Event) { published = append(published, e) })
+
+	pruner := NewPruner(database, hub, time.Hour, 0)
+	if err := pruner.RunOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"archived", "archived", "open", "open"}
+	for i, status := range statuses(t, database, general) {
+		if status != want[i] {
+			t.Errorf("general topic %d: status %q, want %q", i, status, want[i])
+		}
+	}
+	for i, status := range statuses(t, database, append(watercooler, pending.ID)) {
+		if status != "open" {
+			t.Errorf("topic %d outside the limit: status %q, want open", i, status)
+		}
+	}
+	if len(published) != 1 || published[0].Kind != "topics.archive" || published[0].BoardID != 1 {
+		t.Errorf("published events = %+v, want one topics.archive for board 1", published)
+	}
+
+	// A second run finds nothing left to do.
+	published = nil
+	if err := pruner.RunOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 0 {
+		t.Errorf("second run published %+v", published)
+	}
+}
+
+func TestPrunerDeletesExpiredTopics(t *testing.T) {
+	database := openTestDB(t)
+	ctx := context.Background()
+
+	ids := createTopics(t, database, 1, 3)
+	if err := models.SetTopicStatus(ctx, database, ids[0], "archived"); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.SetTopicStatus(ctx, database, ids[1], "archived"); err != nil {
+		t.Fatal(err)
+	}
+	old := models.FormatDBTime(time.Now().Add(-48 * time.Hour))
+	if _, err := database.Exec(`UPDATE topics SET archived_at = ? WHERE id = ?`, old, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	hub := events.NewHub()
+	var published []events.Event
+	hub.Subscribe(func(e events.Event) { published = append(published, e) })
+
+	now := time.Now()
+	pruner := NewPruner(database, hub, time.Hour, 24*time.Hour).WithClock(func() time.Time { return now })
+	if err := pruner.RunOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"deleted", "archived", "open"}
+	for i, status := range statuses(t, database, ids) {
+		if status != want[i] {
+			t.Errorf("topic %d: status %q, want %q", i, status, want[i])
+		}
+	}
+	var posts int
+	if err := database.QueryRow(`SELECT COUNT(*) FROM posts WHERE topic_id = ?`, ids[0]).Scan(&posts); err != nil {
+		t.Fatal(err)
+	}
+	if posts != 0 {
+		t.Errorf("%d posts of the deleted topic left", posts)
+	}
+	if len(published) != 1 || published[0].Kind != "topics.expire" {
+		t.Errorf("published events = %+v, want one topics.expire", published)
+	}
+
+	// Once the retention period has passed for the other one, it goes too.
+	now = now.Add(25 * time.Hour)
+	if err := pruner.RunOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"deleted", "deleted", "open"}
+	for i, status := range statuses(t, database, ids) {
+		if status != want[i] {
+			t.Errorf("after retention: topic %d status %q, want %q", i, status, want[i])
+		}
+	}
+
+	// Without a retention period archived topics are kept forever.
+	if err := models.SetTopicStatus(ctx, database, ids[2], "archived"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(365 * 24 * time.Hour)
+	if err := NewPruner(database, hub, time.Hour, 0).WithClock(func() time.Time { return now }).RunOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if status := statuses(t, database, ids[2:]); status[0] != "archived" {
+		t.Errorf("without retention: status %q, want archived", status[0])
+	}
+}
@@ -0,0 +1,37 @@
+package jobs
+
+import (
+	"sync"
+	"time"
+)
+
+// Status is the outcome of a job's most recent run.
+type Status struct {
+	Name      string    `json:"name"`
+	LastRun   time.Time `json:"last_run"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// tracker remembers the most recent run of a job for health checks.
+type tracker struct {
+	mu     sync.Mutex
+	status Status
+}
+
+func (t *tracker) record(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.LastRun = time.Now()
+	t.status.LastError = ""
+	if err != nil {
+		t.status.LastError = err.Error()
+	}
+}
+
+// Status returns the outcome of the most recent run. LastRun is zero if
+// the job has not run yet.
+func (t *tracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
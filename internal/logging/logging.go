@@ -0,0 +1,149 @@
+// Package logging sets up structured logging with log/slog. Each
+// subsystem gets its own logger whose level can be configured separately,
+// and request handlers find a logger carrying the request ID in their
+// context.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Subsystems used across the server.
+const (
+	Server = "server"
+	HTTP   = "http"
+	Jobs   = "jobs"
+)
+
+// Config selects the output format and levels. Levels overrides Level for
+// individual subsystems.
+type Config struct {
+	Format string
+	Level  slog.Level
+	Levels map[string]slog.Level
+}
+
+// ParseConfig validates the output format (json or text) and parses
+// levels, a default level optionally followed by subsystem overrides, for
+// example "info,http=warn,jobs=debug".
+func ParseConfig(format, levels string) (Config, error) {
+	config := Config{
+		Format: format,
+		Level:  slog.LevelInfo,
+		Levels: make(map[string]slog.Level),
+	}
+	if format != "json" && format != "text" {
+		return Config{}, fmt.Errorf("format must be json or text")
+	}
+
+	for _, part := range strings.Split(levels, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		subsystem, value, override := strings.Cut(part, "=")
+		if !override {
+			value = subsystem
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return Config{}, fmt.Errorf("invalid level %q: %w", part, err)
+		}
+		if override {
+			config.Levels[strings.TrimSpace(subsystem)] = level
+		} else {
+			config.Level = level
+		}
+	}
+
+	return config, nil
+}
+
+var (
+	mu     sync.RWMutex
+	output slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	config              = Config{Level: slog.LevelInfo}
+)
+
+// Setup directs all loggers to stderr in the configured format. The
+// standard library's log package is routed through the server logger.
+func Setup(c Config) {
+	// Levels are checked per subsystem, so the output handler lets
+	// everything through.
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var handler slog.Handler
+	if c.Format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+
+	mu.Lock()
+	output = handler
+	config = c
+	mu.Unlock()
+
+	slog.SetDefault(For(Server))
+}
+
+func levelFor(subsystem string) slog.Level {
+	mu.RLock()
+	defer mu.RUnlock()
+	if level, ok := config.Levels[subsystem]; ok {
+		return level
+	}
+	return config.Level
+}
+
+// For returns the logger of a subsystem. Its level is looked up on every
+// record, so it follows later calls to Setup.
+func For(subsystem string) *slog.Logger {
+	mu.RLock()
+	handler := output
+	mu.RUnlock()
+	return slog.New(&levelHandler{
+		Handler:   handler.WithAttrs([]slog.Attr{slog.String("subsystem", subsystem)}),
+		subsystem: subsystem,
+	})
+}
+
+// levelHandler filters records by the level of its subsystem.
+type levelHandler struct {
+	slog.Handler
+	subsystem string
+}
+
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= levelFor(h.subsystem)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithAttrs(attrs), subsystem: h.subsystem}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithGroup(name), subsystem: h.subsystem}
+}
+
+type contextKey string
+
+const loggerKey contextKey = "logger"
+
+// WithLogger stores a request's logger in its context.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the request's logger, or the HTTP subsystem's logger
+// outside of a request.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return For(HTTP)
+}
@@ -0,0 +1,302 @@
+// Package metrics keeps counters, gauges and histograms in memory and
+// writes them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry served at /metrics. The metrics in this package
+// register themselves with it.
+var Default = &Registry{}
+
+// Registry holds metrics in registration order.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes every metric in the text exposition format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the default registry.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.Write(w)
+	})
+}
+
+// desc is the part shared by all metric types.
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	w.WriteString("# HELP " + d.name + " " + escapeHelp(d.help) + "\n")
+	w.WriteString("# TYPE " + d.name + " " + d.kind + "\n")
+}
+
+// labelKey joins label values into a map key. The separator can't appear
+// in valid UTF-8.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func (d *desc) checkValues(values []string) {
+	if len(values) != len(d.labels) {
+		panic("metrics: " + d.name + " expects " + strconv.Itoa(len(d.labels)) + " label values")
+	}
+}
+
+// formatLabels renders {a="x",b="y"} with any extra pairs appended.
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name + `="` + escapeLabel(values[i]) + `"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if sb.Len() > 1 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(extra[i] + `="` + escapeLabel(extra[i+1]) + `"`)
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec is a counter partitioned by labels. With no labels it is a
+// plain counter.
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+	series map[string][]string
+}
+
+// NewCounter registers a counter with the default registry.
+func NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{name: name, help: help, kind: "counter", labels: labels},
+		values: make(map[string]float64),
+		series: make(map[string][]string),
+	}
+	Default.register(c)
+	return c
+}
+
+// Inc adds one to the series with the given label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the series with the given label values.
+func (c *CounterVec) Add(v float64, values ...string) {
+	c.checkValues(values)
+	key := labelKey(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.series[key]; !ok {
+		c.series[key] = append([]string(nil), values...)
+	}
+	c.values[key] += v
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		// A plain counter is reported from the start.
+		w.WriteString(c.name + " 0\n")
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		w.WriteString(c.name + formatLabels(c.labels, c.series[key]) + " " + formatValue(c.values[key]) + "\n")
+	}
+}
+
+// DefaultBuckets suit HTTP request latencies in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// HistogramVec counts observations into cumulative buckets, partitioned by
+// labels.
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the default registry. buckets
+// are upper bounds in increasing order; +Inf is implied.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+	Default.register(h)
+	return h
+}
+
+// Observe records v in the series with the given label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.checkValues(values)
+	key := labelKey(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{
+			values: append([]string(nil), values...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			w.WriteString(h.name + "_bucket" + formatLabels(h.labels, s.values, "le", formatValue(bound)) +
+				" " + strconv.FormatUint(s.counts[i], 10) + "\n")
+		}
+		w.WriteString(h.name + "_bucket" + formatLabels(h.labels, s.values, "le", "+Inf") +
+			" " + strconv.FormatUint(s.count, 10) + "\n")
+		w.WriteString(h.name + "_sum" + formatLabels(h.labels, s.values) + " " + formatValue(s.sum) + "\n")
+		w.WriteString(h.name + "_count" + formatLabels(h.labels, s.values) + " " + strconv.FormatUint(s.count, 10) + "\n")
+	}
+}
+
+// FuncVec reports values computed when the metrics are scraped, for state
+// that is already kept elsewhere such as connection pool statistics.
+type FuncVec struct {
+	desc
+	mu     sync.Mutex
+	funcs  map[string]func() float64
+	series map[string][]string
+}
+
+// NewGaugeFunc registers a gauge whose series are read at scrape time.
+func NewGaugeFunc(name, help string, labels ...string) *FuncVec {
+	return newFuncVec("gauge", name, help, labels)
+}
+
+// NewCounterFunc registers a counter whose series are read at scrape time.
+func NewCounterFunc(name, help string, labels ...string) *FuncVec {
+	return newFuncVec("counter", name, help, labels)
+}
+
+func newFuncVec(kind, name, help string, labels []string) *FuncVec {
+	f := &FuncVec{
+		desc:   desc{name: name, help: help, kind: kind, labels: labels},
+		funcs:  make(map[string]func() float64),
+		series: make(map[string][]string),
+	}
+	Default.register(f)
+	return f
+}
+
+// Set makes fn the source of the series with the given label values.
+func (f *FuncVec) Set(fn func() float64, values ...string) {
+	f.checkValues(values)
+	key := labelKey(values)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.funcs[key] = fn
+	f.series[key] = append([]string(nil), values...)
+}
+
+func (f *FuncVec) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range sortedKeys(f.funcs) {
+		w.WriteString(f.name + formatLabels(f.labels, f.series[key]) + " " + formatValue(f.funcs[key]()) + "\n")
+	}
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Rejection reasons for PostingRejections.
+const (
+	RejectReadOnly = "read_only"
+	RejectBan      = "ban"
+	RejectPow      = "pow"
+	RejectFilter   = "filter"
+)
+
+var (
+	HTTPRequests = NewCounter("minibb_http_requests_total",
+		"HTTP requests by method, route pattern and status code.",
+		"method", "route", "status")
+	HTTPDuration = NewHistogram("minibb_http_request_duration_seconds",
+		"HTTP request latency by method and route pattern.",
+		DefaultBuckets, "method", "route")
+
+	QueryDuration = NewHistogram("minibb_db_query_duration_seconds",
+		"Time spent in each model function.",
+		[]float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, 1},
+		"function")
+
+	TopicsCreated = NewCounter("minibb_topics_created_total",
+		"Topics created.")
+	PostsCreated = NewCounter("minibb_posts_created_total",
+		"Posts created, including the opening posts of new topics.")
+	PostingRejections = NewCounter("minibb_posting_rejections_total",
+		"Rejected posts by reason: read_only, ban, pow or filter.",
+		"reason")
+
+	CSPViolations = NewCounter("minibb_csp_violations_total",
+		"Content-Security-Policy violations reported by browsers, by directive.",
+		"directive")
+
+	ResponseCacheHits = NewCounter("minibb_response_cache_hits_total",
+		"Read responses served from the response cache.")
+	ResponseCacheMisses = NewCounter("minibb_response_cache_misses_total",
+		"Cacheable read responses that had to be rendered.")
+	ResponseCacheEvictions = NewCounter("minibb_response_cache_evictions_total",
+		"Cached responses dropped to make room for others.")
+	ResponseCacheInvalidations = NewCounter("minibb_response_cache_invalidations_total",
+		"Cached responses dropped because a write changed them.")
+	ResponseCacheEntries = NewGaugeFunc("minibb_response_cache_entries",
+		"Responses held in the response cache.")
+
+	dbOpenConnections = NewGaugeFunc("minibb_db_open_connections",
+		"Open connections by pool.", "pool")
+	dbInUseConnections = NewGaugeFunc("minibb_db_in_use_connections",
+		"Connections in use by pool.", "pool")
+	dbIdleConnections = NewGaugeFunc("minibb_db_idle_connections",
+		"Idle connections by pool.", "pool")
+	dbMaxOpenConnections = NewGaugeFunc("minibb_db_max_open_connections",
+		"Connection limit by pool.", "pool")
+	dbWaitCount = NewCounterFunc("minibb_db_wait_count_total",
+		"Times a query waited for a free connection, by pool.", "pool")
+	dbWaitDuration = NewCounterFunc("minibb_db_wait_duration_seconds_total",
+		"Time spent waiting for a free connection, by pool.", "pool")
+)
+
+// ObserveQuery records the time since start for a model function. It is
+// meant to be deferred: defer metrics.ObserveQuery("GetTopicByID", time.Now())
+func ObserveQuery(function string, start time.Time) {
+	QueryDuration.Observe(time.Since(start).Seconds(), function)
+}
+
+// RegisterPool reports the connection statistics of a database pool.
+func RegisterPool(pool string, db *sql.DB) {
+	dbOpenConnections.Set(func() float64 { return float64(db.Stats().OpenConnections) }, pool)
+	dbInUseConnections.Set(func() float64 { return float64(db.Stats().InUse) }, pool)
+	dbIdleConnections.Set(func() float64 { return float64(db.Stats().Idle) }, pool)
+	dbMaxOpenConnections.Set(func() float64 { return float64(db.Stats().MaxOpenConnections) }, pool)
+	dbWaitCount.Set(func() float64 { return float64(db.Stats().WaitCount) }, pool)
+	dbWaitDuration.Set(func() float64 { return db.Stats().WaitDuration.Seconds() }, pool)
+}
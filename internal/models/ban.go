@@ -0,0 +1,135 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type Ban struct {
+	ID        int        `json:"id"`
+	IP        string     `json:"ip"`
+	Reason    string     `json:"reason"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	LiftedAt  *time.Time `json:"lifted_at"`
+}
+
+const banColumns = `id, ip, reason, created_by, created_at, expires_at, lifted_at`
+
+const activeBanCondition = `lifted_at IS NULL
+	AND (expires_at IS NULL OR expires_at > datetime('now'))`
+
+func scanBan(row interface{ Scan(...interface{}) error }) (*Ban, error) {
+	var ban Ban
+	var expiresAt, liftedAt sql.NullTime
+	if err := row.Scan(
+		&ban.ID, &ban.IP, &ban.Reason, &ban.CreatedBy, &ban.CreatedAt,
+		&expiresAt, &liftedAt,
+	); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		ban.ExpiresAt = &expiresAt.Time
+	}
+	if liftedAt.Valid {
+		ban.LiftedAt = &liftedAt.Time
+	}
+	return &ban, nil
+}
+
+func GetBanByID(ctx context.Context, db Querier, id int) (*Ban, error) {
+	_, span := startSpan(ctx, "GetBanByID")
+	defer span.End()
+
+	query := `SELECT ` + banColumns + ` FROM bans WHERE id = ?`
+	ban, err := scanBan(db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ban, nil
+}
+
+func GetActiveBanByIP(ctx context.Context, db Querier, ip string) (*Ban, error) {
+	_, span := startSpan(ctx, "GetActiveBanByIP")
+	defer span.End()
+
+	query := `SELECT ` + banColumns + ` FROM bans
+		WHERE ip = ? AND ` + activeBanCondition + `
+		ORDER BY id DESC LIMIT 1`
+	ban, err := scanBan(db.QueryRow(query, ip))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ban, nil
+}
+
+func GetActiveBans(ctx context.Context, db Querier) ([]Ban, error) {
+	_, span := startSpan(ctx, "GetActiveBans")
+	defer span.End()
+
+	query := `SELECT ` + banColumns + ` FROM bans
+		WHERE ` + activeBanCondition + ` ORDER BY id DESC`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []Ban
+	for rows.Next() {
+		ban, err := scanBan(rows)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, *ban)
+	}
+
+	return bans, rows.Err()
+}
+
+// CreateBan bans an IP address. A zero duration creates a permanent ban.
+func CreateBan(ctx context.Context, db Querier, ip, reason, createdBy string, duration time.Duration) (*Ban, error) {
+	ctx, span := startSpan(ctx, "CreateBan")
+	defer span.End()
+
+	var expiresAt interface{}
+	if duration > 0 {
+		expiresAt = FormatDBTime(time.Now().Add(duration))
+	}
+
+	query := `INSERT INTO bans (ip, reason, created_by, expires_at) VALUES (?, ?, ?, ?)`
+	result, err := db.Exec(query, ip, reason, createdBy, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	banID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return GetBanByID(ctx, db, int(banID))
+}
+
+func LiftBan(ctx context.Context, db Querier, id int) error {
+	_, span := startSpan(ctx, "LiftBan")
+	defer span.End()
+
+	query := `UPDATE bans SET lifted_at = datetime('now') WHERE id = ? AND lifted_at IS NULL`
+	_, err := db.Exec(query, id)
+	return err
+}
+
+// FormatDBTime formats a timestamp the way SQLite's datetime() does so that
+// stored values compare correctly against CURRENT_TIMESTAMP.
+func FormatDBTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
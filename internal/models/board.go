@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 )
 
@@ -10,7 +11,10 @@ type Board struct {
 	Description string `json:"description"`
 }
 
-func GetAllBoards(db *sql.DB) ([]Board, error) {
+func GetAllBoards(ctx context.Context, db Querier) ([]Board, error) {
+	_, span := startSpan(ctx, "GetAllBoards")
+	defer span.End()
+
 	query := `SELECT id, slug, description FROM boards ORDER BY id`
 	rows, err := db.Query(query)
 	if err != nil {
@@ -30,7 +34,10 @@ func GetAllBoards(db *sql.DB) ([]Board, error) {
 	return boards, rows.Err()
 }
 
-func GetBoardByID(db *sql.DB, id int) (*Board, error) {
+func GetBoardByID(ctx context.Context, db Querier, id int) (*Board, error) {
+	_, span := startSpan(ctx, "GetBoardByID")
+	defer span.End()
+
 	query := `SELECT id, slug, description FROM boards WHERE id = ?`
 	var board Board
 	err := db.QueryRow(query, id).Scan(&board.ID, &board.Slug, &board.Description)
@@ -43,7 +50,11 @@ func GetBoardByID(db *sql.DB, id int) (*Board, error) {
 	return &board, nil
 }
 
-func GetBoardBySlug(db *sql.DB, slug string) (*Board, error) {
+func GetBoardBySlug(ctx context.Context, db Querier, slug string) (*Board, error) {
+	_, span := startSpan(ctx, "GetBoardBySlug")
+	defer span.End()
+	span.SetString("minibb.board", slug)
+
 	query := `SELECT id, slug, description FROM boards WHERE slug = ?`
 	var board Board
 	err := db.QueryRow(query, slug).Scan(&board.ID, &board.Slug, &board.Description)
@@ -55,3 +66,44 @@ func GetBoardBySlug(db *sql.DB, slug string) (*Board, error) {
 	}
 	return &board, nil
 }
+
+func UpdateBoard(ctx context.Context, db Querier, board Board) error {
+	_, span := startSpan(ctx, "UpdateBoard")
+	defer span.End()
+
+	query := `UPDATE boards SET description = ? WHERE id = ?`
+	_, err := db.Exec(query, board.Description, board.ID)
+	return err
+}
+
+// CreateBoard creates a board together with its default settings.
+func CreateBoard(ctx context.Context, db Querier, slug, description string) (*Board, error) {
+	ctx, span := startSpan(ctx, "CreateBoard")
+	defer span.End()
+
+	tx, err := begin(db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO boards (slug, description) VALUES (?, ?)`, slug, description)
+	if err != nil {
+		return nil, err
+	}
+
+	boardID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO board_settings (board_id) VALUES (?)`, boardID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetBoardByID(ctx, db, int(boardID))
+}
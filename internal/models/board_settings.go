@@ -0,0 +1,156 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// MarkupExtensions lists the markdown extensions a board can enable.
+var MarkupExtensions = []string{
+	"table", "strikethrough", "linkify", "tasklist", "footnote", "typographer",
+}
+
+// BoardSettings holds the per-board behaviour. Zero values for the
+// thread and bump limits mean unlimited.
+type BoardSettings struct {
+	BoardID          int      `json:"board_id"`
+	ReadOnly         bool     `json:"read_only"`
+	DefaultName      string   `json:"default_name"`
+	MaxTitleLength   int      `json:"max_title_length"`
+	MaxBodyLength    int      `json:"max_body_length"`
+	MaxActiveThreads int      `json:"max_active_threads"`
+	BumpLimit        int      `json:"bump_limit"`
+	MarkupExtensions []string `json:"markup_extensions"`
+	PowDifficulty    int      `json:"pow_difficulty"`
+	Premoderate      bool     `json:"premoderate"`
+}
+
+// PublicBoardSettings is the subset of board settings clients need to
+// render forms and posts.
+type PublicBoardSettings struct {
+	ReadOnly         bool     `json:"read_only"`
+	DefaultName      string   `json:"default_name"`
+	MaxTitleLength   int      `json:"max_title_length"`
+	MaxBodyLength    int      `json:"max_body_length"`
+	BumpLimit        int      `json:"bump_limit"`
+	MarkupExtensions []string `json:"markup_extensions"`
+	PowDifficulty    int      `json:"pow_difficulty"`
+	Premoderate      bool     `json:"premoderate"`
+}
+
+func DefaultBoardSettings(boardID int) BoardSettings {
+	return BoardSettings{
+		BoardID:          boardID,
+		DefaultName:      "Anonymous",
+		MaxTitleLength:   200,
+		MaxBodyLength:    20000,
+		MarkupExtensions: []string{"strikethrough", "linkify"},
+	}
+}
+
+func (s BoardSettings) Public() PublicBoardSettings {
+	return PublicBoardSettings{
+		ReadOnly:         s.ReadOnly,
+		DefaultName:      s.DefaultName,
+		MaxTitleLength:   s.MaxTitleLength,
+		MaxBodyLength:    s.MaxBodyLength,
+		BumpLimit:        s.BumpLimit,
+		MarkupExtensions: s.MarkupExtensions,
+		PowDifficulty:    s.PowDifficulty,
+		Premoderate:      s.Premoderate,
+	}
+}
+
+func (s BoardSettings) Validate() error {
+	if strings.TrimSpace(s.DefaultName) == "" {
+		return fmt.Errorf("default_name must not be empty")
+	}
+	if s.MaxTitleLength <= 0 || s.MaxBodyLength <= 0 {
+		return fmt.Errorf("maximum title and body lengths must be positive")
+	}
+	if s.MaxActiveThreads < 0 || s.BumpLimit < 0 {
+		return fmt.Errorf("thread and bump limits must not be negative")
+	}
+	if s.PowDifficulty < 0 || s.PowDifficulty > 32 {
+		return fmt.Errorf("pow_difficulty must be between 0 and 32")
+	}
+	for _, ext := range s.MarkupExtensions {
+		if !isMarkupExtension(ext) {
+			return fmt.Errorf("unknown markup extension %q", ext)
+		}
+	}
+	return nil
+}
+
+func isMarkupExtension(name string) bool {
+	for _, ext := range MarkupExtensions {
+		if ext == name {
+			return true
+		}
+	}
+	return false
+}
+
+func splitExtensions(s string) []string {
+	extensions := []string{}
+	for _, ext := range strings.Split(s, ",") {
+		if ext = strings.TrimSpace(ext); ext != "" {
+			extensions = append(extensions, ext)
+		}
+	}
+	return extensions
+}
+
+// GetBoardSettings returns the settings of a board, falling back to the
+// defaults for boards without a settings row.
+func GetBoardSettings(ctx context.Context, db Querier, boardID int) (*BoardSettings, error) {
+	_, span := startSpan(ctx, "GetBoardSettings")
+	defer span.End()
+
+	query := `SELECT board_id, read_only, default_name, max_title_length, max_body_length,
+		max_active_threads, bump_limit, markup_extensions, pow_difficulty, premoderate
+		FROM board_settings WHERE board_id = ?`
+	var settings BoardSettings
+	var extensions string
+	err := db.QueryRow(query, boardID).Scan(
+		&settings.BoardID, &settings.ReadOnly, &settings.DefaultName,
+		&settings.MaxTitleLength, &settings.MaxBodyLength, &settings.MaxActiveThreads,
+		&settings.BumpLimit, &extensions, &settings.PowDifficulty, &settings.Premoderate,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			defaults := DefaultBoardSettings(boardID)
+			return &defaults, nil
+		}
+		return nil, err
+	}
+	settings.MarkupExtensions = splitExtensions(extensions)
+	return &settings, nil
+}
+
+func SaveBoardSettings(ctx context.Context, db Querier, settings BoardSettings) error {
+	_, span := startSpan(ctx, "SaveBoardSettings")
+	defer span.End()
+
+	query := `INSERT INTO board_settings (board_id, read_only, default_name, max_title_length,
+		max_body_length, max_active_threads, bump_limit, markup_extensions, pow_difficulty, premoderate)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(board_id) DO UPDATE SET
+			read_only = excluded.read_only,
+			default_name = excluded.default_name,
+			max_title_length = excluded.max_title_length,
+			max_body_length = excluded.max_body_length,
+			max_active_threads = excluded.max_active_threads,
+			bump_limit = excluded.bump_limit,
+			markup_extensions = excluded.markup_extensions,
+			pow_difficulty = excluded.pow_difficulty,
+			premoderate = excluded.premoderate`
+	_, err := db.Exec(query,
+		settings.BoardID, settings.ReadOnly, settings.DefaultName, settings.MaxTitleLength,
+		settings.MaxBodyLength, settings.MaxActiveThreads, settings.BumpLimit,
+		strings.Join(settings.MarkupExtensions, ","), settings.PowDifficulty, settings.Premoderate,
+	)
+	return err
+}
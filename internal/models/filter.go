@@ -0,0 +1,121 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// FilterRule is the stored configuration of a content filter. Rules without
+// a board apply to every board.
+type FilterRule struct {
+	ID            int       `json:"id"`
+	BoardID       *int      `json:"board_id"`
+	Kind          string    `json:"kind"`
+	Pattern       string    `json:"pattern"`
+	Action        string    `json:"action"`
+	Replacement   string    `json:"replacement"`
+	MaxLinks      int       `json:"max_links"`
+	WindowMinutes int       `json:"window_minutes"`
+	Enabled       bool      `json:"enabled"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+const filterRuleColumns = `id, board_id, kind, pattern, action, replacement,
+	max_links, window_minutes, enabled, created_at`
+
+func scanFilterRule(row interface{ Scan(...interface{}) error }) (*FilterRule, error) {
+	var rule FilterRule
+	if err := row.Scan(
+		&rule.ID, &rule.BoardID, &rule.Kind, &rule.Pattern, &rule.Action,
+		&rule.Replacement, &rule.MaxLinks, &rule.WindowMinutes, &rule.Enabled,
+		&rule.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func GetAllFilterRules(ctx context.Context, db Querier) ([]FilterRule, error) {
+	_, span := startSpan(ctx, "GetAllFilterRules")
+	defer span.End()
+
+	query := `SELECT ` + filterRuleColumns + ` FROM filters ORDER BY id`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []FilterRule
+	for rows.Next() {
+		rule, err := scanFilterRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func GetFilterRuleByID(ctx context.Context, db Querier, id int) (*FilterRule, error) {
+	_, span := startSpan(ctx, "GetFilterRuleByID")
+	defer span.End()
+
+	query := `SELECT ` + filterRuleColumns + ` FROM filters WHERE id = ?`
+	rule, err := scanFilterRule(db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return rule, nil
+}
+
+func CreateFilterRule(ctx context.Context, db Querier, rule FilterRule) (*FilterRule, error) {
+	ctx, span := startSpan(ctx, "CreateFilterRule")
+	defer span.End()
+
+	query := `INSERT INTO filters
+		(board_id, kind, pattern, action, replacement, max_links, window_minutes, enabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := db.Exec(query,
+		rule.BoardID, rule.Kind, rule.Pattern, rule.Action, rule.Replacement,
+		rule.MaxLinks, rule.WindowMinutes, rule.Enabled,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ruleID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return GetFilterRuleByID(ctx, db, int(ruleID))
+}
+
+func UpdateFilterRule(ctx context.Context, db Querier, rule FilterRule) error {
+	_, span := startSpan(ctx, "UpdateFilterRule")
+	defer span.End()
+
+	query := `UPDATE filters SET board_id = ?, kind = ?, pattern = ?, action = ?,
+		replacement = ?, max_links = ?, window_minutes = ?, enabled = ?
+		WHERE id = ?`
+	_, err := db.Exec(query,
+		rule.BoardID, rule.Kind, rule.Pattern, rule.Action, rule.Replacement,
+		rule.MaxLinks, rule.WindowMinutes, rule.Enabled, rule.ID,
+	)
+	return err
+}
+
+func DeleteFilterRule(ctx context.Context, db Querier, id int) error {
+	_, span := startSpan(ctx, "DeleteFilterRule")
+	defer span.End()
+
+	query := `DELETE FROM filters WHERE id = ?`
+	_, err := db.Exec(query, id)
+	return err
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"minibb/internal/metrics"
+	"minibb/internal/tracing"
+)
+
+// span instruments a model function with a trace span and a sample in the
+// query duration metric.
+type span struct {
+	*tracing.Span
+	function string
+	start    time.Time
+}
+
+// startSpan returns the span by value so that, with tracing disabled,
+// instrumenting a call allocates nothing.
+func startSpan(ctx context.Context, function string) (context.Context, span) {
+	ctx, traceSpan := tracing.Start(ctx, "models."+function)
+	return ctx, span{Span: traceSpan, function: function, start: time.Now()}
+}
+
+// End records the duration and ends the trace span.
+func (s span) End() {
+	metrics.ObserveQuery(s.function, s.start)
+	s.Span.End()
+}
+
+// tracedExec runs a statement of a transaction in its own span, recording
+// the number of affected rows.
+func tracedExec(ctx context.Context, tx Querier, name, query string, args ...interface{}) (sql.Result, error) {
+	_, span := tracing.Start(ctx, name)
+	defer span.End()
+
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	if span != nil {
+		if affected, err := result.RowsAffected(); err == nil {
+			span.SetInt("db.rows_affected", int(affected))
+		}
+	}
+	return result, nil
+}
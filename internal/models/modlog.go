@@ -0,0 +1,149 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+type ModLogEntry struct {
+	ID         int             `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   int             `json:"target_id"`
+	BoardID    *int            `json:"board_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	IP         string          `json:"ip"`
+}
+
+// ModLogFilter narrows down mod log queries. Zero values match everything.
+type ModLogFilter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   int
+	BoardID    int
+}
+
+func (f ModLogFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if f.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, f.Actor)
+	}
+	if f.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, f.Action)
+	}
+	if f.TargetType != "" {
+		conditions = append(conditions, "target_type = ?")
+		args = append(args, f.TargetType)
+	}
+	if f.TargetID != 0 {
+		conditions = append(conditions, "target_id = ?")
+		args = append(args, f.TargetID)
+	}
+	if f.BoardID != 0 {
+		conditions = append(conditions, "board_id = ?")
+		args = append(args, f.BoardID)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func CreateModLogEntry(ctx context.Context, db Querier, entry ModLogEntry) error {
+	_, span := startSpan(ctx, "CreateModLogEntry")
+	defer span.End()
+
+	query := `INSERT INTO mod_log
+		(actor, action, target_type, target_id, board_id, before_json, after_json, ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.Exec(query,
+		entry.Actor, entry.Action, entry.TargetType, entry.TargetID, entry.BoardID,
+		nullableJSON(entry.Before), nullableJSON(entry.After), entry.IP,
+	)
+	return err
+}
+
+// RecordModAction fills in the before and after snapshots of entry and
+// writes it to the mod log.
+func RecordModAction(ctx context.Context, db Querier, entry ModLogEntry, before, after interface{}) error {
+	ctx, span := startSpan(ctx, "RecordModAction")
+	defer span.End()
+
+	var err error
+	if before != nil {
+		if entry.Before, err = json.Marshal(before); err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		if entry.After, err = json.Marshal(after); err != nil {
+			return err
+		}
+	}
+	return CreateModLogEntry(ctx, db, entry)
+}
+
+func GetModLogEntriesWithPagination(ctx context.Context, db Querier, filter ModLogFilter, limit, offset int) ([]ModLogEntry, error) {
+	_, span := startSpan(ctx, "GetModLogEntriesWithPagination")
+	defer span.End()
+
+	where, args := filter.where()
+	query := `SELECT id, created_at, actor, action, target_type, target_id, board_id,
+		before_json, after_json, ip
+		FROM mod_log` + where + ` ORDER BY id DESC LIMIT ? OFFSET ?`
+	rows, err := db.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ModLogEntry
+	for rows.Next() {
+		var entry ModLogEntry
+		var before, after sql.NullString
+		if err := rows.Scan(
+			&entry.ID, &entry.CreatedAt, &entry.Actor, &entry.Action,
+			&entry.TargetType, &entry.TargetID, &entry.BoardID,
+			&before, &after, &entry.IP,
+		); err != nil {
+			return nil, err
+		}
+		if before.Valid {
+			entry.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			entry.After = json.RawMessage(after.String)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func CountModLogEntries(ctx context.Context, db Querier, filter ModLogFilter) (int, error) {
+	_, span := startSpan(ctx, "CountModLogEntries")
+	defer span.End()
+
+	where, args := filter.where()
+	query := `SELECT COUNT(*) FROM mod_log` + where
+	var count int
+	err := db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+func nullableJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
@@ -1,23 +1,55 @@
 package models
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"strings"
 	"time"
 )
 
+const (
+	VisibilityPending = "pending"
+	VisibilityVisible = "visible"
+	VisibilityHidden  = "hidden"
+)
+
 type Post struct {
-	ID      int       `json:"id"`
-	TopicID int       `json:"topic_id"`
-	Author  string    `json:"author"`
-	Content string    `json:"content"`
-	PubDate time.Time `json:"pub_date"`
+	ID         int       `json:"id"`
+	TopicID    int       `json:"topic_id"`
+	Author     string    `json:"author"`
+	Content    string    `json:"content"`
+	PubDate    time.Time `json:"pub_date"`
+	Visibility string    `json:"visibility"`
+	IP         string    `json:"-"`
+}
+
+// ContentHash returns the hash used to detect duplicate posts. Whitespace
+// and case differences do not change the hash.
+func ContentHash(content string) string {
+	normalized := strings.ToLower(strings.Join(strings.Fields(content), " "))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
 }
 
-func GetPostByID(db *sql.DB, id int) (*Post, error) {
-	query := `SELECT id, topic_id, author, content, pub_date FROM posts WHERE id = ?`
+// visibilityCondition returns the SQL condition restricting rows to visible
+// ones, or an always-true condition when hidden rows should be included.
+func visibilityCondition(column string, visibleOnly bool) string {
+	if !visibleOnly {
+		return "1 = 1"
+	}
+	return column + " = '" + VisibilityVisible + "'"
+}
+
+func GetPostByID(ctx context.Context, db Querier, id int) (*Post, error) {
+	_, span := startSpan(ctx, "GetPostByID")
+	defer span.End()
+
+	query := `SELECT id, topic_id, author, content, pub_date, visibility FROM posts WHERE id = ?`
 	var post Post
 	err := db.QueryRow(query, id).Scan(
-		&post.ID, &post.TopicID, &post.Author, &post.Content, &post.PubDate,
+		&post.ID, &post.TopicID, &post.Author, &post.Content, &post.PubDate, &post.Visibility,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -28,8 +60,11 @@ func GetPostByID(db *sql.DB, id int) (*Post, error) {
 	return &post, nil
 }
 
-func GetPostsByTopicID(db *sql.DB, topicID int) ([]Post, error) {
-	query := `SELECT id, topic_id, author, content, pub_date 
+func GetPostsByTopicID(ctx context.Context, db Querier, topicID int) ([]Post, error) {
+	_, span := startSpan(ctx, "GetPostsByTopicID")
+	defer span.End()
+
+	query := `SELECT id, topic_id, author, content, pub_date, visibility
 		FROM posts WHERE topic_id = ? ORDER BY pub_date ASC`
 	rows, err := db.Query(query, topicID)
 	if err != nil {
@@ -41,7 +76,7 @@ func GetPostsByTopicID(db *sql.DB, topicID int) ([]Post, error) {
 	for rows.Next() {
 		var post Post
 		if err := rows.Scan(
-			&post.ID, &post.TopicID, &post.Author, &post.Content, &post.PubDate,
+			&post.ID, &post.TopicID, &post.Author, &post.Content, &post.PubDate, &post.Visibility,
 		); err != nil {
 			return nil, err
 		}
@@ -51,16 +86,19 @@ func GetPostsByTopicID(db *sql.DB, topicID int) ([]Post, error) {
 	return posts, rows.Err()
 }
 
-func GetMostRecentPostByBoardID(db *sql.DB, boardID int) (*Post, error) {
-	query := `SELECT p.id, p.topic_id, p.author, p.content, p.pub_date
+func GetMostRecentPostByBoardID(ctx context.Context, db Querier, boardID int) (*Post, error) {
+	_, span := startSpan(ctx, "GetMostRecentPostByBoardID")
+	defer span.End()
+
+	query := `SELECT p.id, p.topic_id, p.author, p.content, p.pub_date, p.visibility
 		FROM posts p
 		JOIN topics t ON p.topic_id = t.id
-		WHERE t.board_id = ?
+		WHERE t.board_id = ? AND p.visibility = 'visible' AND t.visibility = 'visible'
 		ORDER BY p.pub_date DESC
 		LIMIT 1`
 	var post Post
 	err := db.QueryRow(query, boardID).Scan(
-		&post.ID, &post.TopicID, &post.Author, &post.Content, &post.PubDate,
+		&post.ID, &post.TopicID, &post.Author, &post.Content, &post.PubDate, &post.Visibility,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -71,14 +109,17 @@ func GetMostRecentPostByBoardID(db *sql.DB, boardID int) (*Post, error) {
 	return &post, nil
 }
 
-func GetMostRecentPostByTopicID(db *sql.DB, topicID int) (*Post, error) {
-	query := `SELECT id, topic_id, author, content, pub_date
-		FROM posts WHERE topic_id = ?
+func GetMostRecentPostByTopicID(ctx context.Context, db Querier, topicID int) (*Post, error) {
+	_, span := startSpan(ctx, "GetMostRecentPostByTopicID")
+	defer span.End()
+
+	query := `SELECT id, topic_id, author, content, pub_date, visibility
+		FROM posts WHERE topic_id = ? AND visibility = 'visible'
 		ORDER BY pub_date DESC
 		LIMIT 1`
 	var post Post
 	err := db.QueryRow(query, topicID).Scan(
-		&post.ID, &post.TopicID, &post.Author, &post.Content, &post.PubDate,
+		&post.ID, &post.TopicID, &post.Author, &post.Content, &post.PubDate, &post.Visibility,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -89,9 +130,16 @@ func GetMostRecentPostByTopicID(db *sql.DB, topicID int) (*Post, error) {
 	return &post, nil
 }
 
-func GetPostsByTopicIDWithPagination(db *sql.DB, topicID int, limit, offset int) ([]Post, error) {
-	query := `SELECT id, topic_id, author, content, pub_date 
-		FROM posts WHERE topic_id = ? ORDER BY pub_date ASC LIMIT ? OFFSET ?`
+// GetPostsByTopicIDWithPagination lists the posts of a topic. Regular
+// readers should pass visibleOnly so pending and hidden posts are excluded.
+func GetPostsByTopicIDWithPagination(ctx context.Context, db Querier, topicID int, visibleOnly bool, limit, offset int) ([]Post, error) {
+	_, span := startSpan(ctx, "GetPostsByTopicIDWithPagination")
+	defer span.End()
+	span.SetInt("minibb.topic_id", topicID)
+
+	query := `SELECT id, topic_id, author, content, pub_date, visibility
+		FROM posts WHERE topic_id = ? AND ` + visibilityCondition("visibility", visibleOnly) + `
+		ORDER BY pub_date ASC LIMIT ? OFFSET ?`
 	rows, err := db.Query(query, topicID, limit, offset)
 	if err != nil {
 		return nil, err
@@ -102,26 +150,81 @@ func GetPostsByTopicIDWithPagination(db *sql.DB, topicID int, limit, offset int)
 	for rows.Next() {
 		var post Post
 		if err := rows.Scan(
-			&post.ID, &post.TopicID, &post.Author, &post.Content, &post.PubDate,
+			&post.ID, &post.TopicID, &post.Author, &post.Content, &post.PubDate, &post.Visibility,
 		); err != nil {
 			return nil, err
 		}
 		posts = append(posts, post)
 	}
 
+	span.SetInt("db.rows", len(posts))
 	return posts, rows.Err()
 }
 
-func CountPostsByTopicID(db *sql.DB, topicID int) (int, error) {
-	query := `SELECT COUNT(*) FROM posts WHERE topic_id = ?`
+func CountPostsByTopicID(ctx context.Context, db Querier, topicID int, visibleOnly bool) (int, error) {
+	_, span := startSpan(ctx, "CountPostsByTopicID")
+	defer span.End()
+
+	query := `SELECT COUNT(*) FROM posts
+		WHERE topic_id = ? AND ` + visibilityCondition("visibility", visibleOnly)
 	var count int
 	err := db.QueryRow(query, topicID).Scan(&count)
 	return count, err
 }
 
-func CreatePost(db *sql.DB, topicID int, author, content string) (*Post, error) {
-	query := `INSERT INTO posts (topic_id, author, content) VALUES (?, ?, ?)`
-	result, err := db.Exec(query, topicID, author, content)
+// GetPendingPostsWithPagination returns the moderation queue, oldest first.
+func GetPendingPostsWithPagination(ctx context.Context, db Querier, limit, offset int) ([]Post, error) {
+	_, span := startSpan(ctx, "GetPendingPostsWithPagination")
+	defer span.End()
+
+	query := `SELECT id, topic_id, author, content, pub_date, visibility
+		FROM posts WHERE visibility = 'pending' ORDER BY id ASC LIMIT ? OFFSET ?`
+	rows, err := db.Query(query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(
+			&post.ID, &post.TopicID, &post.Author, &post.Content, &post.PubDate, &post.Visibility,
+		); err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+
+	return posts, rows.Err()
+}
+
+func CountPendingPosts(ctx context.Context, db Querier) (int, error) {
+	_, span := startSpan(ctx, "CountPendingPosts")
+	defer span.End()
+
+	query := `SELECT COUNT(*) FROM posts WHERE visibility = 'pending'`
+	var count int
+	err := db.QueryRow(query).Scan(&count)
+	return count, err
+}
+
+// CreatePost adds a reply to a topic. Only visible posts are reflected in
+// the topic's post_count and last_post_id, and only visible posts with bump
+// set move the topic to the top of its board.
+func CreatePost(ctx context.Context, db Querier, topicID int, author, content, ip, visibility string, bump bool) (*Post, error) {
+	ctx, span := startSpan(ctx, "CreatePost")
+	defer span.End()
+
+	tx, err := begin(db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO posts (topic_id, author, content, ip, content_hash, visibility)
+		VALUES (?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(query, topicID, author, content, ip, ContentHash(content), visibility)
 	if err != nil {
 		return nil, err
 	}
@@ -131,5 +234,105 @@ func CreatePost(db *sql.DB, topicID int, author, content string) (*Post, error)
 		return nil, err
 	}
 
-	return GetPostByID(db, int(postID))
+	if visibility == VisibilityVisible {
+		if err := refreshTopicCounters(tx, topicID); err != nil {
+			return nil, err
+		}
+		if bump {
+			bumpQuery := `UPDATE topics SET bumped_at = CURRENT_TIMESTAMP WHERE id = ?`
+			if _, err := tx.Exec(bumpQuery, topicID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetPostByID(ctx, db, int(postID))
+}
+
+// SetPostVisibility changes the visibility of a post. When the post opens
+// its topic, the topic follows along so that approving or rejecting a new
+// topic works through its first post. With bump set, a post made visible
+// moves its topic to the top of its board as if it had just been posted.
+func SetPostVisibility(ctx context.Context, db Querier, id int, visibility string, bump bool) error {
+	_, span := startSpan(ctx, "SetPostVisibility")
+	defer span.End()
+
+	tx, err := begin(db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var topicID, firstPostID int
+	query := `SELECT p.topic_id, (SELECT MIN(id) FROM posts WHERE topic_id = p.topic_id)
+		FROM posts p WHERE p.id = ?`
+	if err := tx.QueryRow(query, id).Scan(&topicID, &firstPostID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE posts SET visibility = ? WHERE id = ?`, visibility, id); err != nil {
+		return err
+	}
+
+	if id == firstPostID {
+		if _, err := tx.Exec(`UPDATE topics SET visibility = ? WHERE id = ?`, visibility, topicID); err != nil {
+			return err
+		}
+	}
+
+	if err := refreshTopicCounters(tx, topicID); err != nil {
+		return err
+	}
+
+	if bump && visibility == VisibilityVisible {
+		bumpQuery := `UPDATE topics SET bumped_at = CURRENT_TIMESTAMP WHERE id = ?`
+		if _, err := tx.Exec(bumpQuery, topicID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func DeletePost(ctx context.Context, db Querier, id int) error {
+	_, span := startSpan(ctx, "DeletePost")
+	defer span.End()
+
+	tx, err := begin(db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var topicID int
+	if err := tx.QueryRow(`SELECT topic_id FROM posts WHERE id = ?`, id).Scan(&topicID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM posts WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	if err := refreshTopicCounters(tx, topicID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CountRecentDuplicatePosts counts posts with the same content hash written
+// from ip since the given time.
+func CountRecentDuplicatePosts(ctx context.Context, db Querier, contentHash, ip string, since time.Time) (int, error) {
+	_, span := startSpan(ctx, "CountRecentDuplicatePosts")
+	defer span.End()
+
+	query := `SELECT COUNT(*) FROM posts
+		WHERE content_hash = ? AND ip = ? AND pub_date >= ?`
+	var count int
+	err := db.QueryRow(query, contentHash, ip, FormatDBTime(since)).Scan(&count)
+	return count, err
 }
@@ -0,0 +1,223 @@
+package models_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"minibb/internal/db"
+	"minibb/internal/models"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	config := db.DefaultConfig()
+	config.Path = filepath.Join(t.TempDir(), "test.db")
+	database, err := db.Init(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+// backdate moves a topic's bump time into the past, so that a bump within
+// the same second can be told apart from no bump.
+func backdate(t *testing.T, database *sql.DB, topicID int) time.Time {
+	t.Helper()
+	past := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	if _, err := database.Exec(`UPDATE topics SET bumped_at = ? WHERE id = ?`, models.FormatDBTime(past), topicID); err != nil {
+		t.Fatal(err)
+	}
+	return past
+}
+
+func getTopic(t *testing.T, database *sql.DB, id int) *models.Topic {
+	t.Helper()
+	topic, err := models.GetTopicByID(context.Background(), database, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic == nil {
+		t.Fatalf("topic %d not found", id)
+	}
+	return topic
+}
+
+func checkCounters(t *testing.T, topic *models.Topic, count int, lastPostID int) {
+	t.Helper()
+	if topic.PostCount != count {
+		t.Errorf("post_count = %d, want %d", topic.PostCount, count)
+	}
+	switch {
+	case lastPostID == 0 && topic.LastPostID != nil:
+		t.Errorf("last_post_id = %d, want none", *topic.LastPostID)
+	case lastPostID != 0 && (topic.LastPostID == nil || *topic.LastPostID != lastPostID):
+		t.Errorf("last_post_id = %v, want %d", topic.LastPostID, lastPostID)
+	}
+}
+
+func TestCreatePostCountsVisibleOnly(t *testing.T) {
+	database := openTestDB(t)
+	ctx := context.Background()
+
+	topic, err := models.CreateTopic(ctx, database, 1, "Hello", "alice", "first", "10.0.0.1", models.VisibilityVisible)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opening := *topic.LastPostID
+	checkCounters(t, topic, 1, opening)
+
+	past := backdate(t, database, topic.ID)
+	if _, err := models.CreatePost(ctx, database, topic.ID, "bob", "held", "10.0.0.2", models.VisibilityPending, true); err != nil {
+		t.Fatal(err)
+	}
+	topic = getTopic(t, database, topic.ID)
+	checkCounters(t, topic, 1, opening)
+	if !topic.BumpedAt.Equal(past) {
+		t.Errorf("pending reply bumped the topic to %v", topic.BumpedAt)
+	}
+
+	sage, err := models.CreatePost(ctx, database, topic.ID, "carol", "sage", "10.0.0.3", models.VisibilityVisible, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic = getTopic(t, database, topic.ID)
+	checkCounters(t, topic, 2, sage.ID)
+	if !topic.BumpedAt.Equal(past) {
+		t.Errorf("reply without bump moved the topic to %v", topic.BumpedAt)
+	}
+
+	reply, err := models.CreatePost(ctx, database, topic.ID, "dave", "reply", "10.0.0.4", models.VisibilityVisible, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic = getTopic(t, database, topic.ID)
+	checkCounters(t, topic, 3, reply.ID)
+	if !topic.BumpedAt.After(past) {
+		t.Error("visible reply did not bump the topic")
+	}
+
+	// The counters follow the visible posts even if they had drifted.
+	if _, err := database.Exec(`UPDATE topics SET post_count = 10 WHERE id = ?`, topic.ID); err != nil {
+		t.Fatal(err)
+	}
+	last, err := models.CreatePost(ctx, database, topic.ID, "erin", "again", "10.0.0.5", models.VisibilityVisible, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCounters(t, getTopic(t, database, topic.ID), 4, last.ID)
+
+	visible, err := models.CountPostsByTopicID(ctx, database, topic.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := models.CountPostsByTopicID(ctx, database, topic.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visible != 4 || all != 5 {
+		t.Errorf("CountPostsByTopicID = %d visible, %d in all; want 4, 5", visible, all)
+	}
+}
+
+func TestSetPostVisibility(t *testing.T) {
+	database := openTestDB(t)
+	ctx := context.Background()
+
+	topic, err := models.CreateTopic(ctx, database, 1, "Hello", "alice", "first", "10.0.0.1", models.VisibilityVisible)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opening := *topic.LastPostID
+	pending, err := models.CreatePost(ctx, database, topic.ID, "bob", "held", "10.0.0.2", models.VisibilityPending, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	past := backdate(t, database, topic.ID)
+	if err := models.SetPostVisibility(ctx, database, pending.ID, models.VisibilityVisible, false); err != nil {
+		t.Fatal(err)
+	}
+	topic = getTopic(t, database, topic.ID)
+	checkCounters(t, topic, 2, pending.ID)
+	if !topic.BumpedAt.Equal(past) {
+		t.Errorf("approval without bump moved the topic to %v", topic.BumpedAt)
+	}
+
+	if err := models.SetPostVisibility(ctx, database, pending.ID, models.VisibilityHidden, true); err != nil {
+		t.Fatal(err)
+	}
+	topic = getTopic(t, database, topic.ID)
+	checkCounters(t, topic, 1, opening)
+	if !topic.BumpedAt.Equal(past) {
+		t.Errorf("hiding a post bumped the topic to %v", topic.BumpedAt)
+	}
+	if topic.Visibility != models.VisibilityVisible {
+		t.Errorf("hiding a reply changed the topic visibility to %q", topic.Visibility)
+	}
+
+	if err := models.SetPostVisibility(ctx, database, pending.ID, models.VisibilityVisible, true); err != nil {
+		t.Fatal(err)
+	}
+	topic = getTopic(t, database, topic.ID)
+	checkCounters(t, topic, 2, pending.ID)
+	if !topic.BumpedAt.After(past) {
+		t.Error("approval with bump did not bump the topic")
+	}
+}
+
+func TestSetPostVisibilityOpeningPost(t *testing.T) {
+	database := openTestDB(t)
+	ctx := context.Background()
+
+	topic, err := models.CreateTopic(ctx, database, 1, "Hello", "alice", "first", "10.0.0.1", models.VisibilityPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCounters(t, topic, 0, 0)
+
+	count := func(visibleOnly bool) int {
+		t.Helper()
+		n, err := models.CountTopicsByBoardID(ctx, database, 1, visibleOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if visible, all := count(true), count(false); visible != 0 || all != 1 {
+		t.Fatalf("CountTopicsByBoardID = %d visible, %d in all; want 0, 1", visible, all)
+	}
+
+	posts, err := models.GetPendingPostsWithPagination(ctx, database, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 || posts[0].TopicID != topic.ID {
+		t.Fatalf("pending posts = %+v, want the opening post", posts)
+	}
+
+	if err := models.SetPostVisibility(ctx, database, posts[0].ID, models.VisibilityVisible, false); err != nil {
+		t.Fatal(err)
+	}
+	topic = getTopic(t, database, topic.ID)
+	if topic.Visibility != models.VisibilityVisible {
+		t.Errorf("approved topic visibility = %q, want visible", topic.Visibility)
+	}
+	checkCounters(t, topic, 1, posts[0].ID)
+	if visible := count(true); visible != 1 {
+		t.Errorf("%d visible topics after approval, want 1", visible)
+	}
+
+	if err := models.SetPostVisibility(ctx, database, posts[0].ID, models.VisibilityHidden, false); err != nil {
+		t.Fatal(err)
+	}
+	if visibility := getTopic(t, database, topic.ID).Visibility; visibility != models.VisibilityHidden {
+		t.Errorf("rejected topic visibility = %q, want hidden", visibility)
+	}
+	if pending, err := models.CountPendingPosts(ctx, database); err != nil || pending != 0 {
+		t.Errorf("CountPendingPosts = %d, %v; want 0", pending, err)
+	}
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Querier is what model functions run their statements on: a *sql.DB, or
+// a *sql.Tx to make them part of the caller's transaction, such as an
+// admin action together with its mod log entry.
+type Querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// modelTx is the transaction of a model function that writes several
+// rows. Given a transaction, the function joins it and leaves committing
+// or rolling back to the caller.
+type modelTx struct {
+	*sql.Tx
+	joined bool
+}
+
+func begin(db Querier) (*modelTx, error) {
+	switch db := db.(type) {
+	case *sql.Tx:
+		return &modelTx{Tx: db, joined: true}, nil
+	case *sql.DB:
+		tx, err := db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		return &modelTx{Tx: tx}, nil
+	}
+	return nil, fmt.Errorf("cannot begin a transaction on %T", db)
+}
+
+func (tx *modelTx) Commit() error {
+	if tx.joined {
+		return nil
+	}
+	return tx.Tx.Commit()
+}
+
+func (tx *modelTx) Rollback() error {
+	if tx.joined {
+		return nil
+	}
+	return tx.Tx.Rollback()
+}
+
+// WithTx runs fn in a transaction on db, committing it if fn succeeds and
+// rolling it back otherwise.
+func WithTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
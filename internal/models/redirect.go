@@ -0,0 +1,97 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+)
+
+// Redirect targets. A redirect points at a record rather than a URL so
+// that it follows the record when it is moved again later.
+const (
+	RedirectBoard = "board"
+	RedirectTopic = "topic"
+	RedirectPost  = "post"
+)
+
+// BoardPath is the SPA route of a board.
+func BoardPath(slug string) string {
+	return "/b/" + slug
+}
+
+// TopicPath is the SPA route of a topic.
+func TopicPath(boardSlug string, topicID int) string {
+	return BoardPath(boardSlug) + "/t/" + strconv.Itoa(topicID)
+}
+
+// ResolveRedirect returns the current route for the first of paths that
+// has a redirect whose target still exists, or "" if there is none.
+func ResolveRedirect(ctx context.Context, db Querier, paths []string) (string, error) {
+	_, span := startSpan(ctx, "ResolveRedirect")
+	defer span.End()
+
+	for _, path := range paths {
+		var targetType string
+		var targetID int
+		err := db.QueryRow(`SELECT target_type, target_id FROM redirects WHERE path = ?`, path).
+			Scan(&targetType, &targetID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		route, err := routeFor(db, targetType, targetID)
+		if err != nil {
+			return "", err
+		}
+		if route != "" {
+			return route, nil
+		}
+	}
+	return "", nil
+}
+
+func routeFor(db Querier, targetType string, targetID int) (string, error) {
+	var slug string
+	var topicID int
+	var err error
+
+	switch targetType {
+	case RedirectBoard:
+		err = db.QueryRow(`SELECT slug FROM boards WHERE id = ?`, targetID).Scan(&slug)
+		if err == nil {
+			return BoardPath(slug), nil
+		}
+	case RedirectTopic:
+		topicID = targetID
+		err = db.QueryRow(`SELECT b.slug FROM topics t JOIN boards b ON b.id = t.board_id
+			WHERE t.id = ?`, targetID).Scan(&slug)
+	case RedirectPost:
+		err = db.QueryRow(`SELECT t.id, b.slug FROM posts p
+			JOIN topics t ON t.id = p.topic_id
+			JOIN boards b ON b.id = t.board_id
+			WHERE p.id = ?`, targetID).Scan(&topicID, &slug)
+	default:
+		return "", nil
+	}
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return TopicPath(slug, topicID), nil
+}
+
+// addRedirect points path at a record, replacing any earlier redirect for
+// the same path.
+func addRedirect(tx Querier, path, targetType string, targetID int) error {
+	_, err := tx.Exec(`INSERT INTO redirects (path, target_type, target_id) VALUES (?, ?, ?)
+		ON CONFLICT (path) DO UPDATE SET target_type = excluded.target_type,
+			target_id = excluded.target_id, created_at = CURRENT_TIMESTAMP`,
+		path, targetType, targetID)
+	return err
+}
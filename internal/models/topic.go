@@ -1,8 +1,11 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"time"
+
+	"minibb/internal/tracing"
 )
 
 type Topic struct {
@@ -14,15 +17,22 @@ type Topic struct {
 	Status     string    `json:"status"`
 	LastPostID *int      `json:"last_post_id"`
 	PostCount  int       `json:"post_count"`
+	Visibility string    `json:"visibility"`
+	BumpedAt   time.Time `json:"bumped_at"`
 }
 
-func GetTopicByID(db *sql.DB, id int) (*Topic, error) {
-	query := `SELECT id, board_id, title, author, pub_date, status, last_post_id, post_count 
+func GetTopicByID(ctx context.Context, db Querier, id int) (*Topic, error) {
+	_, span := startSpan(ctx, "GetTopicByID")
+	defer span.End()
+	span.SetInt("minibb.topic_id", id)
+
+	query := `SELECT id, board_id, title, author, pub_date, status, last_post_id, post_count, visibility, bumped_at
 		FROM topics WHERE id = ?`
 	var topic Topic
 	err := db.QueryRow(query, id).Scan(
 		&topic.ID, &topic.BoardID, &topic.Title, &topic.Author,
-		&topic.PubDate, &topic.Status, &topic.LastPostID, &topic.PostCount,
+		&topic.PubDate, &topic.Status, &topic.LastPostID, &topic.PostCount, &topic.Visibility,
+		&topic.BumpedAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -33,9 +43,12 @@ func GetTopicByID(db *sql.DB, id int) (*Topic, error) {
 	return &topic, nil
 }
 
-func GetTopicsByBoardID(db *sql.DB, boardID int) ([]Topic, error) {
-	query := `SELECT id, board_id, title, author, pub_date, status, last_post_id, post_count 
-		FROM topics WHERE board_id = ? ORDER BY pub_date DESC`
+func GetTopicsByBoardID(ctx context.Context, db Querier, boardID int) ([]Topic, error) {
+	_, span := startSpan(ctx, "GetTopicsByBoardID")
+	defer span.End()
+
+	query := `SELECT id, board_id, title, author, pub_date, status, last_post_id, post_count, visibility, bumped_at
+		FROM topics WHERE board_id = ? ORDER BY bumped_at DESC`
 	rows, err := db.Query(query, boardID)
 	if err != nil {
 		return nil, err
@@ -47,7 +60,8 @@ func GetTopicsByBoardID(db *sql.DB, boardID int) ([]Topic, error) {
 		var topic Topic
 		if err := rows.Scan(
 			&topic.ID, &topic.BoardID, &topic.Title, &topic.Author,
-			&topic.PubDate, &topic.Status, &topic.LastPostID, &topic.PostCount,
+			&topic.PubDate, &topic.Status, &topic.LastPostID, &topic.PostCount, &topic.Visibility,
+			&topic.BumpedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -57,13 +71,18 @@ func GetTopicsByBoardID(db *sql.DB, boardID int) ([]Topic, error) {
 	return topics, rows.Err()
 }
 
-func GetMostRecentTopicByBoardID(db *sql.DB, boardID int) (*Topic, error) {
-	query := `SELECT id, board_id, title, author, pub_date, status, last_post_id, post_count 
-		FROM topics WHERE board_id = ? ORDER BY pub_date DESC LIMIT 1`
+func GetMostRecentTopicByBoardID(ctx context.Context, db Querier, boardID int) (*Topic, error) {
+	_, span := startSpan(ctx, "GetMostRecentTopicByBoardID")
+	defer span.End()
+
+	query := `SELECT id, board_id, title, author, pub_date, status, last_post_id, post_count, visibility, bumped_at
+		FROM topics WHERE board_id = ? AND visibility = 'visible'
+		ORDER BY pub_date DESC LIMIT 1`
 	var topic Topic
 	err := db.QueryRow(query, boardID).Scan(
 		&topic.ID, &topic.BoardID, &topic.Title, &topic.Author,
-		&topic.PubDate, &topic.Status, &topic.LastPostID, &topic.PostCount,
+		&topic.PubDate, &topic.Status, &topic.LastPostID, &topic.PostCount, &topic.Visibility,
+		&topic.BumpedAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -74,9 +93,18 @@ func GetMostRecentTopicByBoardID(db *sql.DB, boardID int) (*Topic, error) {
 	return &topic, nil
 }
 
-func GetTopicsByBoardIDWithPagination(db *sql.DB, boardID int, limit, offset int) ([]Topic, error) {
-	query := `SELECT id, board_id, title, author, pub_date, status, last_post_id, post_count 
-		FROM topics WHERE board_id = ? ORDER BY pub_date DESC LIMIT ? OFFSET ?`
+// GetTopicsByBoardIDWithPagination lists the active topics of a board.
+// Regular readers should pass visibleOnly so pending and hidden topics are
+// excluded. Archived topics are listed by GetArchivedTopicsByBoardIDWithPagination.
+func GetTopicsByBoardIDWithPagination(ctx context.Context, db Querier, boardID int, visibleOnly bool, limit, offset int) ([]Topic, error) {
+	_, span := startSpan(ctx, "GetTopicsByBoardIDWithPagination")
+	defer span.End()
+	span.SetInt("minibb.board_id", boardID)
+
+	query := `SELECT id, board_id, title, author, pub_date, status, last_post_id, post_count, visibility, bumped_at
+		FROM topics WHERE board_id = ? AND status != 'archived'
+		AND ` + visibilityCondition("visibility", visibleOnly) + `
+		ORDER BY bumped_at DESC LIMIT ? OFFSET ?`
 	rows, err := db.Query(query, boardID, limit, offset)
 	if err != nil {
 		return nil, err
@@ -88,61 +116,266 @@ func GetTopicsByBoardIDWithPagination(db *sql.DB, boardID int, limit, offset int
 		var topic Topic
 		if err := rows.Scan(
 			&topic.ID, &topic.BoardID, &topic.Title, &topic.Author,
-			&topic.PubDate, &topic.Status, &topic.LastPostID, &topic.PostCount,
+			&topic.PubDate, &topic.Status, &topic.LastPostID, &topic.PostCount, &topic.Visibility,
+			&topic.BumpedAt,
 		); err != nil {
 			return nil, err
 		}
 		topics = append(topics, topic)
 	}
 
+	span.SetInt("db.rows", len(topics))
 	return topics, rows.Err()
 }
 
-func CountTopicsByBoardID(db *sql.DB, boardID int) (int, error) {
-	query := `SELECT COUNT(*) FROM topics WHERE board_id = ?`
+func CountTopicsByBoardID(ctx context.Context, db Querier, boardID int, visibleOnly bool) (int, error) {
+	_, span := startSpan(ctx, "CountTopicsByBoardID")
+	defer span.End()
+
+	query := `SELECT COUNT(*) FROM topics
+		WHERE board_id = ? AND status != 'archived'
+		AND ` + visibilityCondition("visibility", visibleOnly)
 	var count int
 	err := db.QueryRow(query, boardID).Scan(&count)
 	return count, err
 }
 
-func CreateTopic(db *sql.DB, boardID int, title, author, content string) (*Topic, error) {
-	tx, err := db.Begin()
+func GetArchivedTopicsByBoardIDWithPagination(ctx context.Context, db Querier, boardID int, visibleOnly bool, limit, offset int) ([]Topic, error) {
+	_, span := startSpan(ctx, "GetArchivedTopicsByBoardIDWithPagination")
+	defer span.End()
+
+	query := `SELECT id, board_id, title, author, pub_date, status, last_post_id, post_count, visibility, bumped_at
+		FROM topics WHERE board_id = ? AND status = 'archived'
+		AND ` + visibilityCondition("visibility", visibleOnly) + `
+		ORDER BY archived_at DESC, id DESC LIMIT ? OFFSET ?`
+	rows, err := db.Query(query, boardID, limit, offset)
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback()
+	defer rows.Close()
+
+	var topics []Topic
+	for rows.Next() {
+		var topic Topic
+		if err := rows.Scan(
+			&topic.ID, &topic.BoardID, &topic.Title, &topic.Author,
+			&topic.PubDate, &topic.Status, &topic.LastPostID, &topic.PostCount, &topic.Visibility,
+			&topic.BumpedAt,
+		); err != nil {
+			return nil, err
+		}
+		topics = append(topics, topic)
+	}
+
+	return topics, rows.Err()
+}
 
-	topicQuery := `INSERT INTO topics (board_id, title, author) VALUES (?, ?, ?)`
-	topicResult, err := tx.Exec(topicQuery, boardID, title, author)
+func CountArchivedTopicsByBoardID(ctx context.Context, db Querier, boardID int, visibleOnly bool) (int, error) {
+	_, span := startSpan(ctx, "CountArchivedTopicsByBoardID")
+	defer span.End()
+
+	query := `SELECT COUNT(*) FROM topics
+		WHERE board_id = ? AND status = 'archived'
+		AND ` + visibilityCondition("visibility", visibleOnly)
+	var count int
+	err := db.QueryRow(query, boardID).Scan(&count)
+	return count, err
+}
+
+// CreateTopic creates a topic together with its opening post. Both share
+// the given visibility.
+func CreateTopic(ctx context.Context, db Querier, boardID int, title, author, content, ip, visibility string) (*Topic, error) {
+	ctx, span := startSpan(ctx, "CreateTopic")
+	defer span.End()
+
+	span.SetInt("minibb.board_id", boardID)
+	span.SetString("minibb.visibility", visibility)
+
+	topicID, err := createTopicTx(ctx, db, boardID, title, author, content, ip, visibility)
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
+	span.SetInt("minibb.topic_id", topicID)
 
-	topicID, err := topicResult.LastInsertId()
+	return GetTopicByID(ctx, db, topicID)
+}
+
+func createTopicTx(ctx context.Context, db Querier, boardID int, title, author, content, ip, visibility string) (int, error) {
+	ctx, span := tracing.Start(ctx, "models.CreateTopic.transaction")
+	defer span.End()
+
+	tx, err := begin(db)
 	if err != nil {
-		return nil, err
+		span.RecordError(err)
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	postQuery := `INSERT INTO posts (topic_id, author, content) VALUES (?, ?, ?)`
-	postResult, err := tx.Exec(postQuery, topicID, author, content)
+	topicQuery := `INSERT INTO topics (board_id, title, author, visibility, bumped_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`
+	topicResult, err := tracedExec(ctx, tx, "insert topic", topicQuery, boardID, title, author, visibility)
 	if err != nil {
-		return nil, err
+		span.RecordError(err)
+		return 0, err
 	}
 
-	postID, err := postResult.LastInsertId()
+	topicID, err := topicResult.LastInsertId()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	updateTopicQuery := `UPDATE topics SET last_post_id = ? WHERE id = ?`
-	_, err = tx.Exec(updateTopicQuery, postID, topicID)
+	postQuery := `INSERT INTO posts (topic_id, author, content, ip, content_hash, visibility)
+		VALUES (?, ?, ?, ?, ?, ?)`
+	_, err = tracedExec(ctx, tx, "insert post", postQuery, topicID, author, content, ip, ContentHash(content), visibility)
 	if err != nil {
-		return nil, err
+		span.RecordError(err)
+		return 0, err
+	}
+
+	if err := refreshTopicCounters(tx, int(topicID)); err != nil {
+		span.RecordError(err)
+		return 0, err
 	}
 
 	if err := tx.Commit(); err != nil {
-		return nil, err
+		span.RecordError(err)
+		return 0, err
+	}
+
+	return int(topicID), nil
+}
+
+func SetTopicStatus(ctx context.Context, db Querier, id int, status string) error {
+	_, span := startSpan(ctx, "SetTopicStatus")
+	defer span.End()
+
+	query := `UPDATE topics SET status = ?,
+		archived_at = CASE WHEN ? = 'archived' THEN COALESCE(archived_at, CURRENT_TIMESTAMP) END
+		WHERE id = ?`
+	_, err := db.Exec(query, status, status, id)
+	return err
+}
+
+// ArchiveExcessTopics archives the least recently bumped visible topics of
+// a board so that at most maxActive remain active. It returns the number
+// of archived topics.
+func ArchiveExcessTopics(ctx context.Context, db Querier, boardID int, maxActive int) (int, error) {
+	_, span := startSpan(ctx, "ArchiveExcessTopics")
+	defer span.End()
+
+	query := `UPDATE topics SET status = 'archived', archived_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM topics
+			WHERE board_id = ? AND status != 'archived' AND visibility = 'visible'
+			ORDER BY bumped_at DESC, id DESC
+			LIMIT -1 OFFSET ?
+		)`
+	result, err := db.Exec(query, boardID, maxActive)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
+
+// DeleteArchivedTopicsBefore hard-deletes topics archived before cutoff
+// together with their posts. It returns the number of deleted topics.
+func DeleteArchivedTopicsBefore(ctx context.Context, db Querier, cutoff time.Time) (int, error) {
+	_, span := startSpan(ctx, "DeleteArchivedTopicsBefore")
+	defer span.End()
+
+	tx, err := begin(db)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	expired := `SELECT id FROM topics WHERE status = 'archived' AND archived_at < ?`
+	if _, err := tx.Exec(`DELETE FROM posts WHERE topic_id IN (`+expired+`)`, FormatDBTime(cutoff)); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(`DELETE FROM topics WHERE id IN (`+expired+`)`, FormatDBTime(cutoff))
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(affected), tx.Commit()
+}
+
+// MoveTopic moves a topic to another board and redirects its old route to
+// the new one.
+func MoveTopic(ctx context.Context, db Querier, id int, boardID int) error {
+	_, span := startSpan(ctx, "MoveTopic")
+	defer span.End()
+
+	tx, err := begin(db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldSlug, newSlug string
+	if err := tx.QueryRow(`SELECT b.slug FROM topics t JOIN boards b ON b.id = t.board_id
+		WHERE t.id = ?`, id).Scan(&oldSlug); err != nil {
+		return err
+	}
+	if err := tx.QueryRow(`SELECT slug FROM boards WHERE id = ?`, boardID).Scan(&newSlug); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE topics SET board_id = ? WHERE id = ?`, boardID, id); err != nil {
+		return err
+	}
+
+	if oldSlug != newSlug {
+		if err := addRedirect(tx, TopicPath(oldSlug, id), RedirectTopic, id); err != nil {
+			return err
+		}
+		// Moving a topic back must not leave its route redirecting to itself.
+		if _, err := tx.Exec(`DELETE FROM redirects WHERE path = ?`, TopicPath(newSlug, id)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func DeleteTopic(ctx context.Context, db Querier, id int) error {
+	_, span := startSpan(ctx, "DeleteTopic")
+	defer span.End()
+
+	tx, err := begin(db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM posts WHERE topic_id = ?`, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM topics WHERE id = ?`, id); err != nil {
+		return err
 	}
 
-	return GetTopicByID(db, int(topicID))
+	return tx.Commit()
+}
+
+// refreshTopicCounters recomputes the denormalized post_count and
+// last_post_id of a topic from its visible posts.
+func refreshTopicCounters(tx Querier, topicID int) error {
+	query := `UPDATE topics SET
+		post_count = (SELECT COUNT(*) FROM posts
+			WHERE topic_id = topics.id AND visibility = 'visible'),
+		last_post_id = (SELECT MAX(id) FROM posts
+			WHERE topic_id = topics.id AND visibility = 'visible')
+		WHERE id = ?`
+	_, err := tx.Exec(query, topicID)
+	return err
 }
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// Version marks the state of a board or topic for HTTP caching. The
+// version columns are bumped by triggers on every change, so Tag changes
+// whenever the content can have. Modified has second precision.
+type Version struct {
+	Tag      string
+	Modified time.Time
+}
+
+// GetBoardsVersion returns the version of the board list, which changes
+// with any board.
+func GetBoardsVersion(ctx context.Context, db Querier) (Version, error) {
+	_, span := startSpan(ctx, "GetBoardsVersion")
+	defer span.End()
+
+	rows, err := db.Query(`SELECT id, version, modified_at FROM boards ORDER BY id`)
+	if err != nil {
+		return Version{}, err
+	}
+	defer rows.Close()
+
+	h := fnv.New64a()
+	var version Version
+	for rows.Next() {
+		var id, v int
+		var modified sql.NullTime
+		if err := rows.Scan(&id, &v, &modified); err != nil {
+			return Version{}, err
+		}
+		fmt.Fprintf(h, "%d.%d,", id, v)
+		if modified.Valid && modified.Time.After(version.Modified) {
+			version.Modified = modified.Time
+		}
+	}
+	version.Tag = fmt.Sprintf("boards-%x", h.Sum64())
+	return version, rows.Err()
+}
+
+// GetBoardVersion returns the version of a board's topic lists, or nil if
+// there is no such board.
+func GetBoardVersion(ctx context.Context, db Querier, slug string) (*Version, error) {
+	_, span := startSpan(ctx, "GetBoardVersion")
+	defer span.End()
+	span.SetString("minibb.board", slug)
+
+	var id, v int
+	var modified sql.NullTime
+	err := db.QueryRow(`SELECT id, version, modified_at FROM boards WHERE slug = ?`, slug).
+		Scan(&id, &v, &modified)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Version{Tag: fmt.Sprintf("board-%d-%d", id, v), Modified: modified.Time}, nil
+}
+
+// GetTopicVersion returns the version of a topic's posts, or nil if there
+// is no such topic.
+func GetTopicVersion(ctx context.Context, db Querier, topicID int) (*Version, error) {
+	_, span := startSpan(ctx, "GetTopicVersion")
+	defer span.End()
+	span.SetInt("minibb.topic_id", topicID)
+
+	var v int
+	var modified sql.NullTime
+	err := db.QueryRow(`SELECT version, modified_at FROM topics WHERE id = ?`, topicID).
+		Scan(&v, &modified)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Version{Tag: fmt.Sprintf("topic-%d-%d", topicID, v), Modified: modified.Time}, nil
+}
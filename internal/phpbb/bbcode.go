@@ -0,0 +1,270 @@
+package phpbb
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// phpBB 3.0 stores smilies and magic links as HTML wrapped in comments.
+	smileyPattern    = regexp.MustCompile(`<!-- s(.*?) --><img[^>]*><!-- s.*? -->`)
+	magicLinkPattern = regexp.MustCompile(`<!-- [mlwe] --><a [^>]*href="([^"]*)"[^>]*>.*?</a><!-- [mlwe] -->`)
+
+	// phpBB 3.2+ stores parsed XML that keeps the original markup in
+	// <s> and <e> elements, so dropping the tags recovers the BBCode.
+	xmlLineBreak = regexp.MustCompile(`<br\s*/?>`)
+	xmlTag       = regexp.MustCompile(`</?[A-Za-z][^>]*>`)
+
+	quoteAttribute = regexp.MustCompile(`\s+[a-z_]+=`)
+	bbcodeTag      = regexp.MustCompile(`\[(/?)([A-Za-z]+|\*)(?:=([^\]]*))?\]`)
+	blankLines     = regexp.MustCompile(`\n{3,}`)
+	trailingSpace  = regexp.MustCompile(`[ \t]+\n`)
+)
+
+// knownTags are the BBCode tags that are converted. Anything else is kept
+// as literal text.
+var knownTags = map[string]bool{
+	"b": true, "i": true, "u": true, "s": true, "strike": true,
+	"url": true, "email": true, "img": true, "quote": true, "code": true,
+	"list": true, "*": true, "color": true, "size": true, "font": true,
+	"align": true, "center": true, "left": true, "right": true,
+	"sup": true, "sub": true,
+}
+
+// ToMarkdown converts a phpBB post body to Markdown. uid is the post's
+// bbcode_uid, which phpBB 3.0 appends to every tag.
+func ToMarkdown(text, uid string) string {
+	if strings.HasPrefix(text, "<r>") || strings.HasPrefix(text, "<t>") {
+		text = xmlLineBreak.ReplaceAllString(text, "\n")
+		text = xmlTag.ReplaceAllString(text, "")
+	} else {
+		if uid != "" {
+			text = strings.ReplaceAll(text, ":"+uid+"]", "]")
+		}
+		text = smileyPattern.ReplaceAllString(text, "$1")
+		text = magicLinkPattern.ReplaceAllString(text, "$1")
+	}
+	text = html.UnescapeString(text)
+
+	// Lists carry their type on the closing tag in 3.0 ([/list:u]) and
+	// items may be closed explicitly ([/*:m]); neither matters here.
+	text = strings.NewReplacer("[/list:u]", "[/list]", "[/list:o]", "[/list]",
+		"[/*:m]", "", "[/*]", "").Replace(text)
+
+	root := parseBBCode(text)
+	markdown := root.render()
+
+	markdown = trailingSpace.ReplaceAllString(markdown, "\n")
+	markdown = blankLines.ReplaceAllString(markdown, "\n\n")
+	return strings.TrimSpace(markdown)
+}
+
+type node struct {
+	tag      string
+	arg      string
+	text     string
+	children []*node
+}
+
+// parseBBCode builds a tree of tags. Unknown or unbalanced closing tags
+// are kept as text, and tags left open at the end are closed implicitly.
+func parseBBCode(text string) *node {
+	root := &node{}
+	stack := []*node{root}
+	top := func() *node { return stack[len(stack)-1] }
+	addText := func(s string) {
+		if s != "" {
+			top().children = append(top().children, &node{text: s})
+		}
+	}
+	closeTag := func(name string) bool {
+		for i := len(stack) - 1; i > 0; i-- {
+			if stack[i].tag == name {
+				stack = stack[:i]
+				return true
+			}
+		}
+		return false
+	}
+
+	for len(text) > 0 {
+		loc := bbcodeTag.FindStringSubmatchIndex(text)
+		if loc == nil {
+			addText(text)
+			break
+		}
+		addText(text[:loc[0]])
+		raw := text[loc[0]:loc[1]]
+		closing := loc[3] > loc[2]
+		name := strings.ToLower(text[loc[4]:loc[5]])
+		arg := ""
+		if loc[6] >= 0 {
+			arg = text[loc[6]:loc[7]]
+		}
+		text = text[loc[1]:]
+
+		if !knownTags[name] {
+			addText(raw)
+			continue
+		}
+
+		if closing {
+			if name == "list" {
+				closeTag("*")
+			}
+			if !closeTag(name) {
+				addText(raw)
+			}
+			continue
+		}
+
+		// Code blocks are verbatim up to their closing tag.
+		if name == "code" {
+			end := strings.Index(strings.ToLower(text), "[/code]")
+			if end < 0 {
+				end = len(text)
+			}
+			top().children = append(top().children, &node{tag: "code", text: text[:end]})
+			text = text[min(end+len("[/code]"), len(text)):]
+			continue
+		}
+
+		// A list item ends where the next one starts.
+		if name == "*" && top().tag == "*" {
+			stack = stack[:len(stack)-1]
+		}
+
+		n := &node{tag: name, arg: arg}
+		top().children = append(top().children, n)
+		stack = append(stack, n)
+	}
+
+	return root
+}
+
+func (n *node) renderChildren() string {
+	var sb strings.Builder
+	for _, child := range n.children {
+		sb.WriteString(child.render())
+	}
+	return sb.String()
+}
+
+func (n *node) render() string {
+	inner := n.renderChildren()
+
+	switch n.tag {
+	case "":
+		return n.text + inner
+	case "b":
+		return wrapInline("**", inner)
+	case "i":
+		return wrapInline("*", inner)
+	case "s", "strike":
+		return wrapInline("~~", inner)
+	case "url":
+		if n.arg == "" {
+			return "<" + strings.TrimSpace(inner) + ">"
+		}
+		return "[" + inner + "](" + unquote(n.arg) + ")"
+	case "email":
+		address := n.arg
+		if address == "" {
+			address = inner
+		}
+		return "[" + inner + "](mailto:" + strings.TrimSpace(unquote(address)) + ")"
+	case "img":
+		return "![](" + strings.TrimSpace(inner) + ")"
+	case "code":
+		return "\n\n```\n" + strings.Trim(n.text, "\n") + "\n```\n\n"
+	case "quote":
+		body := strings.TrimSpace(inner)
+		if name := quoteAuthor(n.arg); name != "" {
+			body = "**" + name + " wrote:**\n\n" + body
+		}
+		return "\n\n" + prefixLines(body, "> ") + "\n\n"
+	case "list":
+		return "\n\n" + n.renderList() + "\n\n"
+	case "*":
+		// Items outside a list are rendered as bullets.
+		return "\n- " + strings.TrimSpace(inner) + "\n"
+	}
+
+	// Presentational tags without a Markdown equivalent keep their text.
+	return inner
+}
+
+func (n *node) renderList() string {
+	ordered := n.arg != "" && n.arg != "u" && n.arg != "disc" && n.arg != "circle" && n.arg != "square"
+
+	var lines []string
+	number := 1
+	for _, child := range n.children {
+		if child.tag != "*" {
+			// Text between [list] and the first item is usually whitespace.
+			if text := strings.TrimSpace(child.render()); text != "" {
+				lines = append(lines, text)
+			}
+			continue
+		}
+
+		marker := "- "
+		if ordered {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+		item := strings.TrimSpace(child.renderChildren())
+		indent := strings.Repeat(" ", len(marker))
+		lines = append(lines, marker+strings.ReplaceAll(item, "\n", "\n"+indent))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// wrapInline wraps text in a Markdown emphasis marker, keeping surrounding
+// whitespace outside since Markdown ignores markers next to spaces.
+func wrapInline(marker, text string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	start := strings.Index(text, trimmed)
+	return text[:start] + marker + trimmed + marker + text[start+len(trimmed):]
+}
+
+func prefixLines(text, prefix string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = strings.TrimRight(prefix, " ")
+		} else {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// quoteAuthor extracts the name from a quote argument. phpBB 3.2 adds
+// attributes after it: "name" post_id=1 or name post_id=1.
+func quoteAuthor(arg string) string {
+	arg = strings.TrimSpace(arg)
+	if len(arg) > 0 && (arg[0] == '"' || arg[0] == '\'') {
+		if end := strings.IndexByte(arg[1:], arg[0]); end >= 0 {
+			return arg[1 : end+1]
+		}
+	}
+	if loc := quoteAttribute.FindStringIndex(arg); loc != nil {
+		arg = arg[:loc[0]]
+	}
+	return unquote(arg)
+}
+
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return strings.Trim(s, `"`)
+}
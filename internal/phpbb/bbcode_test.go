@@ -0,0 +1,62 @@
+package phpbb
+
+import "testing"
+
+func TestToMarkdown(t *testing.T) {
+	tests := []struct {
+		name, text, uid, want string
+	}{
+		{"plain", "Hello &amp; welcome", "", "Hello & welcome"},
+		{"emphasis", "[b]bold[/b], [i]italic[/i] and [s]gone[/s]", "", "**bold**, *italic* and ~~gone~~"},
+		{"emphasis spaces", "a[b] bold [/b]word", "", "a **bold** word"},
+		{"uid", "[b:1a2b3c]bold[/b:1a2b3c]", "1a2b3c", "**bold**"},
+		{"case", "[B]bold[/B]", "", "**bold**"},
+		{"url", "[url=http://example.com/]site[/url]", "", "[site](http://example.com/)"},
+		{"bare url", "[url]http://example.com/[/url]", "", "<http://example.com/>"},
+		{"email", "[email]a@example.com[/email]", "", "[a@example.com](mailto:a@example.com)"},
+		{"img", "[img]http://example.com/a.png[/img]", "", "![](http://example.com/a.png)"},
+		{"code", "before[code]x := [b]1[/b]\n[/code]after", "", "before\n\n```\nx := [b]1[/b]\n```\n\nafter"},
+		{"unclosed code", "[code]x", "", "```\nx\n```"},
+		{"quote", "[quote=\"alice\"]hi\nthere[/quote]reply", "", "> **alice wrote:**\n>\n> hi\n> there\n\nreply"},
+		{"quote 3.2", "[quote=bob post_id=3 time=1]hi[/quote]", "", "> **bob wrote:**\n>\n> hi"},
+		{"nested quote", "[quote]outer [quote]inner[/quote][/quote]", "", "> outer\n>\n> > inner"},
+		{"list", "[list][*]one[*]two[/list]", "", "- one\n- two"},
+		{"ordered list", "[list=1][*]one[*]two[/list:o]", "", "1. one\n2. two"},
+		{"closed items", "[list][*]one[/*:m][*]two[/*:m][/list:u]", "", "- one\n- two"},
+		{"multi-line item", "[list=1][*]one\nmore[/list]", "", "1. one\n   more"},
+		{"presentational", "[color=red]red[/color] [size=150]big[/size]", "", "red big"},
+		{"unknown tag", "[spoiler]x[/spoiler]", "", "[spoiler]x[/spoiler]"},
+		{"unbalanced close", "a[/b]c", "", "a[/b]c"},
+		{"unclosed", "[b]bold", "", "**bold**"},
+		{"smiley", `<!-- s:) --><img src="{SMILIES_PATH}/smile.gif" alt=":)" /><!-- s:) -->`, "", ":)"},
+		{"magic link", `<!-- m --><a class="postlink" href="http://example.com/long">http://example.com/...</a><!-- m -->`, "", "http://example.com/long"},
+		{"xml", `<r><B><s>[b]</s>bold<e>[/b]</e></B><br/>next &amp; last</r>`, "", "**bold**\nnext & last"},
+		{"xml text", `<t>just text</t>`, "", "just text"},
+		{"blank lines", "a\n\n\n\nb  \nc", "", "a\n\nb\nc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToMarkdown(tt.text, tt.uid); got != tt.want {
+				t.Errorf("ToMarkdown(%q) =\n%q\nwant\n%q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuoteAuthor(t *testing.T) {
+	tests := []struct {
+		arg, want string
+	}{
+		{"", ""},
+		{"alice", "alice"},
+		{`"alice"`, "alice"},
+		{`"Alice Smith" post_id=3`, "Alice Smith"},
+		{"bob post_id=3 time=1 user_id=2", "bob"},
+		{`'carol'`, "carol"},
+	}
+	for _, tt := range tests {
+		if got := quoteAuthor(tt.arg); got != tt.want {
+			t.Errorf("quoteAuthor(%q) = %q, want %q", tt.arg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// Package phpbb imports a phpBB 3 forum. Forums become boards, topics
+// become topics and posts become posts. The old IDs are recorded in the
+// import_map table and old viewforum.php and viewtopic.php URLs are added
+// to the redirects table.
+package phpbb
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"minibb/internal/models"
+)
+
+// MapSource is the import_map source name used for phpBB imports.
+const MapSource = "phpbb"
+
+// anonymousUserID is phpBB's guest account.
+const anonymousUserID = "1"
+
+const progressInterval = 1000
+
+// Stats counts imported and skipped records.
+type Stats struct {
+	Boards  int `json:"boards"`
+	Topics  int `json:"topics"`
+	Posts   int `json:"posts"`
+	Skipped int `json:"skipped"`
+}
+
+// Options configures an import. BasePath is the URL path phpBB was
+// served under, such as /forum. Progress may be nil.
+type Options struct {
+	Prefix   string
+	BasePath string
+	Progress func(Stats)
+}
+
+type importer struct {
+	tx       *sql.Tx
+	src      Source
+	opts     Options
+	stats    Stats
+	users    map[string]string
+	boards   map[string]int64
+	topics   map[string]int64
+	slugs    map[string]bool
+	reported int
+}
+
+// Import copies a phpBB forum into db in a single transaction. Existing
+// boards are left alone; imported boards get slugs derived from the forum
+// names.
+func Import(db *sql.DB, src Source, opts Options) (Stats, error) {
+	if opts.Prefix == "" {
+		opts.Prefix = "phpbb_"
+	}
+
+	var imported int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM import_map WHERE source = ?`, MapSource).Scan(&imported); err != nil {
+		return Stats{}, err
+	}
+	if imported > 0 {
+		return Stats{}, fmt.Errorf("a phpBB forum has already been imported")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return Stats{}, err
+	}
+	defer tx.Rollback()
+
+	imp := &importer{
+		tx:     tx,
+		src:    src,
+		opts:   opts,
+		users:  make(map[string]string),
+		boards: make(map[string]int64),
+		topics: make(map[string]int64),
+		slugs:  make(map[string]bool),
+	}
+
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"users", imp.loadUsers},
+		{"forums", imp.importForums},
+		{"topics", imp.importTopics},
+		{"posts", imp.importPosts},
+		{"topic counters", imp.finishTopics},
+		{"redirects", imp.addRedirects},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			return imp.stats, fmt.Errorf("failed to import %s: %w", step.name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return imp.stats, err
+	}
+	if opts.Progress != nil {
+		opts.Progress(imp.stats)
+	}
+	return imp.stats, nil
+}
+
+func (imp *importer) table(name string) string {
+	return imp.opts.Prefix + name
+}
+
+func (imp *importer) progress() {
+	total := imp.stats.Boards + imp.stats.Topics + imp.stats.Posts
+	if imp.opts.Progress != nil && total-imp.reported >= progressInterval {
+		imp.reported = total
+		imp.opts.Progress(imp.stats)
+	}
+}
+
+func (imp *importer) mapID(kind, oldID string, newID int64) error {
+	_, err := imp.tx.Exec(`INSERT INTO import_map (source, kind, old_id, new_id) VALUES (?, ?, ?, ?)`,
+		MapSource, kind, oldID, newID)
+	return err
+}
+
+func (imp *importer) loadUsers() error {
+	return imp.src.Rows(imp.table("users"), func(row Row) error {
+		imp.users[row["user_id"]] = html.UnescapeString(row["username"])
+		return nil
+	})
+}
+
+func (imp *importer) importForums() error {
+	rows, err := imp.tx.Query(`SELECT slug FROM boards`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			rows.Close()
+			return err
+		}
+		imp.slugs[slug] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return imp.src.Rows(imp.table("forums"), func(row Row) error {
+		// Categories (0) and links (2) hold no topics.
+		if row["forum_type"] != "1" {
+			return nil
+		}
+
+		name := html.UnescapeString(row["forum_name"])
+		description := ToMarkdown(row["forum_desc"], row["forum_desc_uid"])
+		if description == "" {
+			description = name
+		}
+		slug := imp.uniqueSlug(name, row["forum_id"])
+
+		result, err := imp.tx.Exec(`INSERT INTO boards (slug, description) VALUES (?, ?)`, slug, description)
+		if err != nil {
+			return err
+		}
+		boardID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if _, err := imp.tx.Exec(`INSERT INTO board_settings (board_id) VALUES (?)`, boardID); err != nil {
+			return err
+		}
+		if err := imp.mapID("forum", row["forum_id"], boardID); err != nil {
+			return err
+		}
+
+		imp.boards[row["forum_id"]] = boardID
+		imp.stats.Boards++
+		imp.progress()
+		return nil
+	})
+}
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+func (imp *importer) uniqueSlug(name, forumID string) string {
+	base := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(base) > 32 {
+		base = strings.TrimRight(base[:32], "-")
+	}
+	if base == "" {
+		base = "forum-" + forumID
+	}
+
+	slug := base
+	for n := 2; imp.slugs[slug]; n++ {
+		slug = base + "-" + strconv.Itoa(n)
+	}
+	imp.slugs[slug] = true
+	return slug
+}
+
+func (imp *importer) importTopics() error {
+	return imp.src.Rows(imp.table("topics"), func(row Row) error {
+		boardID, ok := imp.boards[row["forum_id"]]
+		// Moved topics leave a shadow topic pointing at the real one.
+		if !ok || (row["topic_moved_id"] != "" && row["topic_moved_id"] != "0") {
+			imp.stats.Skipped++
+			return nil
+		}
+
+		author := html.UnescapeString(row["topic_first_poster_name"])
+		if author == "" {
+			author = imp.author(row["topic_poster"], "")
+		}
+		status := "open"
+		if row["topic_status"] == "1" {
+			status = "locked"
+		}
+		pubDate := unixTime(row["topic_time"])
+
+		result, err := imp.tx.Exec(`INSERT INTO topics (board_id, title, author, pub_date,
+			status, visibility, bumped_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			boardID, html.UnescapeString(row["topic_title"]), author, pubDate, status,
+			visibility(row, "topic"), pubDate,
+		)
+		if err != nil {
+			return err
+		}
+		topicID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if err := imp.mapID("topic", row["topic_id"], topicID); err != nil {
+			return err
+		}
+
+		imp.topics[row["topic_id"]] = topicID
+		imp.stats.Topics++
+		imp.progress()
+		return nil
+	})
+}
+
+func (imp *importer) importPosts() error {
+	return imp.src.Rows(imp.table("posts"), func(row Row) error {
+		topicID, ok := imp.topics[row["topic_id"]]
+		if !ok {
+			imp.stats.Skipped++
+			return nil
+		}
+
+		content := ToMarkdown(row["post_text"], row["bbcode_uid"])
+		result, err := imp.tx.Exec(`INSERT INTO posts (topic_id, author, content, pub_date,
+			visibility, ip, content_hash) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			topicID, imp.author(row["poster_id"], row["post_username"]), content,
+			unixTime(row["post_time"]), visibility(row, "post"), row["poster_ip"],
+			models.ContentHash(content),
+		)
+		if err != nil {
+			return err
+		}
+		postID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if err := imp.mapID("post", row["post_id"], postID); err != nil {
+			return err
+		}
+
+		imp.stats.Posts++
+		imp.progress()
+		return nil
+	})
+}
+
+// finishTopics fills in the denormalized topic columns from the imported
+// posts and drops topics that ended up without any.
+func (imp *importer) finishTopics() error {
+	imported := `SELECT new_id FROM import_map WHERE source = '` + MapSource + `' AND kind = 'topic'`
+
+	result, err := imp.tx.Exec(`DELETE FROM topics WHERE id IN (` + imported + `)
+		AND NOT EXISTS (SELECT 1 FROM posts WHERE topic_id = topics.id)`)
+	if err != nil {
+		return err
+	}
+	dropped, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	imp.stats.Topics -= int(dropped)
+	imp.stats.Skipped += int(dropped)
+
+	if _, err := imp.tx.Exec(`DELETE FROM import_map WHERE source = ? AND kind = 'topic'
+		AND new_id NOT IN (SELECT id FROM topics)`, MapSource); err != nil {
+		return err
+	}
+
+	// A topic is only as visible as its opening post.
+	_, err = imp.tx.Exec(`UPDATE topics SET
+		visibility = (SELECT visibility FROM posts WHERE topic_id = topics.id ORDER BY id LIMIT 1),
+		post_count = (SELECT COUNT(*) FROM posts
+			WHERE topic_id = topics.id AND visibility = 'visible'),
+		last_post_id = (SELECT MAX(id) FROM posts
+			WHERE topic_id = topics.id AND visibility = 'visible'),
+		bumped_at = COALESCE((SELECT MAX(pub_date) FROM posts
+			WHERE topic_id = topics.id AND visibility = 'visible'), pub_date)
+		WHERE id IN (` + imported + `)`)
+	return err
+}
+
+// addRedirects maps the old phpBB URLs of everything imported. Posts are
+// linked as viewtopic.php?p=ID, so they get redirects of their own.
+func (imp *importer) addRedirects() error {
+	base := strings.TrimRight(imp.opts.BasePath, "/")
+	if base != "" && !strings.HasPrefix(base, "/") {
+		base = "/" + base
+	}
+
+	redirects := []struct {
+		kind, path, targetType string
+	}{
+		{"forum", "/viewforum.php?f=", models.RedirectBoard},
+		{"topic", "/viewtopic.php?t=", models.RedirectTopic},
+		{"post", "/viewtopic.php?p=", models.RedirectPost},
+	}
+	for _, r := range redirects {
+		_, err := imp.tx.Exec(`INSERT OR REPLACE INTO redirects (path, target_type, target_id)
+			SELECT ? || old_id, ?, new_id FROM import_map WHERE source = ? AND kind = ?`,
+			base+r.path, r.targetType, MapSource, r.kind)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// author resolves the name shown for a post. Guests have their name
+// stored on the post itself.
+func (imp *importer) author(userID, postUsername string) string {
+	if userID == anonymousUserID || userID == "" {
+		if postUsername != "" {
+			return html.UnescapeString(postUsername)
+		}
+		return "Anonymous"
+	}
+	if name, ok := imp.users[userID]; ok && name != "" {
+		return name
+	}
+	if postUsername != "" {
+		return html.UnescapeString(postUsername)
+	}
+	return "Anonymous"
+}
+
+// visibility maps phpBB's approval state. phpBB 3.1+ has a visibility
+// column (0 unapproved, 1 approved, 2 deleted, 3 reapprove); 3.0 only has
+// an approved flag.
+func visibility(row Row, kind string) string {
+	if value, ok := row[kind+"_visibility"]; ok {
+		switch value {
+		case "1":
+			return models.VisibilityVisible
+		case "2":
+			return models.VisibilityHidden
+		}
+		return models.VisibilityPending
+	}
+	if value, ok := row[kind+"_approved"]; ok && value == "0" {
+		return models.VisibilityPending
+	}
+	return models.VisibilityVisible
+}
+
+func unixTime(value string) string {
+	seconds, _ := strconv.ParseInt(value, 10, 64)
+	return models.FormatDBTime(time.Unix(seconds, 0))
+}
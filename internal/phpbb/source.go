@@ -0,0 +1,420 @@
+package phpbb
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// Row is a table row keyed by column name. NULL values are empty strings.
+type Row map[string]string
+
+// Source yields the rows of a phpBB table.
+type Source interface {
+	Rows(table string, fn func(Row) error) error
+	Close() error
+}
+
+var tableName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// OpenSource opens a phpBB database, either a SQLite file or a MySQL dump
+// as written by mysqldump.
+func OpenSource(path string) (Source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 16)
+	n, _ := io.ReadFull(f, header)
+	f.Close()
+
+	if bytes.Equal(header[:n], []byte("SQLite format 3\x00")) {
+		db, err := sql.Open("sqlite", path+"?_pragma=query_only(1)")
+		if err != nil {
+			return nil, err
+		}
+		return &sqliteSource{db: db}, nil
+	}
+
+	return &dumpSource{path: path}, nil
+}
+
+type sqliteSource struct {
+	db *sql.DB
+}
+
+func (s *sqliteSource) Rows(table string, fn func(Row) error) error {
+	if !tableName.MatchString(table) {
+		return fmt.Errorf("invalid table name %q", table)
+	}
+
+	rows, err := s.db.Query(`SELECT * FROM ` + table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		row := make(Row, len(columns))
+		for i, column := range columns {
+			row[column] = formatValue(values[i])
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqliteSource) Close() error {
+	return s.db.Close()
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// dumpSource reads tables from a MySQL dump. Every call to Rows scans the
+// whole file, which keeps memory flat for large post tables.
+type dumpSource struct {
+	path string
+}
+
+var (
+	createTablePattern = regexp.MustCompile("(?is)^CREATE TABLE (?:IF NOT EXISTS )?`?([A-Za-z0-9_]+)`?\\s*\\(")
+	insertPattern      = regexp.MustCompile("(?is)^INSERT (?:IGNORE )?INTO `?([A-Za-z0-9_]+)`?\\s*(\\([^)]*\\))?\\s*VALUES\\s*")
+)
+
+func (s *dumpSource) Rows(table string, fn func(Row) error) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var columns []string
+	found := false
+	reader := newStatementReader(f)
+	for {
+		statement, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if m := createTablePattern.FindStringSubmatch(statement); m != nil {
+			if m[1] == table {
+				columns = parseColumnDefinitions(statement)
+				found = true
+			}
+			continue
+		}
+
+		m := insertPattern.FindStringSubmatchIndex(statement)
+		if m == nil || statement[m[2]:m[3]] != table {
+			continue
+		}
+		found = true
+
+		insertColumns := columns
+		if m[4] >= 0 {
+			insertColumns = parseColumnList(statement[m[4]:m[5]])
+		}
+		if len(insertColumns) == 0 {
+			return fmt.Errorf("no column names for table %s", table)
+		}
+
+		tuples, err := parseTuples(statement[m[1]:])
+		if err != nil {
+			return fmt.Errorf("table %s: %w", table, err)
+		}
+		for _, tuple := range tuples {
+			if len(tuple) != len(insertColumns) {
+				return fmt.Errorf("table %s: expected %d values, found %d", table, len(insertColumns), len(tuple))
+			}
+			row := make(Row, len(tuple))
+			for i, column := range insertColumns {
+				row[column] = tuple[i]
+			}
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("table %s not found in dump", table)
+	}
+	return nil
+}
+
+func (s *dumpSource) Close() error {
+	return nil
+}
+
+// parseColumnDefinitions returns the column names of a CREATE TABLE
+// statement in order. Column lines start with a backquoted name; index
+// definitions don't.
+func parseColumnDefinitions(statement string) []string {
+	body := statement[strings.Index(statement, "(")+1:]
+	var columns []string
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "`") {
+			continue
+		}
+		if end := strings.Index(line[1:], "`"); end >= 0 {
+			columns = append(columns, line[1:end+1])
+		}
+	}
+	return columns
+}
+
+func parseColumnList(list string) []string {
+	list = strings.Trim(list, "()")
+	var columns []string
+	for _, column := range strings.Split(list, ",") {
+		columns = append(columns, strings.Trim(strings.TrimSpace(column), "`"))
+	}
+	return columns
+}
+
+// parseTuples parses the value list of an extended INSERT:
+// (1,'a',NULL),(2,'b',NULL)
+func parseTuples(s string) ([][]string, error) {
+	var tuples [][]string
+	i := 0
+	for {
+		for i < len(s) && (s[i] == ',' || isSpace(s[i])) {
+			i++
+		}
+		if i >= len(s) {
+			return tuples, nil
+		}
+		if s[i] != '(' {
+			return nil, fmt.Errorf("expected ( at offset %d", i)
+		}
+		i++
+
+		var tuple []string
+		for {
+			for i < len(s) && isSpace(s[i]) {
+				i++
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated value list")
+			}
+
+			var value string
+			if s[i] == '\'' {
+				var err error
+				value, i, err = parseString(s, i)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				start := i
+				for i < len(s) && s[i] != ',' && s[i] != ')' {
+					i++
+				}
+				value = strings.TrimSpace(s[start:i])
+				if strings.EqualFold(value, "NULL") {
+					value = ""
+				}
+			}
+			tuple = append(tuple, value)
+
+			for i < len(s) && isSpace(s[i]) {
+				i++
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated value list")
+			}
+			if s[i] == ')' {
+				i++
+				break
+			}
+			if s[i] != ',' {
+				return nil, fmt.Errorf("unexpected %q at offset %d", s[i], i)
+			}
+			i++
+		}
+		tuples = append(tuples, tuple)
+	}
+}
+
+// parseString parses a single-quoted MySQL string literal starting at
+// s[i] and returns its value and the offset after the closing quote.
+func parseString(s string, i int) (string, int, error) {
+	var sb strings.Builder
+	i++
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case '0':
+				sb.WriteByte(0)
+			case 'b':
+				sb.WriteByte('\b')
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'Z':
+				sb.WriteByte(0x1a)
+			case '%', '_':
+				sb.WriteByte('\\')
+				sb.WriteByte(s[i])
+			default:
+				sb.WriteByte(s[i])
+			}
+		case c == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			sb.WriteByte('\'')
+			i++
+		case c == '\'':
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteByte(c)
+		}
+		i++
+	}
+	return "", i, fmt.Errorf("unterminated string")
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t'
+}
+
+// statementReader splits a dump into statements at semicolons outside of
+// quotes, skipping comments.
+type statementReader struct {
+	r *bufio.Reader
+}
+
+func newStatementReader(r io.Reader) *statementReader {
+	return &statementReader{r: bufio.NewReaderSize(r, 1<<20)}
+}
+
+func (sr *statementReader) next() (string, error) {
+	var sb strings.Builder
+	var quote byte
+	atLineStart := true
+
+	for {
+		c, err := sr.r.ReadByte()
+		if err == io.EOF {
+			if statement := strings.TrimSpace(sb.String()); statement != "" {
+				return statement, nil
+			}
+			return "", io.EOF
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if quote != 0 {
+			sb.WriteByte(c)
+			switch c {
+			case '\\':
+				escaped, err := sr.r.ReadByte()
+				if err != nil {
+					return "", fmt.Errorf("unterminated string in dump")
+				}
+				sb.WriteByte(escaped)
+			case quote:
+				quote = 0
+			}
+			continue
+		}
+
+		// Skip "-- " and "#" line comments.
+		if atLineStart && (c == '#' || (c == '-' && (sr.peekIs("- ") || sr.peekIs("-\n") || sr.peekIs("-\r")))) {
+			if _, err := sr.r.ReadString('\n'); err != nil && err != io.EOF {
+				return "", err
+			}
+			continue
+		}
+		atLineStart = c == '\n'
+
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+		case '/':
+			// Skip /* */ and /*! */ comments; the latter only carry
+			// MySQL session settings in dumps.
+			if sr.peekIs("*") {
+				if err := sr.skipBlockComment(); err != nil {
+					return "", err
+				}
+				continue
+			}
+		case ';':
+			if statement := strings.TrimSpace(sb.String()); statement != "" {
+				return statement, nil
+			}
+			sb.Reset()
+			continue
+		}
+		sb.WriteByte(c)
+	}
+}
+
+func (sr *statementReader) peekIs(s string) bool {
+	next, err := sr.r.Peek(len(s))
+	return err == nil && string(next) == s
+}
+
+func (sr *statementReader) skipBlockComment() error {
+	sr.r.ReadByte()
+	var prev byte
+	for {
+		c, err := sr.r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("unterminated comment in dump")
+		}
+		if prev == '*' && c == '/' {
+			return nil
+		}
+		prev = c
+	}
+}
@@ -0,0 +1,242 @@
+// Package pow implements a hashcash-style proof-of-work scheme for posting.
+//
+// A challenge is a self-contained, HMAC-signed token naming the board, the
+// difficulty and an expiry. A solution is a nonce such that the SHA-256 of
+// "<challenge>:<nonce>" starts with at least difficulty zero bits. Verifying
+// a solution needs nothing but the secret, so it works without a database.
+package pow
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMalformed   = errors.New("malformed challenge")
+	ErrSignature   = errors.New("invalid challenge signature")
+	ErrExpired     = errors.New("challenge expired")
+	ErrWrongBoard  = errors.New("challenge was issued for another board")
+	ErrDifficulty  = errors.New("challenge difficulty too low")
+	ErrInvalidWork = errors.New("nonce does not solve the challenge")
+	ErrReplayed    = errors.New("challenge was already used")
+)
+
+const DefaultTTL = 10 * time.Minute
+
+// Request headers that carry a solved challenge.
+const (
+	ChallengeHeader = "X-PoW-Challenge"
+	NonceHeader     = "X-PoW-Nonce"
+)
+
+type Challenge struct {
+	Token      string    `json:"challenge"`
+	Board      string    `json:"board"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type Verifier struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	nextSweep time.Time
+}
+
+func NewVerifier(secret []byte, ttl time.Duration) *Verifier {
+	return &Verifier{
+		secret: secret,
+		ttl:    ttl,
+		now:    time.Now,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// ConfigureVerifier creates a verifier keyed by the configured secret.
+// Without one a random secret is generated, which invalidates outstanding
+// challenges on restart.
+func ConfigureVerifier(configured string, ttl time.Duration) (*Verifier, error) {
+	secret := []byte(configured)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return NewVerifier(secret, ttl), nil
+}
+
+// WithClock replaces the verifier's time source.
+func (v *Verifier) WithClock(now func() time.Time) *Verifier {
+	v.now = now
+	return v
+}
+
+func (v *Verifier) Issue(board string, difficulty int) (*Challenge, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	expiresAt := v.now().Add(v.ttl).Truncate(time.Second)
+	payload := strings.Join([]string{
+		"v1",
+		board,
+		strconv.Itoa(difficulty),
+		strconv.FormatInt(expiresAt.Unix(), 10),
+		hex.EncodeToString(salt),
+	}, "|")
+
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return &Challenge{
+		Token:      encoded + "." + v.sign(encoded),
+		Board:      board,
+		Difficulty: difficulty,
+		ExpiresAt:  expiresAt,
+	}, nil
+}
+
+func (v *Verifier) sign(encoded string) string {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Parse validates the signature and expiry of a challenge token.
+func (v *Verifier) Parse(token string) (*Challenge, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrMalformed
+	}
+	if !hmac.Equal([]byte(signature), []byte(v.sign(encoded))) {
+		return nil, ErrSignature
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 5 || parts[0] != "v1" {
+		return nil, ErrMalformed
+	}
+
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	expiresUnix, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	challenge := &Challenge{
+		Token:      token,
+		Board:      parts[1],
+		Difficulty: difficulty,
+		ExpiresAt:  time.Unix(expiresUnix, 0),
+	}
+	if !v.now().Before(challenge.ExpiresAt) {
+		return nil, ErrExpired
+	}
+	return challenge, nil
+}
+
+// Verify checks that nonce solves token for the given board at no less
+// than minDifficulty and marks the challenge as used.
+func (v *Verifier) Verify(token, nonce, board string, minDifficulty int) error {
+	challenge, err := v.Parse(token)
+	if err != nil {
+		return err
+	}
+	if challenge.Board != board {
+		return ErrWrongBoard
+	}
+	if challenge.Difficulty < minDifficulty {
+		return ErrDifficulty
+	}
+	if !Solves(token, nonce, challenge.Difficulty) {
+		return ErrInvalidWork
+	}
+	return v.markSeen(token, challenge.ExpiresAt)
+}
+
+// markSeen records token as used until it expires. Expired tokens are swept
+// at most once per TTL, so a used challenge is remembered for no more than
+// twice its lifetime and most calls only do the lookup and insert.
+func (v *Verifier) markSeen(token string, expiresAt time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if now := v.now(); !now.Before(v.nextSweep) {
+		for seenToken, seenExpiry := range v.seen {
+			if !now.Before(seenExpiry) {
+				delete(v.seen, seenToken)
+			}
+		}
+		v.nextSweep = now.Add(v.ttl)
+	}
+
+	if _, ok := v.seen[token]; ok {
+		return ErrReplayed
+	}
+	v.seen[token] = expiresAt
+	return nil
+}
+
+// Solves reports whether nonce is a valid solution for token.
+func Solves(token, nonce string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(token + ":" + nonce))
+	return leadingZeroBits(sum[:]) >= difficulty
+}
+
+// Solve brute-forces a nonce for token. It is meant for clients and tools.
+func Solve(token string, difficulty int) string {
+	for i := uint64(0); ; i++ {
+		nonce := fmt.Sprintf("%x", i)
+		if Solves(token, nonce, difficulty) {
+			return nonce
+		}
+	}
+}
+
+func leadingZeroBits(sum []byte) int {
+	count := 0
+	for _, b := range sum {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
+
+type contextKey string
+
+const verifierContextKey contextKey = "pow"
+
+func WithVerifier(ctx context.Context, v *Verifier) context.Context {
+	return context.WithValue(ctx, verifierContextKey, v)
+}
+
+func FromContext(ctx context.Context) *Verifier {
+	v, ok := ctx.Value(verifierContextKey).(*Verifier)
+	if !ok {
+		panic("pow verifier not found in context")
+	}
+	return v
+}
@@ -0,0 +1,160 @@
+package pow
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const difficulty = 8
+
+// clock is a time source the tests move forward by hand.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newTestVerifier() (*Verifier, *clock) {
+	c := &clock{t: time.Unix(1_700_000_000, 0)}
+	return NewVerifier([]byte("secret"), time.Minute).WithClock(c.now), c
+}
+
+func TestVerify(t *testing.T) {
+	v, _ := newTestVerifier()
+	challenge, err := v.Issue("general", difficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := Solve(challenge.Token, difficulty)
+
+	if err := v.Verify(challenge.Token, nonce, "general", difficulty); err != nil {
+		t.Fatalf("valid solution rejected: %v", err)
+	}
+	if err := v.Verify(challenge.Token, nonce, "general", difficulty); !errors.Is(err, ErrReplayed) {
+		t.Errorf("second use: got %v, want %v", err, ErrReplayed)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	v, c := newTestVerifier()
+	challenge, err := v.Issue("general", difficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := challenge.Token
+	nonce := Solve(token, difficulty)
+
+	wrongNonce := "x"
+	for Solves(token, wrongNonce, difficulty) {
+		wrongNonce += "x"
+	}
+	encoded, signature, _ := strings.Cut(token, ".")
+	other, err := NewVerifier([]byte("other secret"), time.Minute).Issue("general", difficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		token, nonce  string
+		board         string
+		minDifficulty int
+		want          error
+	}{
+		{"no signature", encoded, nonce, "general", difficulty, ErrMalformed},
+		{"tampered payload", encoded + "A." + signature, nonce, "general", difficulty, ErrSignature},
+		{"other secret", other.Token, Solve(other.Token, difficulty), "general", difficulty, ErrSignature},
+		{"wrong board", token, nonce, "random", difficulty, ErrWrongBoard},
+		{"too easy", token, nonce, "general", difficulty + 1, ErrDifficulty},
+		{"wrong nonce", token, wrongNonce, "general", difficulty, ErrInvalidWork},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := v.Verify(tt.token, tt.nonce, tt.board, tt.minDifficulty); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// None of the failures used up the challenge, but expiry does
+	c.t = challenge.ExpiresAt
+	if err := v.Verify(token, nonce, "general", difficulty); !errors.Is(err, ErrExpired) {
+		t.Errorf("expired: got %v, want %v", err, ErrExpired)
+	}
+}
+
+func TestParse(t *testing.T) {
+	v, _ := newTestVerifier()
+	challenge, err := v.Issue("general", difficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := v.Parse(challenge.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Board != "general" || parsed.Difficulty != difficulty || !parsed.ExpiresAt.Equal(challenge.ExpiresAt) {
+		t.Errorf("parsed %+v, issued %+v", parsed, challenge)
+	}
+
+	// A well-signed token whose payload is not a challenge
+	encoded := "bm90IGEgY2hhbGxlbmdl"
+	if _, err := v.Parse(encoded + "." + v.sign(encoded)); !errors.Is(err, ErrMalformed) {
+		t.Errorf("got %v, want %v", err, ErrMalformed)
+	}
+}
+
+// TestSeenPruned checks that used challenges are forgotten once they have
+// expired, as they can't be replayed anyway, but only by the periodic sweep.
+func TestSeenPruned(t *testing.T) {
+	v, c := newTestVerifier()
+	use := func() {
+		t.Helper()
+		challenge, err := v.Issue("general", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Verify(challenge.Token, "", "general", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first use sweeps the empty map, so the next sweep is due a TTL
+	// later, when these three have just expired.
+	for i := 0; i < 3; i++ {
+		use()
+	}
+	if len(v.seen) != 3 {
+		t.Fatalf("%d challenges remembered, want 3", len(v.seen))
+	}
+
+	c.t = c.t.Add(30 * time.Second)
+	use()
+	if len(v.seen) != 4 {
+		t.Fatalf("%d challenges remembered before the sweep, want 4", len(v.seen))
+	}
+
+	c.t = c.t.Add(45 * time.Second)
+	use()
+	if len(v.seen) != 2 {
+		t.Errorf("%d challenges remembered after the sweep, want 2", len(v.seen))
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	tests := []struct {
+		sum  []byte
+		want int
+	}{
+		{[]byte{0x80}, 0},
+		{[]byte{0x01}, 7},
+		{[]byte{0x00, 0x40}, 9},
+		{[]byte{0x00, 0x00}, 16},
+	}
+	for _, tt := range tests {
+		if got := leadingZeroBits(tt.sum); got != tt.want {
+			t.Errorf("leadingZeroBits(%x) = %d, want %d", tt.sum, got, tt.want)
+		}
+	}
+}
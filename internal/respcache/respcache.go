@@ -0,0 +1,323 @@
+// Package respcache keeps rendered read responses in memory so that bursts
+// of requests for the same page don't each query the database. A response
+// is dropped as soon as a write changes what it shows, as published on the
+// event hub, when the cache is full and it is the least recently used, or
+// when it reaches the maximum age.
+package respcache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"minibb/internal/auth"
+	"minibb/internal/events"
+	"minibb/internal/metrics"
+	"minibb/internal/utils"
+)
+
+type Cache struct {
+	maxEntries int
+	maxAge     time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *entry, most recently used first
+	// deps maps each dependency to the keys of the entries that have it.
+	deps map[string]map[string]bool
+	// generation counts invalidations so that a response rendered before
+	// a write is not stored after it.
+	generation uint64
+	flights    map[string]*flight
+}
+
+type entry struct {
+	key     string
+	deps    []string
+	status  int
+	header  http.Header
+	body    []byte
+	created time.Time
+}
+
+// flight is a response being rendered, which concurrent requests for the
+// same page wait for instead of rendering it again.
+type flight struct {
+	done  chan struct{}
+	entry *entry
+}
+
+// New creates a cache of at most maxEntries responses, each served for at
+// most maxAge. Writes made by other processes, such as the command-line
+// tools, only show once the responses they affect have expired.
+func New(maxEntries int, maxAge time.Duration) *Cache {
+	c := &Cache{
+		maxEntries: maxEntries,
+		maxAge:     maxAge,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		deps:       make(map[string]map[string]bool),
+		flights:    make(map[string]*flight),
+	}
+	metrics.ResponseCacheEntries.Set(func() float64 { return float64(c.Len()) })
+	return c
+}
+
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// allBoards is the dependency of responses that show every board.
+const allBoards = "board:*"
+
+func boardDep(id int) string { return "board:" + strconv.Itoa(id) }
+func topicDep(id int) string { return "topic:" + strconv.Itoa(id) }
+
+type contextKey string
+
+const depsContextKey contextKey = "respcache"
+
+// DependOnBoard declares that the response being rendered for the request
+// of ctx shows the board, or every board if boardID is 0. Responses that
+// declare no dependencies are not cached.
+func DependOnBoard(ctx context.Context, boardID int) {
+	if boardID == 0 {
+		depend(ctx, allBoards)
+	} else {
+		depend(ctx, boardDep(boardID))
+	}
+}
+
+// DependOnTopic declares that the response being rendered for the request
+// of ctx shows the topic.
+func DependOnTopic(ctx context.Context, topicID int) {
+	depend(ctx, topicDep(topicID))
+}
+
+func depend(ctx context.Context, dep string) {
+	if deps, ok := ctx.Value(depsContextKey).(*[]string); ok {
+		*deps = append(*deps, dep)
+	}
+}
+
+// Invalidate drops the responses that e may have changed: those showing
+// its topic, its board or every board. An event without a board drops
+// everything.
+func (c *Cache) Invalidate(e events.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	// Requests from now on must not wait for a response rendered from the
+	// data before the write
+	c.flights = make(map[string]*flight)
+
+	if e.BoardID == 0 {
+		metrics.ResponseCacheInvalidations.Add(float64(c.lru.Len()))
+		c.entries = make(map[string]*list.Element)
+		c.lru.Init()
+		c.deps = make(map[string]map[string]bool)
+		return
+	}
+
+	deps := []string{allBoards, boardDep(e.BoardID)}
+	if e.TopicID != 0 {
+		deps = append(deps, topicDep(e.TopicID))
+	}
+	for _, dep := range deps {
+		for key := range c.deps[dep] {
+			c.remove(c.entries[key])
+			metrics.ResponseCacheInvalidations.Inc()
+		}
+	}
+}
+
+// Handler serves GET requests from the cache, rendering them with next
+// and storing the response on a miss. Concurrent misses for the same page
+// are rendered once. Admins see hidden content and bypass the cache.
+func (c *Cache) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || auth.IsAdmin(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := r.URL.Path + "?" + r.URL.Query().Encode()
+		if e := c.get(key); e != nil {
+			metrics.ResponseCacheHits.Inc()
+			e.serve(w, r)
+			return
+		}
+		metrics.ResponseCacheMisses.Inc()
+
+		e, shared := c.render(key, w, r, next)
+		if shared && r.Context().Err() != nil {
+			return
+		}
+		if shared && (e == nil || e.status != http.StatusOK) {
+			// The request that rendered it may have failed for its own
+			// reasons, such as its client going away
+			next.ServeHTTP(w, r)
+			return
+		}
+		e.serve(w, r)
+	})
+}
+
+func (c *Cache) get(key string) *entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := elem.Value.(*entry)
+	if time.Since(e.created) > c.maxAge {
+		c.remove(elem)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return e
+}
+
+// render renders the response for key with next, or waits for the request
+// already rendering it; shared reports the latter. Successful responses
+// with dependencies are stored unless a write happened meanwhile.
+func (c *Cache) render(key string, w http.ResponseWriter, r *http.Request, next http.Handler) (e *entry, shared bool) {
+	c.mu.Lock()
+	if f, ok := c.flights[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.entry, true
+		case <-r.Context().Done():
+			// The client went away or the request timed out while waiting
+			return nil, true
+		}
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	generation := c.generation
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		if c.flights[key] == f {
+			delete(c.flights, key)
+		}
+		if f.entry != nil && f.entry.status == http.StatusOK && len(f.entry.deps) > 0 && c.generation == generation {
+			c.add(f.entry)
+		}
+		c.mu.Unlock()
+		close(f.done)
+	}()
+
+	// The response is shared by requests with different validators, so
+	// they are checked when it is served rather than by next
+	var deps []string
+	req := r.Clone(context.WithValue(r.Context(), depsContextKey, &deps))
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+
+	// Error responses include the request ID from the response header,
+	// which belongs to this request only
+	rec := &recorder{header: make(http.Header)}
+	rec.header.Set(utils.RequestIDHeader, w.Header().Get(utils.RequestIDHeader))
+	next.ServeHTTP(rec, req)
+	rec.header.Del(utils.RequestIDHeader)
+
+	f.entry = &entry{
+		key:     key,
+		deps:    deps,
+		status:  rec.status,
+		header:  rec.header,
+		body:    rec.body.Bytes(),
+		created: time.Now(),
+	}
+	if f.entry.status == 0 {
+		f.entry.status = http.StatusOK
+	}
+	return f.entry, false
+}
+
+func (c *Cache) add(e *entry) {
+	if elem, ok := c.entries[e.key]; ok {
+		c.remove(elem)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for _, dep := range e.deps {
+		if c.deps[dep] == nil {
+			c.deps[dep] = make(map[string]bool)
+		}
+		c.deps[dep][e.key] = true
+	}
+
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+		metrics.ResponseCacheEvictions.Inc()
+	}
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*entry)
+	delete(c.entries, e.key)
+	for _, dep := range e.deps {
+		delete(c.deps[dep], e.key)
+		if len(c.deps[dep]) == 0 {
+			delete(c.deps, dep)
+		}
+	}
+}
+
+// serve writes the stored response, or 304 Not Modified if the client's
+// copy is current.
+func (e *entry) serve(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	for name, values := range e.header {
+		if name == "Vary" {
+			h[name] = append(h[name], values...)
+		} else {
+			h[name] = append([]string(nil), values...)
+		}
+	}
+
+	if e.status == http.StatusOK {
+		modified, _ := http.ParseTime(e.header.Get("Last-Modified"))
+		if utils.NotModified(r, e.header.Get("ETag"), modified) {
+			h.Del("Content-Type")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// recorder captures a response for the cache.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
@@ -0,0 +1,330 @@
+package respcache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"minibb/internal/auth"
+	"minibb/internal/events"
+)
+
+// topicPages renders /topics/{id} as a page depending on topic id of board
+// 1, counting how often it renders.
+type topicPages struct {
+	renders atomic.Int32
+	status  int
+	// release, when set, holds each render until it is closed; started
+	// is signalled as a render begins.
+	release chan struct{}
+	started chan struct{}
+}
+
+func (p *topicPages) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := p.renders.Add(1)
+	if p.started != nil {
+		p.started <- struct{}{}
+	}
+	if p.release != nil {
+		<-p.release
+	}
+	id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/topics/"))
+	if id != 0 {
+		DependOnTopic(r.Context(), id)
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, n))
+	if p.status != 0 {
+		w.WriteHeader(p.status)
+	}
+	fmt.Fprintf(w, "render %d", n)
+}
+
+func get(t *testing.T, h http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestCacheHit(t *testing.T) {
+	pages := &topicPages{}
+	h := New(10, time.Minute).Handler(pages)
+
+	first := get(t, h, "/topics/1")
+	second := get(t, h, "/topics/1")
+	if pages.renders.Load() != 1 {
+		t.Errorf("rendered %d times, want 1", pages.renders.Load())
+	}
+	if first.Body.String() != second.Body.String() || first.Header().Get("ETag") != second.Header().Get("ETag") {
+		t.Errorf("cached response differs: %q, %q", first.Body, second.Body)
+	}
+
+	// Validators are checked against the stored response
+	req := httptest.NewRequest(http.MethodGet, "/topics/1", nil)
+	req.Header.Set("If-None-Match", first.Header().Get("ETag"))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("conditional request: status %d, want 304", w.Code)
+	}
+
+	// Query parameters are part of the key
+	get(t, h, "/topics/1?page=2")
+	if pages.renders.Load() != 2 {
+		t.Errorf("rendered %d times, want 2", pages.renders.Load())
+	}
+}
+
+func TestNotCached(t *testing.T) {
+	pages := &topicPages{}
+	h := New(10, time.Minute).Handler(pages)
+	get(t, h, "/topics/0") // declares no dependencies
+	get(t, h, "/topics/0")
+	if pages.renders.Load() != 2 {
+		t.Errorf("response without dependencies: rendered %d times, want 2", pages.renders.Load())
+	}
+
+	pages = &topicPages{status: http.StatusNotFound}
+	h = New(10, time.Minute).Handler(pages)
+	get(t, h, "/topics/1")
+	get(t, h, "/topics/1")
+	if pages.renders.Load() != 2 {
+		t.Errorf("error response: rendered %d times, want 2", pages.renders.Load())
+	}
+
+	pages = &topicPages{}
+	h = New(10, time.Minute).Handler(pages)
+	req := httptest.NewRequest(http.MethodGet, "/topics/1", nil)
+	req = req.WithContext(auth.WithAdmin(req.Context(), &auth.Admin{}))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	get(t, h, "/topics/1")
+	if pages.renders.Load() != 2 {
+		t.Errorf("admin request: rendered %d times, want 2", pages.renders.Load())
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		event events.Event
+		// dropped lists the pages the event drops; /boards shows every
+		// board and /boards/1 the first
+		dropped []string
+	}{
+		{"topic", events.Event{BoardID: 1, TopicID: 1}, []string{"/topics/1", "/boards", "/boards/1"}},
+		{"board", events.Event{BoardID: 1}, []string{"/boards", "/boards/1"}},
+		{"other board", events.Event{BoardID: 2}, []string{"/boards"}},
+		{"everything", events.Event{}, []string{"/topics/1", "/topics/2", "/boards", "/boards/1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renders := make(map[string]int)
+			c := New(10, time.Minute)
+			h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				renders[r.URL.Path]++
+				switch r.URL.Path {
+				case "/boards":
+					DependOnBoard(r.Context(), 0)
+				case "/boards/1":
+					DependOnBoard(r.Context(), 1)
+				default:
+					id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/topics/"))
+					DependOnTopic(r.Context(), id)
+				}
+			}))
+
+			pages := []string{"/topics/1", "/topics/2", "/boards", "/boards/1"}
+			for _, page := range pages {
+				get(t, h, page)
+			}
+			c.Invalidate(tt.event)
+			for _, page := range pages {
+				get(t, h, page)
+			}
+
+			dropped := make(map[string]bool)
+			for _, page := range tt.dropped {
+				dropped[page] = true
+			}
+			for _, page := range pages {
+				want := 1
+				if dropped[page] {
+					want = 2
+				}
+				if renders[page] != want {
+					t.Errorf("%s rendered %d times, want %d", page, renders[page], want)
+				}
+			}
+			if got, want := c.Len(), len(pages); got != want {
+				t.Errorf("%d entries, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	pages := &topicPages{}
+	h := New(10, time.Nanosecond).Handler(pages)
+	get(t, h, "/topics/1")
+	time.Sleep(time.Millisecond)
+	get(t, h, "/topics/1")
+	if pages.renders.Load() != 2 {
+		t.Errorf("expired response: rendered %d times, want 2", pages.renders.Load())
+	}
+}
+
+func TestEviction(t *testing.T) {
+	pages := &topicPages{}
+	c := New(2, time.Minute)
+	h := c.Handler(pages)
+	get(t, h, "/topics/1")
+	get(t, h, "/topics/2")
+	get(t, h, "/topics/1") // now the most recently used
+	get(t, h, "/topics/3")
+	if c.Len() != 2 {
+		t.Errorf("%d entries, want 2", c.Len())
+	}
+	get(t, h, "/topics/1")
+	get(t, h, "/topics/2")
+	if pages.renders.Load() != 4 {
+		t.Errorf("rendered %d times, want 4 with /topics/2 evicted", pages.renders.Load())
+	}
+}
+
+// TestWriteDuringRender checks that a response rendered from the data
+// before a write is served to its request but not stored.
+func TestWriteDuringRender(t *testing.T) {
+	pages := &topicPages{release: make(chan struct{}), started: make(chan struct{}, 1)}
+	c := New(10, time.Minute)
+	h := c.Handler(pages)
+
+	done := make(chan struct{})
+	go func() {
+		get(t, h, "/topics/1")
+		close(done)
+	}()
+	<-pages.started
+	c.Invalidate(events.Event{BoardID: 1, TopicID: 1})
+	close(pages.release)
+	<-done
+
+	if c.Len() != 0 {
+		t.Errorf("%d entries, want the stale response dropped", c.Len())
+	}
+}
+
+func TestSingleFlight(t *testing.T) {
+	pages := &topicPages{release: make(chan struct{}), started: make(chan struct{}, 10)}
+	h := New(10, time.Minute).Handler(pages)
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = get(t, h, "/topics/1").Body.String()
+		}(i)
+	}
+	<-pages.started
+	// Give the other requests time to join the render; any that come
+	// later are served from the cache
+	time.Sleep(20 * time.Millisecond)
+	close(pages.release)
+	wg.Wait()
+
+	if pages.renders.Load() != 1 {
+		t.Errorf("rendered %d times, want 1", pages.renders.Load())
+	}
+	for i, body := range bodies {
+		if body != "render 1" {
+			t.Errorf("request %d got %q", i, body)
+		}
+	}
+}
+
+// TestSingleFlightCancel checks that a request waiting for another's
+// render gives up when its own context ends.
+func TestSingleFlightCancel(t *testing.T) {
+	pages := &topicPages{release: make(chan struct{}), started: make(chan struct{}, 10)}
+	h := New(10, time.Minute).Handler(pages)
+
+	leader := make(chan struct{})
+	go func() {
+		get(t, h, "/topics/1")
+		close(leader)
+	}()
+	defer func() {
+		close(pages.release)
+		<-leader
+	}()
+	<-pages.started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/topics/1", nil).WithContext(ctx))
+		done <- w
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case w := <-done:
+		if w.Body.Len() != 0 {
+			t.Errorf("cancelled request got %q", w.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cancelled request still waiting for the render")
+	}
+	if pages.renders.Load() != 1 {
+		t.Errorf("rendered %d times, want 1", pages.renders.Load())
+	}
+}
+
+// TestSharedFailure checks that requests that waited for a render that
+// failed render the page themselves.
+func TestSharedFailure(t *testing.T) {
+	failed := false
+	var mu sync.Mutex
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	h := New(10, time.Minute).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		first := !failed
+		failed = true
+		mu.Unlock()
+		DependOnTopic(r.Context(), 1)
+		if first {
+			started <- struct{}{}
+			<-release
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+
+	leader := make(chan struct{})
+	go func() {
+		get(t, h, "/topics/1")
+		close(leader)
+	}()
+	<-started
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- get(t, h, "/topics/1") }()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	<-leader
+
+	if w := <-done; w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("follower got %d %q, want its own render", w.Code, w.Body)
+	}
+}
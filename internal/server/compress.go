@@ -0,0 +1,271 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Compression levels that suit responses rendered per request: most of the
+// size reduction for little CPU.
+const (
+	gzipLevel   = 5
+	brotliLevel = 4
+)
+
+// compressibleTypes are the media types worth compressing. Images, fonts
+// and archives are compressed already, and event streams are left out so
+// that every event reaches the client as soon as it is flushed.
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"image/svg+xml":          true,
+	"text/css":               true,
+	"text/html":              true,
+	"text/javascript":        true,
+	"text/plain":             true,
+	"text/xml":               true,
+}
+
+// encoder is implemented by both gzip.Writer and brotli.Writer.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+var encoderPools = map[string]*sync.Pool{
+	"br": {New: func() interface{} {
+		return brotli.NewWriterLevel(io.Discard, brotliLevel)
+	}},
+	"gzip": {New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzipLevel)
+		return w
+	}},
+}
+
+// compress encodes responses with brotli or gzip, as negotiated through
+// Accept-Encoding, if their type is compressible and they are at least
+// minSize bytes. Responses that already have a Content-Encoding, partial
+// content and event streams pass through unchanged.
+func compress(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				encoding:       negotiateEncoding(r.Header.Get("Accept-Encoding")),
+				minSize:        minSize,
+			}
+			// Not deferred: after a panic the buffered response is dropped
+			// and the recovery middleware responds instead
+			next.ServeHTTP(cw, r)
+			cw.Close()
+		})
+	}
+}
+
+// negotiateEncoding picks br or gzip from an Accept-Encoding header,
+// preferring br when the client rates both the same, or returns "" if the
+// client accepts neither.
+func negotiateEncoding(header string) string {
+	q := map[string]float64{"br": -1, "gzip": -1, "*": -1}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := q[name]; !ok {
+			continue
+		}
+		weight := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if weight, err = strconv.ParseFloat(value, 64); err != nil {
+				weight = 0
+			}
+		}
+		q[name] = weight
+	}
+
+	for _, name := range []string{"br", "gzip"} {
+		if q[name] < 0 {
+			q[name] = q["*"]
+		}
+	}
+	switch {
+	case q["br"] > 0 && q["br"] >= q["gzip"]:
+		return "br"
+	case q["gzip"] > 0:
+		return "gzip"
+	}
+	return ""
+}
+
+// compressWriter holds back the start of a response until it knows whether
+// to compress it: once minSize bytes are written, the handler flushes or
+// the handler returns.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     encoder
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	switch {
+	case cw.decided || status < 200:
+		// Informational responses such as 103 Early Hints go out as
+		// they are
+		cw.ResponseWriter.WriteHeader(status)
+	case cw.status == 0:
+		cw.status = status
+		if status == http.StatusNoContent || status == http.StatusNotModified {
+			cw.decide(false)
+		}
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.minSize {
+			return len(p), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide sends the header, compressing the response if it qualifies;
+// large says whether it has reached the size threshold. The buffered
+// start of the body is then written.
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	h := cw.Header()
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		// As net/http would, so the type can be checked
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	switch {
+	case cw.status == http.StatusNotModified:
+		// It stands for the full response, which may have been encoded
+		addVary(h, "Accept-Encoding")
+	case cw.compressible():
+		addVary(h, "Accept-Encoding")
+		if length, err := strconv.Atoi(h.Get("Content-Length")); err == nil && length < cw.minSize {
+			large = false
+		}
+		if large && cw.encoding != "" {
+			h.Set("Content-Encoding", cw.encoding)
+			h.Del("Content-Length")
+			// A strong tag promises identical bytes, which an encoded body
+			// is not
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+			cw.enc = encoderPools[cw.encoding].Get().(encoder)
+			cw.enc.Reset(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// compressible reports whether the response is of a compressible type and
+// can be encoded.
+func (cw *compressWriter) compressible() bool {
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" ||
+		cw.status == http.StatusPartialContent || cw.status == http.StatusNoContent {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return compressibleTypes[mediaType] || strings.HasSuffix(mediaType, "+json")
+}
+
+// Flush sends what has been written so far, so streamed responses reach
+// the client without waiting for the size threshold.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the response: a response shorter than the threshold is
+// sent as it is, and a compressed one has its encoder flushed and pooled.
+func (cw *compressWriter) Close() {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			// The handler wrote nothing; leave the defaults to net/http
+			return
+		}
+		cw.decide(false)
+	}
+	if cw.enc != nil {
+		cw.enc.Close()
+		cw.enc.Reset(io.Discard)
+		encoderPools[cw.encoding].Put(cw.enc)
+		cw.enc = nil
+	}
+}
+
+// Unwrap gives http.ResponseController access to the connection.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// addVary adds a header name to Vary unless it is listed already.
+func addVary(h http.Header, name string) {
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"br", "br"},
+		{"gzip, deflate, br", "br"},
+		{"GZIP", "gzip"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=1.0, gzip;q=1.0", "br"},
+		{"gzip;q=0, br;q=0", ""},
+		{"br;q=0, gzip;q=0.1", "gzip"},
+		{"*", "br"},
+		{"*;q=0.5, br;q=0", "gzip"},
+		{"gzip;q=bogus", ""},
+		{" gzip ; q=0.8 , deflate", "gzip"},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.header); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+const minSize = 100
+
+var large = strings.Repeat("compressible text ", 50)
+
+// serve runs handler behind the compression middleware for a request
+// accepting encoding.
+func serve(t *testing.T, encoding string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if encoding != "" {
+		req.Header.Set("Accept-Encoding", encoding)
+	}
+	w := httptest.NewRecorder()
+	compress(minSize)(handler).ServeHTTP(w, req)
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var r io.Reader = w.Body
+	switch w.Header().Get("Content-Encoding") {
+	case "gzip":
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	case "br":
+		r = brotli.NewReader(w.Body)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func writeText(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, body)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	for _, encoding := range []string{"br", "gzip"} {
+		t.Run(encoding, func(t *testing.T) {
+			w := serve(t, encoding, writeText(large))
+			if got := w.Header().Get("Content-Encoding"); got != encoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, encoding)
+			}
+			if w.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Vary = %q", w.Header().Get("Vary"))
+			}
+			if w.Body.Len() >= len(large) {
+				t.Errorf("%d bytes encoded from %d", w.Body.Len(), len(large))
+			}
+			if got := decode(t, w); got != large {
+				t.Errorf("decoded body differs: %q", got)
+			}
+		})
+	}
+
+	// The encoders are pooled; a second response must not carry state
+	// from the first
+	for i := 0; i < 2; i++ {
+		if got := decode(t, serve(t, "gzip", writeText(large))); got != large {
+			t.Errorf("response %d decoded to %q", i, got)
+		}
+	}
+}
+
+// TestCompressWrites checks a body written in pieces, crossing the
+// threshold partway.
+func TestCompressWrites(t *testing.T) {
+	w := serve(t, "gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		for i := 0; i < len(large); i += 7 {
+			io.WriteString(w, large[i:min(i+7, len(large))])
+		}
+	})
+	if w.Code != http.StatusCreated {
+		t.Errorf("status %d, want 201", w.Code)
+	}
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("not compressed")
+	}
+	if got := decode(t, w); got != large {
+		t.Errorf("decoded body differs: %q", got)
+	}
+}
+
+func TestCompressSkips(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		handler  http.HandlerFunc
+		vary     bool
+	}{
+		{"not accepted", "", writeText(large), true},
+		{"small", "gzip", writeText("short"), true},
+		{"small by Content-Length", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", "50")
+			// A handler that flushes before the threshold
+			io.WriteString(w, large[:50])
+			w.(http.Flusher).Flush()
+		}, true},
+		{"image", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, large)
+		}, false},
+		{"event stream", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, large)
+		}, false},
+		{"already encoded", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "identity")
+			io.WriteString(w, large)
+		}, false},
+		{"partial content", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Range", "bytes 0-899/2000")
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, large)
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var expected bytes.Buffer
+			rec := httptest.NewRecorder()
+			tt.handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			expected.Write(rec.Body.Bytes())
+
+			w := serve(t, tt.encoding, tt.handler)
+			if got := w.Header().Get("Content-Encoding"); got != "" && got != "identity" {
+				t.Errorf("Content-Encoding = %q, want none", got)
+			}
+			if w.Body.String() != expected.String() {
+				t.Errorf("body changed: %q", w.Body)
+			}
+			if got := w.Header().Get("Vary") == "Accept-Encoding"; got != tt.vary {
+				t.Errorf("Vary = %q", w.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestCompressHeaders(t *testing.T) {
+	w := serve(t, "br", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", "900")
+		w.Header().Set("Vary", "Origin")
+		io.WriteString(w, large)
+	})
+	if got := w.Header().Get("ETag"); got != `W/"v1"` {
+		t.Errorf("ETag = %q, want the weak tag", got)
+	}
+	if got := w.Header().Get("Content-Length"); got != "" {
+		t.Errorf("Content-Length = %q, want none", got)
+	}
+	if got := w.Header().Values("Vary"); len(got) != 2 || got[1] != "Accept-Encoding" {
+		t.Errorf("Vary = %q", got)
+	}
+
+	// A 304 stands for the encoded response it validates
+	w = serve(t, "br", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	})
+	if w.Code != http.StatusNotModified || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("304: status %d, Vary %q", w.Code, w.Header().Get("Vary"))
+	}
+
+	// A handler that writes nothing gets net/http's defaults
+	w = serve(t, "br", func(w http.ResponseWriter, r *http.Request) {})
+	if w.Code != http.StatusOK || len(w.Header()) != 0 {
+		t.Errorf("empty response: status %d, header %v", w.Code, w.Header())
+	}
+}
+
+// TestCompressFlush checks that flushing sends what was written so far,
+// decodable before the response ends.
+func TestCompressFlush(t *testing.T) {
+	var flushed []byte
+	w := httptest.NewRecorder()
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain")
+		io.WriteString(rw, "first event\n")
+		rw.(http.Flusher).Flush()
+		flushed = append([]byte(nil), w.Body.Bytes()...)
+		io.WriteString(rw, "second event\n")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	compress(minSize)(http.HandlerFunc(handler)).ServeHTTP(w, req)
+
+	if !w.Flushed {
+		t.Error("not flushed")
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(flushed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, _ := io.ReadAtLeast(zr, buf, len("first event\n"))
+	if got := string(buf[:n]); got != "first event\n" {
+		t.Errorf("flushed %q", got)
+	}
+	if got := decode(t, w); got != "first event\nsecond event\n" {
+		t.Errorf("decoded body %q", got)
+	}
+}
+
+func TestCompressHead(t *testing.T) {
+	req := httptest.NewRequest(http.MethodHead, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	compress(minSize)(writeText(large)).ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Error("HEAD response encoded")
+	}
+}
@@ -1,34 +1,287 @@
 package server
 
 import (
-	"os"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+
+	"minibb/internal/logging"
+	"minibb/internal/metrics"
+	"minibb/internal/models"
+	"minibb/internal/pow"
+	"minibb/internal/tracing"
+	"minibb/internal/utils"
 )
 
 func (s *Server) setupMiddleware() {
-	// Basic logging
-	s.router.Use(middleware.Logger)
-	s.router.Use(middleware.Recoverer)
-
-	// CORS for development mode
-	if isDevelopment() {
-		s.router.Use(cors.Handler(cors.Options{
-			AllowedOrigins:   []string{"http://localhost:5173"},
-			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-			ExposedHeaders:   []string{"Link"},
-			AllowCredentials: true,
-			MaxAge:           300,
-		}))
+	// The client address, for logs, bans and duplicate checks
+	s.router.Use(s.resolveClientIP)
+
+	// Request IDs, logging and metrics
+	s.router.Use(assignRequestID)
+	s.router.Use(traceRequests)
+	s.router.Use(logRequests)
+	s.router.Use(recoverPanics)
+	s.router.Use(instrumentRequests)
+
+	// Security headers and the Content-Security-Policy
+	s.router.Use(s.setSecurityHeaders)
+
+	// Compression for clients that accept it
+	if s.config.Server.Compression {
+		s.router.Use(compress(s.config.Server.CompressMinBytes))
 	}
 
+	// CORS for the configured origins, such as the Vite dev server
+	s.setCORSOrigins(s.config.Server.CORSOrigins)
+	s.router.Use(s.applyCORS)
+
 	// Request timeout
-	s.router.Use(middleware.Timeout(30 * time.Second))
+	s.router.Use(middleware.Timeout(s.config.Limits.RequestTimeout))
+}
+
+// resolveClientIP replaces the remote address of requests from trusted
+// proxies with the client address they forward.
+func (s *Server) resolveClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = s.proxies.Resolve(r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setCORSOrigins replaces the origins allowed to call the API. No origins
+// disables CORS.
+func (s *Server) setCORSOrigins(origins []string) {
+	if len(origins) == 0 {
+		s.cors.Store(nil)
+		return
+	}
+	s.cors.Store(cors.New(cors.Options{
+		AllowedOrigins: origins,
+		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{
+			"Accept", "Authorization", "Content-Type", "X-CSRF-Token", utils.RequestIDHeader,
+			pow.ChallengeHeader, pow.NonceHeader,
+		},
+		ExposedHeaders:   []string{"Link", utils.RequestIDHeader},
+		AllowCredentials: true,
+		MaxAge:           300,
+	}))
+}
+
+// applyCORS runs the current CORS handler, which a reload may swap.
+func (s *Server) applyCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c := s.cors.Load(); c != nil {
+			c.Handler(next).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cached serves a read route through the response cache, if enabled.
+func (s *Server) cached(next http.Handler) http.Handler {
+	if s.cache == nil {
+		return next
+	}
+	return s.cache.Handler(next)
 }
 
-func isDevelopment() bool {
-	return os.Getenv("ENV") == "development"
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// assignRequestID takes the request ID from the X-Request-ID header, as set
+// by a proxy in front of the server, or generates one. It is echoed in the
+// response and attached to the request's logger.
+func assignRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(utils.RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(utils.RequestIDHeader, id)
+
+		logger := logging.For(logging.HTTP).With("request_id", id)
+		next.ServeHTTP(w, r.WithContext(logging.WithLogger(r.Context(), logger)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// traceRequests wraps each request in a server span, continuing the trace
+// of a caller that sent a traceparent header. The span is named after the
+// route pattern once routing is done.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote := tracing.ParseTraceparent(r.Header.Get("traceparent"))
+		ctx, span := tracing.StartKind(r.Context(), r.Method, tracing.KindServer, remote)
+		if span == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer span.End()
+
+		ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("trace_id", span.TraceID()))
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		rctx := chi.RouteContext(r.Context())
+		route := rctx.RoutePattern()
+		span.SetName(r.Method + " " + route)
+		span.SetString("http.request.method", r.Method)
+		span.SetString("http.route", route)
+		span.SetString("url.path", r.URL.Path)
+		span.SetString("minibb.request_id", w.Header().Get(utils.RequestIDHeader))
+		if board := rctx.URLParam("board"); board != "" {
+			span.SetString("minibb.board", board)
+		}
+		if topicID, err := strconv.Atoi(rctx.URLParam("topicId")); err == nil {
+			span.SetInt("minibb.topic_id", topicID)
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetInt("http.response.status_code", status)
+		if status >= 500 {
+			span.RecordError(fmt.Errorf("%s", http.StatusText(status)))
+		}
+	})
+}
+
+// logRequests writes an access log line for every request.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		logging.FromContext(r.Context()).Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", chi.RouteContext(r.Context()).RoutePattern(),
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"ip", utils.ClientIP(r),
+		)
+	})
+}
+
+// recoverPanics turns a panicking handler into a 500 response and logs the
+// panic with its stack.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			logging.FromContext(r.Context()).Error("handler panicked",
+				"panic", fmt.Sprint(rec), "stack", string(debug.Stack()))
+			utils.RespondWithError(w, http.StatusInternalServerError, utils.APIError{
+				Detail: "encountered an unexpected internal failure on the backend server",
+			})
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// instrumentRequests counts requests and their latency by route pattern,
+// so /api/topics/{topicId}/posts is one series however many topics there
+// are.
+func instrumentRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.HTTPRequests.Inc(r.Method, route, strconv.Itoa(status))
+		metrics.HTTPDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+// redirectLegacyURLs answers requests for paths in the redirects table with
+// a 301 to the target's current route. Besides the exact path and query, a
+// path matches with each of phpBB's id parameters on its own, so
+// viewtopic.php?f=2&t=5 finds the viewtopic.php?t=5 redirect. Static files
+// never have a redirect and skip the lookup.
+func (s *Server) redirectLegacyURLs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !mayBeLegacy(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target, err := models.ResolveRedirect(r.Context(), s.reader, redirectCandidates(r.URL))
+		if err != nil {
+			// A failed lookup shouldn't take the SPA down with it.
+			logging.FromContext(r.Context()).Error("redirect lookup failed", "error", err)
+		}
+		if target == "" || target == r.URL.Path {
+			next.ServeHTTP(w, r)
+			return
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
+
+// legacyQueryKeys are the query parameters that identify a forum, topic or
+// post in phpBB URLs.
+var legacyQueryKeys = []string{"f", "p", "t"}
+
+// mayBeLegacy reports whether a redirect could exist for path: old routes
+// have no extension and phpBB's are PHP scripts.
+func mayBeLegacy(urlPath string) bool {
+	if strings.HasPrefix(urlPath, "/assets/") {
+		return false
+	}
+	ext := path.Ext(urlPath)
+	return ext == "" || ext == ".php"
+}
+
+func redirectCandidates(u *url.URL) []string {
+	if u.RawQuery == "" {
+		return []string{u.Path}
+	}
+
+	candidates := []string{u.Path + "?" + u.RawQuery}
+	query := u.Query()
+	for _, key := range legacyQueryKeys {
+		if value := query.Get(key); value != "" {
+			candidates = append(candidates, u.Path+"?"+key+"="+value)
+		}
+	}
+	return append(candidates, u.Path)
 }
@@ -1,71 +1,134 @@
 package server
 
 import (
-	"io"
 	"io/fs"
 	"net/http"
-	"time"
+	"path"
 
 	"github.com/go-chi/chi/v5"
 
+	"minibb/internal/auth"
 	"minibb/internal/db"
+	"minibb/internal/events"
+	"minibb/internal/filters"
 	"minibb/internal/handlers"
+	"minibb/internal/health"
+	"minibb/internal/metrics"
+	"minibb/internal/pow"
 )
 
 func (s *Server) setupRoutes() {
+	if s.config.Server.MetricsListen == "" {
+		s.router.With(auth.Middleware(s.admins), auth.RequireAdmin).
+			Get("/metrics", metrics.Handler().ServeHTTP)
+	}
+
 	// API routes
 	s.router.Route("/api", func(r chi.Router) {
-		// Add database context middleware
+		// Add database, proof-of-work, filter, event hub and health check
+		// context middleware
 		r.Use(func(next http.Handler) http.Handler {
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				ctx := db.WithDB(r.Context(), s.db)
+				ctx = db.WithReader(ctx, s.reader)
+				ctx = db.WithConfig(ctx, s.config.Database)
+				ctx = pow.WithVerifier(ctx, s.pow)
+				ctx = filters.WithStore(ctx, s.filters)
+				ctx = events.WithHub(ctx, s.events)
+				ctx = health.WithChecker(ctx, s.health)
 				next.ServeHTTP(w, r.WithContext(ctx))
 			})
 		})
+		r.Use(auth.Middleware(s.admins))
 
 		r.Get("/health", handlers.HealthCheck)
-		r.Get("/boards", handlers.ListBoards)
-		r.Get("/boards/{board}/topics", handlers.ListTopics)
-		r.Get("/topics/{topicId}/posts", handlers.ListPosts)
+		r.Get("/health/live", handlers.Live)
+		r.Get("/health/ready", handlers.Ready)
+		r.With(s.cached).Get("/boards", handlers.ListBoards)
+		r.With(s.cached).Get("/boards/{board}/topics", handlers.ListTopics)
+		r.With(s.cached).Get("/boards/{board}/archive", handlers.ListArchivedTopics)
+		r.With(s.cached).Get("/topics/{topicId}/posts", handlers.ListPosts)
+		r.Get("/pow/challenge", handlers.IssuePowChallenge)
+		r.Post("/csp-report", handlers.CSPReport)
+
+		r.Post("/boards/{board}/topics", handlers.CreateTopic)
+		r.Post("/topics/{topicId}/posts", handlers.CreatePost)
+
+		if s.config.Server.PublicModLog {
+			r.Get("/boards/{board}/modlog", handlers.ListBoardModLog)
+		}
+
+		// Admin routes
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(auth.RequireAdmin)
+
+			r.Get("/modlog", handlers.ListModLog)
+			r.Put("/boards/{board}", handlers.UpdateBoard)
+			r.Get("/boards/{board}/settings", handlers.GetBoardSettings)
+			r.Put("/boards/{board}/settings", handlers.UpdateBoardSettings)
+			r.Get("/fsck", handlers.CheckConsistency)
+			r.Post("/fsck", handlers.RepairConsistency)
+			r.Get("/backup", handlers.DownloadBackup)
+			r.Get("/queue", handlers.ListQueue)
+			r.Post("/posts/{postId}/approve", handlers.ApprovePost)
+			r.Post("/posts/{postId}/reject", handlers.RejectPost)
+			r.Put("/topics/{topicId}/status", handlers.SetTopicStatus)
+			r.Post("/topics/{topicId}/move", handlers.MoveTopic)
+			r.Delete("/topics/{topicId}", handlers.DeleteTopic)
+			r.Delete("/posts/{postId}", handlers.DeletePost)
+			r.Get("/bans", handlers.ListBans)
+			r.Post("/bans", handlers.CreateBan)
+			r.Delete("/bans/{banId}", handlers.LiftBan)
+			r.Get("/filters", handlers.ListFilters)
+			r.Post("/filters", handlers.CreateFilter)
+			r.Put("/filters/{filterId}", handlers.UpdateFilter)
+			r.Delete("/filters/{filterId}", handlers.DeleteFilter)
+		})
 	})
 
-	// Static file serving for production
-	if !isDevelopment() {
-		s.setupStaticFileServing()
+	// Static file serving for production. Legacy URLs are redirected
+	// before they reach the SPA, even when there is none to serve.
+	var spa http.Handler = http.NotFoundHandler()
+	if !s.config.Development() {
+		if handler := s.staticFileHandler(); handler != nil {
+			spa = handler
+		}
 	}
+	s.router.With(s.redirectLegacyURLs).Get("/*", spa.ServeHTTP)
 }
 
-func (s *Server) setupStaticFileServing() {
+func (s *Server) staticFileHandler() http.Handler {
 	if s.staticFiles == nil {
 		// No static files to serve (dev mode)
-		return
+		return nil
 	}
 
 	// Serve embedded static files
 	distFS, err := fs.Sub(*s.staticFiles, "web/dist")
 	if err != nil {
 		// If no embedded files, serve nothing (dev mode)
-		return
+		return nil
 	}
 
 	fileServer := http.FileServer(http.FS(distFS))
+	index, indexErr := fs.ReadFile(distFS, "index.html")
 
-	s.router.Get("/*", func(w http.ResponseWriter, r *http.Request) {
-		// Try to serve the file
-		if r.URL.Path != "/" {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Try to serve the file; paths without an extension are client-side
+		// routes such as /b/general/t/1
+		if r.URL.Path != "/" && path.Ext(r.URL.Path) != "" {
 			fileServer.ServeHTTP(w, r)
 			return
 		}
 
-		// For root path, serve index.html
-		file, err := distFS.Open("index.html")
-		if err != nil {
+		// Otherwise serve index.html. Its tags carry this response's CSP
+		// nonce, so it must not be stored and served again.
+		if indexErr != nil {
 			http.NotFound(w, r)
 			return
 		}
-		defer file.Close()
-
-		w.Header().Set("Content-Type", "text/html")
-		http.ServeContent(w, r, "index.html", time.Time{}, file.(io.ReadSeeker))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(addNonce(index, nonceFromContext(r.Context())))
 	})
 }
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Headers sent with every response. The site is never framed, doesn't
+// tell other sites which thread a link was followed from and has no use
+// for device features.
+const (
+	referrerPolicy    = "same-origin"
+	permissionsPolicy = "camera=(), geolocation=(), microphone=(), payment=(), usb=()"
+)
+
+// cspReportEndpoint is where browsers send violation reports, named csp
+// for the report-to directive.
+const cspReportEndpoint = `csp="/api/csp-report"`
+
+type contextKey string
+
+const nonceContextKey contextKey = "nonce"
+
+// nonceFromContext returns the CSP nonce of the response, or "" if the
+// policy has none.
+func nonceFromContext(ctx context.Context) string {
+	nonce, _ := ctx.Value(nonceContextKey).(string)
+	return nonce
+}
+
+// setSecurityHeaders adds the security headers to every response,
+// including the Content-Security-Policy with a fresh nonce.
+func (s *Server) setSecurityHeaders(next http.Handler) http.Handler {
+	security := s.config.Security
+	cspHeader := "Content-Security-Policy"
+	if security.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	var hsts string
+	if security.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.FormatInt(int64(security.HSTSMaxAge.Seconds()), 10)
+		if security.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if security.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", referrerPolicy)
+		h.Set("Permissions-Policy", permissionsPolicy)
+		if hsts != "" {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+
+		if policy := security.CSP; policy != "" {
+			if strings.Contains(policy, "{nonce}") {
+				nonce := newNonce()
+				policy = strings.ReplaceAll(policy, "{nonce}", nonce)
+				r = r.WithContext(context.WithValue(r.Context(), nonceContextKey, nonce))
+			}
+			h.Set(cspHeader, policy)
+			h.Set("Reporting-Endpoints", cspReportEndpoint)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// addNonce adds a nonce attribute to the script and style tags of an HTML
+// page, so that the policy allows them.
+func addNonce(page []byte, nonce string) []byte {
+	if nonce == "" {
+		return page
+	}
+	attr := []byte(` nonce="` + nonce + `"`)
+	for _, tag := range []string{"<script", "<style"} {
+		page = bytes.ReplaceAll(page, []byte(tag), append([]byte(tag), attr...))
+	}
+	return page
+}
@@ -2,65 +2,287 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"embed"
 	"fmt"
+	"net"
 	"net/http"
-	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/cors"
+
+	"minibb/internal/auth"
+	"minibb/internal/config"
+	"minibb/internal/events"
+	"minibb/internal/filters"
+	"minibb/internal/health"
+	"minibb/internal/jobs"
+	"minibb/internal/logging"
+	"minibb/internal/metrics"
+	"minibb/internal/pow"
+	"minibb/internal/respcache"
+	"minibb/internal/utils"
 )
 
 type Server struct {
 	router      *chi.Mux
+	config      config.Config
 	db          *sql.DB
-	port        string
+	reader      *sql.DB
 	staticFiles *embed.FS
+	admins      *auth.Tokens
+	proxies     *utils.TrustedProxies
+	pow         *pow.Verifier
+	filters     *filters.Store
+	events      *events.Hub
+	cache       *respcache.Cache // nil if disabled
+	pruner      *jobs.Pruner
+	backups     *jobs.Backups
+	health      *health.Checker
+	cors        atomic.Pointer[cors.Cors]
+
+	// reloadMu serializes reloads; reloaded is the configuration of
+	// the last one.
+	reloadMu sync.Mutex
+	reloaded config.Config
 }
 
-func New(db *sql.DB, staticFiles *embed.FS) *Server {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+// New creates a server that writes through db and serves read-only
+// requests from reader. The configuration must have been validated.
+func New(config config.Config, db, reader *sql.DB, staticFiles *embed.FS) (*Server, error) {
+	admins, err := config.AdminTokens()
+	if err != nil {
+		return nil, fmt.Errorf("invalid admin tokens: %w", err)
+	}
+
+	proxies, err := config.TrustedProxies()
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
+	verifier, err := pow.ConfigureVerifier(config.PoW.Secret, config.PoW.ChallengeTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up proof-of-work: %w", err)
+	}
+
+	hub := events.NewHub()
+	pruner := jobs.NewPruner(db, hub, config.Jobs.PruneInterval, config.Jobs.ArchiveRetention)
+
+	backups, err := jobs.ConfigureBackups(config.Database, config.Jobs.BackupDir,
+		config.Jobs.BackupInterval, config.Jobs.BackupKeep)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up backups: %w", err)
 	}
 
 	s := &Server{
 		router:      chi.NewRouter(),
+		config:      config,
 		db:          db,
-		port:        port,
+		reader:      reader,
 		staticFiles: staticFiles,
+		admins:      admins,
+		proxies:     proxies,
+		pow:         verifier,
+		filters:     filters.NewStore(),
+		events:      hub,
+		pruner:      pruner,
+		backups:     backups,
+		reloaded:    config,
+	}
+	s.health = s.newChecker()
+
+	if config.Cache.Entries > 0 {
+		s.cache = respcache.New(config.Cache.Entries, config.Cache.MaxAge)
+		hub.Subscribe(s.cache.Invalidate)
 	}
 
+	metrics.RegisterPool("write", db)
+	metrics.RegisterPool("read", reader)
+
 	s.setupMiddleware()
 	s.setupRoutes()
 
-	return s
+	return s, nil
+}
+
+// newChecker sets up the readiness checks: the database, its schema, free
+// space on its volume and the background jobs.
+func (s *Server) newChecker() *health.Checker {
+	trackers := []interface{ Status() jobs.Status }{s.pruner}
+	if s.backups != nil {
+		trackers = append(trackers, s.backups)
+	}
+
+	return health.NewChecker(
+		health.Database(s.reader),
+		health.Migrations(s.reader),
+		health.DiskSpace(s.config.Database.Path, uint64(s.config.Limits.MinFreeDiskMB)<<20),
+		health.Jobs(trackers...),
+	)
+}
+
+// Reload applies the reloadable settings of next, the admin tokens, CORS
+// origins and log levels, and reloads the word filters from the database.
+// Settings that differ from the running configuration but only take
+// effect after a restart are logged.
+func (s *Server) Reload(next config.Config) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	changed, _ := s.reloaded.Changes(next)
+	for _, key := range changed {
+		switch key {
+		case "admin.tokens":
+			tokens, _ := next.AdminTokens()
+			s.admins.Replace(tokens)
+		case "server.cors_origins":
+			s.setCORSOrigins(next.Server.CORSOrigins)
+		case "log.level":
+			logConfig, _ := next.Logging()
+			logConfig.Format = s.config.Log.Format
+			logging.Setup(logConfig)
+		}
+	}
+	s.filters.Invalidate()
+	s.reloaded = next
+
+	logger := logging.For(logging.Server)
+	logger.Info("configuration reloaded", "changed", changed)
+	if _, restart := s.config.Changes(next); len(restart) > 0 {
+		logger.Warn("changed settings take effect after a restart", "settings", restart)
+	}
 }
 
 func (s *Server) Start(ctx context.Context) error {
 	server := &http.Server{
-		Addr:    ":" + s.port,
-		Handler: s.router,
+		Handler:           s.router,
+		ReadHeaderTimeout: s.config.Server.ReadHeaderTimeout,
+		IdleTimeout:       s.config.Server.IdleTimeout,
+		MaxHeaderBytes:    s.config.Server.MaxHeaderBytes,
+	}
+	if s.config.Server.TLSCert != "" {
+		certs, err := newCertReloader(s.config.Server.TLSCert, s.config.Server.TLSKey)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		server.TLSConfig = &tls.Config{
+			GetCertificate: certs.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
 	}
 
-	// Start server in a goroutine
-	errChan := make(chan error, 1)
-	go func() {
-		fmt.Printf("Server starting on port %s\n", s.port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			errChan <- err
+	listeners, err := s.listen()
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	for _, listener := range listeners {
+		if listener.Addr().Network() == "unix" && !s.proxies.Trusts("") {
+			logging.For(logging.Server).Warn("clients on a Unix socket have no address; " +
+				"add \"unix\" to server.trusted_proxies if a proxy forwards it, " +
+				"otherwise bans and duplicate checks don't apply")
+			break
 		}
+	}
+
+	// Start background jobs; they stop with the server context and are
+	// waited for before Start returns
+	jobsCtx, stopJobs := context.WithCancel(ctx)
+	var jobsWG sync.WaitGroup
+	jobsWG.Add(1)
+	go func() {
+		defer jobsWG.Done()
+		s.pruner.Run(jobsCtx)
+	}()
+	if s.backups != nil {
+		jobsWG.Add(1)
+		go func() {
+			defer jobsWG.Done()
+			s.backups.Run(jobsCtx)
+		}()
+	}
+	defer func() {
+		stopJobs()
+		jobsWG.Wait()
 	}()
 
+	// Serve every listener in its own goroutine
+	errChan := make(chan error, len(listeners)+1)
+	metricsServer, err := s.serveMetrics(errChan)
+	if err != nil {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		return fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+	if metricsServer != nil {
+		defer metricsServer.Close()
+	}
+
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			logging.For(logging.Server).Info("server starting",
+				"network", listener.Addr().Network(), "address", listener.Addr().String(),
+				"tls", server.TLSConfig != nil)
+			var err error
+			if server.TLSConfig != nil {
+				err = server.ServeTLS(listener, "", "")
+			} else {
+				err = server.Serve(listener)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				errChan <- err
+			}
+		}(listener)
+	}
+
 	// Wait for context cancellation or server error
 	select {
 	case err := <-errChan:
+		server.Close()
 		return err
 	case <-ctx.Done():
+		// Fail readiness first and give load balancers time to notice
+		// before connections stop being accepted
+		s.health.Drain()
+		if delay := s.config.Server.DrainDelay; delay > 0 {
+			logging.For(logging.Server).Info("draining before shutdown", "delay", delay.String())
+			time.Sleep(delay)
+		}
+
 		// Graceful shutdown
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.Server.ShutdownTimeout)
 		defer cancel()
 		return server.Shutdown(shutdownCtx)
 	}
 }
+
+// serveMetrics serves /metrics on the separate metrics address, if one is
+// configured, reporting a failure on errChan.
+func (s *Server) serveMetrics(errChan chan<- error) (*http.Server, error) {
+	address := s.config.Server.MetricsListen
+	if address == "" {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: s.config.Server.ReadHeaderTimeout,
+	}
+	go func() {
+		logging.For(logging.Server).Info("metrics server starting", "address", listener.Addr().String())
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			errChan <- err
+		}
+	}()
+	return server, nil
+}
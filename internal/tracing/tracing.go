@@ -0,0 +1,228 @@
+// Package tracing records spans in the OpenTelemetry data model and exports
+// them over OTLP/HTTP with JSON encoding. Tracing is off unless an endpoint
+// is configured; Start then returns a nil span whose methods do nothing, so
+// instrumented code pays for one atomic load.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Span kinds as numbered by OTLP.
+const (
+	KindInternal = 1
+	KindServer   = 2
+)
+
+// Config configures the exporter. An empty Endpoint disables tracing.
+// SampleRatio is the fraction of traces to keep. The environment variables
+// are the standard OpenTelemetry ones.
+type Config struct {
+	Endpoint    string  `toml:"endpoint" env:"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"`
+	ServiceName string  `toml:"service_name" env:"OTEL_SERVICE_NAME"`
+	SampleRatio float64 `toml:"sample_ratio" env:"OTEL_TRACES_SAMPLER_ARG"`
+}
+
+func DefaultConfig() Config {
+	return Config{ServiceName: "minibb", SampleRatio: 1}
+}
+
+func (c Config) Validate() error {
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("sample ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+type tracer struct {
+	config   Config
+	exporter *exporter
+}
+
+var active atomic.Pointer[tracer]
+
+// Setup starts exporting spans if an endpoint is configured. The returned
+// function flushes pending spans and stops the exporter.
+func Setup(config Config) func(context.Context) error {
+	if config.Endpoint == "" {
+		return func(context.Context) error { return nil }
+	}
+
+	t := &tracer{config: config, exporter: newExporter(config)}
+	active.Store(t)
+	return func(ctx context.Context) error {
+		active.CompareAndSwap(t, nil)
+		return t.exporter.shutdown(ctx)
+	}
+}
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// Span is a timed operation. A nil *Span is valid and ignores all calls.
+type Span struct {
+	tracer     *tracer
+	context    SpanContext
+	parentID   [8]byte
+	name       string
+	kind       int
+	start      time.Time
+	mu         sync.Mutex
+	attributes map[string]interface{}
+	errMessage string
+	ended      bool
+}
+
+type contextKey string
+
+const spanKey contextKey = "span"
+
+// SpanFromContext returns the span stored in ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// Start begins an internal span as a child of the span in ctx.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return StartKind(ctx, name, KindInternal, SpanContext{})
+}
+
+// StartKind begins a span of the given kind. If ctx holds no span, remote
+// continues the trace of a caller, as parsed by ParseTraceparent.
+func StartKind(ctx context.Context, name string, kind int, remote SpanContext) (context.Context, *Span) {
+	t := active.Load()
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.context.TraceID = parent.context.TraceID
+		span.context.Sampled = parent.context.Sampled
+		span.parentID = parent.context.SpanID
+	} else if remote.TraceID != ([16]byte{}) {
+		span.context.TraceID = remote.TraceID
+		span.context.Sampled = remote.Sampled
+		span.parentID = remote.SpanID
+	} else {
+		rand.Read(span.context.TraceID[:])
+		span.context.Sampled = sampled(span.context.TraceID, t.config.SampleRatio)
+	}
+	rand.Read(span.context.SpanID[:])
+
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// sampled decides from the trace ID so that every span of a trace gets the
+// same answer.
+func sampled(traceID [16]byte, ratio float64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(traceID[8:])>>11)/(1<<53) < ratio
+}
+
+// SetString attaches a string attribute.
+func (s *Span) SetString(key, value string) {
+	if s != nil {
+		s.setAttr(key, value)
+	}
+}
+
+// SetInt attaches an integer attribute. Taking a concrete type keeps the
+// disabled path free of allocations.
+func (s *Span) SetInt(key string, value int) {
+	if s != nil {
+		s.setAttr(key, int64(value))
+	}
+}
+
+func (s *Span) setAttr(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = make(map[string]interface{})
+	}
+	s.attributes[key] = value
+}
+
+// SetName renames the span, for names only known once it is done.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// RecordError marks the span as failed. A nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errMessage = err.Error()
+}
+
+// End finishes the span and queues it for export.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.mu.Unlock()
+
+	if s.context.Sampled {
+		s.tracer.exporter.enqueue(s, end)
+	}
+}
+
+// TraceID returns the hex trace ID, or "" for a nil span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.context.TraceID[:])
+}
+
+// ParseTraceparent parses a W3C traceparent header. It returns a zero
+// SpanContext if the header is missing or malformed.
+func ParseTraceparent(header string) SpanContext {
+	var sc SpanContext
+	if len(header) != 55 || header[2] != '-' || header[35] != '-' || header[52] != '-' {
+		return SpanContext{}
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(header[3:35])); err != nil {
+		return SpanContext{}
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(header[36:52])); err != nil {
+		return SpanContext{}
+	}
+	flags, err := strconv.ParseUint(header[53:55], 16, 8)
+	if err != nil || sc.TraceID == ([16]byte{}) || sc.SpanID == ([8]byte{}) {
+		return SpanContext{}
+	}
+	sc.Sampled = flags&1 == 1
+	return sc
+}
@@ -0,0 +1,234 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector is a local OTLP/HTTP endpoint that keeps the spans posted to
+// it.
+type collector struct {
+	mu       sync.Mutex
+	requests []otlpRequest
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req otlpRequest
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	c.requests = append(c.requests, req)
+	c.mu.Unlock()
+}
+
+func (c *collector) spans() map[string]otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	spans := make(map[string]otlpSpan)
+	for _, req := range c.requests {
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					spans[span.Name] = span
+				}
+			}
+		}
+	}
+	return spans
+}
+
+// setup starts tracing to a local collector and returns the function that
+// flushes and stops it.
+func setup(t *testing.T, config Config) (*collector, func()) {
+	t.Helper()
+	c := &collector{}
+	server := httptest.NewServer(c)
+	t.Cleanup(server.Close)
+
+	config.Endpoint = server.URL + "/v1/traces"
+	shutdown := Setup(config)
+	return c, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func attributes(span otlpSpan) map[string]otlpValue {
+	values := make(map[string]otlpValue)
+	for _, attr := range span.Attributes {
+		values[attr.Key] = attr.Value
+	}
+	return values
+}
+
+func TestExport(t *testing.T) {
+	c, shutdown := setup(t, DefaultConfig())
+
+	ctx, parent := StartKind(context.Background(), "GET", KindServer, SpanContext{})
+	parent.SetString("http.method", "GET")
+	parent.SetInt("http.status_code", 500)
+	parent.SetName("GET /api/boards")
+	_, child := Start(ctx, "ListBoards")
+	child.RecordError(errors.New("database is locked"))
+	child.RecordError(nil)
+	child.End()
+	parent.End()
+	parent.End() // ending twice exports once
+	shutdown()
+
+	if n := len(c.requests); n != 1 {
+		t.Fatalf("%d export requests, want 1", n)
+	}
+	resource := c.requests[0].ResourceSpans[0].Resource
+	if len(resource.Attributes) != 1 || resource.Attributes[0].Key != "service.name" ||
+		*resource.Attributes[0].Value.StringValue != "minibb" {
+		t.Errorf("resource attributes %+v", resource.Attributes)
+	}
+
+	spans := c.spans()
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2: %+v", len(spans), spans)
+	}
+	server, internal := spans["GET /api/boards"], spans["ListBoards"]
+
+	if server.Kind != KindServer || internal.Kind != KindInternal {
+		t.Errorf("kinds %d and %d", server.Kind, internal.Kind)
+	}
+	if server.TraceID != parent.TraceID() || internal.TraceID != server.TraceID {
+		t.Errorf("trace IDs %s and %s, want %s", server.TraceID, internal.TraceID, parent.TraceID())
+	}
+	if server.ParentSpanID != "" || internal.ParentSpanID != server.SpanID {
+		t.Errorf("parent IDs %q and %q, want none and %s", server.ParentSpanID, internal.ParentSpanID, server.SpanID)
+	}
+	start, _ := strconv.ParseInt(server.StartTimeUnixNano, 10, 64)
+	end, _ := strconv.ParseInt(server.EndTimeUnixNano, 10, 64)
+	if start == 0 || end < start {
+		t.Errorf("span times %s to %s", server.StartTimeUnixNano, server.EndTimeUnixNano)
+	}
+
+	attrs := attributes(server)
+	if v := attrs["http.method"].StringValue; v == nil || *v != "GET" {
+		t.Errorf("http.method = %+v", attrs["http.method"])
+	}
+	if v := attrs["http.status_code"].IntValue; v == nil || *v != "500" {
+		t.Errorf("http.status_code = %+v", attrs["http.status_code"])
+	}
+	if server.Status != nil {
+		t.Errorf("server span status %+v", server.Status)
+	}
+	if internal.Status == nil || internal.Status.Code != 2 || internal.Status.Message != "database is locked" {
+		t.Errorf("internal span status %+v", internal.Status)
+	}
+}
+
+func TestRemoteParent(t *testing.T) {
+	c, shutdown := setup(t, DefaultConfig())
+
+	remote := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, span := StartKind(context.Background(), "GET", KindServer, remote)
+	span.End()
+	shutdown()
+
+	exported := c.spans()["GET"]
+	if exported.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || exported.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("span %+v does not continue the remote trace", exported)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	config := DefaultConfig()
+	config.SampleRatio = 0
+	c, shutdown := setup(t, config)
+	_, span := Start(context.Background(), "dropped")
+	span.End()
+
+	// A caller's decision is followed whatever the ratio
+	remote := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, span := StartKind(context.Background(), "kept", KindServer, remote)
+	_, child := Start(ctx, "kept child")
+	child.End()
+	span.End()
+	shutdown()
+
+	spans := c.spans()
+	if _, ok := spans["dropped"]; ok {
+		t.Error("unsampled span exported")
+	}
+	if _, ok := spans["kept"]; !ok {
+		t.Error("span sampled by the caller not exported")
+	}
+	if _, ok := spans["kept child"]; !ok {
+		t.Error("child of a sampled span not exported")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	shutdown := Setup(Config{})
+	defer shutdown(context.Background())
+
+	ctx, span := Start(context.Background(), "nothing")
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Fatal("span started without an endpoint")
+	}
+	// A nil span ignores every call
+	span.SetString("key", "value")
+	span.SetInt("key", 1)
+	span.SetName("name")
+	span.RecordError(errors.New("ignored"))
+	span.End()
+	if span.TraceID() != "" {
+		t.Error("nil span has a trace ID")
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header  string
+		valid   bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01", false, false},
+		{"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+	}
+	for _, tt := range tests {
+		sc := ParseTraceparent(tt.header)
+		if valid := sc.TraceID != ([16]byte{}); valid != tt.valid || sc.Sampled != tt.sampled {
+			t.Errorf("ParseTraceparent(%q) = %+v, want valid %v, sampled %v", tt.header, sc, tt.valid, tt.sampled)
+		}
+	}
+}
+
+func TestSampled(t *testing.T) {
+	var low, high [16]byte
+	for i := 8; i < 16; i++ {
+		high[i] = 0xff
+	}
+	if !sampled(low, 0.5) || sampled(high, 0.5) {
+		t.Error("sampling does not follow the trace ID")
+	}
+	if !sampled(high, 1) || sampled(low, 0) {
+		t.Error("ratios 0 and 1 must drop and keep every trace")
+	}
+}
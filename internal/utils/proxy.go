@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the peers whose X-Forwarded-For and X-Real-IP
+// headers name the client: networks, single addresses, and "unix" for
+// connections over a Unix socket, which have no peer address.
+type TrustedProxies struct {
+	unix     bool
+	networks []*net.IPNet
+}
+
+func ParseTrustedProxies(entries []string) (*TrustedProxies, error) {
+	p := &TrustedProxies{}
+	for _, entry := range entries {
+		if entry == "unix" {
+			p.unix = true
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			p.networks = append(p.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", entry)
+		}
+		p.networks = append(p.networks, network)
+	}
+	return p, nil
+}
+
+// Trusts reports whether the peer at ip is a trusted proxy. An empty ip
+// stands for a Unix socket peer.
+func (p *TrustedProxies) Trusts(ip string) bool {
+	if ip == "" {
+		return p.unix
+	}
+	parsed := net.ParseIP(ip)
+	for _, network := range p.networks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client address of a request. Behind trusted proxies
+// it is the last address in X-Forwarded-For that isn't a trusted proxy
+// itself, or X-Real-IP if there is no X-Forwarded-For. It is "" when the
+// peer has no address and isn't trusted.
+func (p *TrustedProxies) Resolve(r *http.Request) string {
+	client := ClientIP(r)
+	if !p.Trusts(client) {
+		return client
+	}
+
+	var forwarded []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(value, ",")...)
+	}
+	if len(forwarded) == 0 {
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+			return ip
+		}
+		return client
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(ip) == nil {
+			// Whatever comes before can't be trusted either
+			break
+		}
+		client = ip
+		if !p.Trusts(ip) {
+			break
+		}
+	}
+	return client
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, entries := range [][]string{
+		{"example.com"},
+		{"10.0.0.0/33"},
+		{"unix:/run/minibb.sock"},
+	} {
+		if _, err := ParseTrustedProxies(entries); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) succeeded", entries)
+		}
+	}
+
+	p, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1", "::1", "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.1.2.3":    true,
+		"192.0.2.1":   true,
+		"192.0.2.2":   false,
+		"::1":         true,
+		"2001:db8::1": false,
+		"":            true,
+	} {
+		if got := p.Trusts(ip); got != want {
+			t.Errorf("Trusts(%q) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    []string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{"direct", nil, "203.0.113.7:5555", []string{"198.51.100.1"}, "", "203.0.113.7"},
+		{"unix without proxy", nil, "@", nil, "", ""},
+		{"unix headers ignored", nil, "", []string{"198.51.100.1"}, "198.51.100.1", ""},
+		{"unix proxy", []string{"unix"}, "@", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"real ip", []string{"unix"}, "@", nil, "198.51.100.2", "198.51.100.2"},
+		{"forwarded wins", []string{"unix"}, "@", []string{"198.51.100.1"}, "198.51.100.2", "198.51.100.1"},
+		{"spoofed", []string{"10.0.0.0/8"}, "10.0.0.1:80", []string{"1.1.1.1, 198.51.100.1"}, "", "198.51.100.1"},
+		{"proxy chain", []string{"10.0.0.0/8"}, "10.0.0.1:80", []string{"198.51.100.1", "10.0.0.2"}, "", "198.51.100.1"},
+		{"all trusted", []string{"10.0.0.0/8"}, "10.0.0.1:80", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"garbage", []string{"10.0.0.0/8"}, "10.0.0.1:80", []string{"198.51.100.1, bogus"}, "", "10.0.0.1"},
+		{"untrusted tcp proxy", []string{"unix"}, "203.0.113.7:5555", []string{"198.51.100.1"}, "", "203.0.113.7"},
+		{"ipv6", []string{"::1"}, "[::1]:80", []string{"2001:db8::1"}, "", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseTrustedProxies(tt.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := p.Resolve(r); got != tt.want {
+				t.Errorf("Resolve = %q, want %q", got, tt.want)
+			}
+
+			// The server stores the result as the remote address
+			r.RemoteAddr = p.Resolve(r)
+			if got := ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP after resolving = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
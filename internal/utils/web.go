@@ -2,14 +2,22 @@ package utils
 
 import (
 	"encoding/json"
-	"log"
 	"math"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+
+	"minibb/internal/logging"
 )
 
+// RequestIDHeader carries the ID that ties a response to its log lines.
+const RequestIDHeader = "X-Request-ID"
+
 type APIError struct {
-	Detail string `json:"detail"`
+	Detail    string `json:"detail"`
+	RequestID string `json:"request_id,omitempty"`
 }
 
 func RespondWithJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -18,17 +26,68 @@ func RespondWithJSON(w http.ResponseWriter, status int, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
+// RespondWithError writes err as JSON. The request ID is taken from the
+// response header set by the server's middleware.
 func RespondWithError(w http.ResponseWriter, status int, err APIError) {
+	if err.RequestID == "" {
+		err.RequestID = w.Header().Get(RequestIDHeader)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(err)
 }
 
-func InternalServerError(w http.ResponseWriter, originalErr error) {
-	log.Printf("Internal server error: %v", originalErr)
+func InternalServerError(w http.ResponseWriter, r *http.Request, originalErr error) {
+	logging.FromContext(r.Context()).Error("internal server error", "error", originalErr)
 	RespondWithError(w, http.StatusInternalServerError, APIError{Detail: "encountered an unexpected internal failure on the backend server"})
 }
 
+// NotModified reports whether the request's If-None-Match or, without one,
+// If-Modified-Since shows that the client's copy of a response with the
+// given validators is current.
+func NotModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		return etagMatches(match, etag)
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// etagMatches applies the weak comparison of If-None-Match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func DecodeJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// ClientIP returns the address of the client, as resolved by the server
+// from the headers of trusted proxies. It is "" when the peer has no
+// address, as over a Unix socket with no trusted proxy configured, so
+// that such clients are not all taken for one.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if net.ParseIP(host) == nil {
+		return ""
+	}
+	return host
+}
+
 type PaginationParams struct {
 	Page    int `json:"page"`
 	PerPage int `json:"per_page"`